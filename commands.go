@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sogko/slumber/middlewares/migrations"
+	"github.com/sogko/slumber/server"
+	"github.com/sogko/slumber/test_helpers"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %v [command]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  serve       run the server (default)")
+	fmt.Fprintln(os.Stderr, "  routes      print the routes added to the router")
+	fmt.Fprintln(os.Stderr, "  acl-matrix  print which fixture users are allowed each route, see `acl-matrix -h`")
+	fmt.Fprintln(os.Stderr, "  migrate     apply, revert or print the status of database migrations, see `migrate -h`")
+	flag.PrintDefaults()
+}
+
+// printRoutes prints the routes added to the router as a table
+func printRoutes(w io.Writer, router *server.Router) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMETHOD\tPATTERN\tVERSIONS\tDEFAULT\tACL")
+	for _, info := range router.RouteInfos() {
+		acl := "no"
+		if info.HasACLHandler {
+			acl = "yes"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", info.Name, info.Method, info.Pattern,
+			strings.Join(info.Versions, ","), info.DefaultVersion, acl)
+	}
+	tw.Flush()
+}
+
+// runACLMatrix prints the ACL matrix of routes by fixture users, or asserts it against a golden file
+func runACLMatrix(w io.Writer, args []string, router *server.Router, ac *server.AccessController) error {
+	flags := flag.NewFlagSet("acl-matrix", flag.ExitOnError)
+	usersFile := flags.String("users", "", "JSON file of fixture users, for eg: [{\"name\": \"admin\", \"roles\": [\"admin\"]}]")
+	golden := flags.String("golden", "", "golden file to assert the matrix against")
+	update := flags.Bool("update", false, "write the matrix to the golden file instead of asserting it")
+	format := flags.String("format", "table", "output format: table or json")
+	flags.Parse(args)
+
+	users := []*test_helpers.ACLFixtureUser{}
+	if *usersFile != "" {
+		var err error
+		if users, err = test_helpers.LoadACLFixtureUsersFile(*usersFile); err != nil {
+			return err
+		}
+	}
+	matrix := test_helpers.NewACLMatrix(router, ac, users)
+
+	if *golden != "" {
+		if err := matrix.AssertGoldenFile(*golden, *update); err != nil {
+			return err
+		}
+	}
+	switch *format {
+	case "table":
+		matrix.WriteTable(w)
+	case "json":
+		return matrix.WriteJSON(w)
+	default:
+		return errors.New(fmt.Sprintf("Unknown format: %v", *format))
+	}
+	return nil
+}
+
+// runMigrate applies or reverts the migrations, or prints their status
+func runMigrate(w io.Writer, args []string, migrator *migrations.Migrator) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: migrate [up|down|status] [-to version] [-all]")
+		fmt.Fprintln(os.Stderr, "  up      apply the pending migrations (default)")
+		fmt.Fprintln(os.Stderr, "  down    revert the last applied migration, or every one with -all")
+		fmt.Fprintln(os.Stderr, "  status  print the migrations and when they were applied")
+		flags.PrintDefaults()
+	}
+	to := flags.Int64("to", 0, "version to migrate up or down to, instead of every pending migration or the last applied one")
+	all := flags.Bool("all", false, "revert every applied migration, with down")
+	flags.Parse(args)
+	command := flags.Arg(0)
+	if flags.NArg() > 1 {
+		// allow flags after the command, for eg: `migrate down -to 20150610000000`
+		flags.Parse(flags.Args()[1:])
+	}
+
+	if *all && (command != "down" || *to != 0) {
+		return errors.New("Invalid migrate flags, -all only reverts every migration with `down` and without -to")
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "", "up":
+		applied, err := migrator.Up(ctx, *to)
+		for _, migration := range applied {
+			fmt.Fprintf(w, "applied   %v  %v\n", migration.Version, migration.Description)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+		return err
+	case "down":
+		target := *to
+		if target == 0 && !*all {
+			// revert the last applied migration only
+			var err error
+			if target, err = migrator.PreviousVersion(ctx); err != nil {
+				return err
+			}
+		}
+		reverted, err := migrator.Down(ctx, target)
+		for _, migration := range reverted {
+			fmt.Fprintf(w, "reverted  %v  %v\n", migration.Version, migration.Description)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(w, "no applied migrations")
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Record != nil {
+				applied = status.Record.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\n", status.Version, status.Description, applied)
+		}
+		tw.Flush()
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unknown migrate command: %v", command))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	"github.com/sogko/slumber/middlewares/mongodb"
+	"github.com/sogko/slumber/middlewares/sqldb"
+)
+
+// Database backends, selected with the `-database` flag
+const (
+	DatabaseMongoDB  = "mongodb"
+	DatabaseBolt     = "bolt"
+	DatabasePostgres = "postgres"
+	DatabaseSQLite   = "sqlite"
+)
+
+// newDatabase Returns the database backend and a function that connects to it.
+// url is the server name for MongoDB, the file path for Bolt and SQLite or the connection string for PostgreSQL;
+// an empty url uses the default of the backend.
+func newDatabase(backend string, url string) (domain.IDatabase, func() error, error) {
+	switch backend {
+	case DatabaseMongoDB:
+		if url == "" {
+			url = "localhost"
+		}
+		db := mongodb.New(&mongodb.Options{
+			ServerName:   url,
+			DatabaseName: "test-go-app",
+		})
+		return db, func() error {
+			_ = db.NewSession()
+			return nil
+		}, nil
+	case DatabaseBolt:
+		if url == "" {
+			url = "slumber.db"
+		}
+		db := boltdb.New(&boltdb.Options{
+			Path: url,
+		})
+		return db, db.Open, nil
+	case DatabasePostgres:
+		if url == "" {
+			url = "postgres://localhost/slumber?sslmode=disable"
+		}
+		db := sqldb.New(&sqldb.Options{
+			DriverName:     "postgres",
+			DataSourceName: url,
+		})
+		return db, db.Open, nil
+	case DatabaseSQLite:
+		if url == "" {
+			url = "slumber.sqlite"
+		}
+		db := sqldb.New(&sqldb.Options{
+			DriverName:     "sqlite3",
+			DataSourceName: url,
+		})
+		return db, db.Open, nil
+	}
+	return nil, nil, errors.New(fmt.Sprintf("Unknown database `%v`, expected `%v`, `%v`, `%v` or `%v`",
+		backend, DatabaseMongoDB, DatabaseBolt, DatabasePostgres, DatabaseSQLite))
+}
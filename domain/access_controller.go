@@ -8,6 +8,52 @@ type ACLHandlerFunc func(*http.Request, IUser) (bool, string)
 
 type ACLMap map[string]ACLHandlerFunc
 
+// ACLEffect is the outcome of an ACL decision
+type ACLEffect string
+
+const (
+	ACLAllow ACLEffect = "allow"
+	ACLDeny  ACLEffect = "deny"
+	// ACLRequireAuthentication denies the request until the user authenticates (401 instead of 403)
+	ACLRequireAuthentication ACLEffect = "require-authentication"
+)
+
+// ACLDecision type
+// Scheme and Realm are used for the WWW-Authenticate challenge of ACLRequireAuthentication decisions,
+// the access controller defaults are used if empty.
+type ACLDecision struct {
+	Effect  ACLEffect
+	Message string
+	Scheme  string
+	Realm   string
+}
+
+// ACLDecisionHandlerFunc is an ACL handler that returns a richer decision than ACLHandlerFunc
+type ACLDecisionHandlerFunc func(*http.Request, IUser) ACLDecision
+
+// NewACLDecision Returns the ACLDecision of an ACLHandlerFunc result
+func NewACLDecision(result bool, message string) ACLDecision {
+	if result {
+		return ACLDecision{Effect: ACLAllow, Message: message}
+	}
+	return ACLDecision{Effect: ACLDeny, Message: message}
+}
+
+// RequireAuthentication Returns an ACLDecisionHandlerFunc that requires authentication when the ACLHandlerFunc
+// denies a request without user; the access controller decides with ACL handlers this way
+func RequireAuthentication(handler ACLHandlerFunc) ACLDecisionHandlerFunc {
+	return func(req *http.Request, user IUser) ACLDecision {
+		decision := NewACLDecision(handler(req, user))
+		if decision.Effect == ACLDeny && user == nil {
+			decision.Effect = ACLRequireAuthentication
+		}
+		return decision
+	}
+}
+
+// ResourceACLHandlerFunc decides if the user can perform an action on a resource loaded by the handler
+type ResourceACLHandlerFunc func(req *http.Request, user IUser, resource interface{}) (bool, string)
+
 func (m *ACLMap) Append(maps ...*ACLMap) ACLMap {
 	res := ACLMap{}
 	// copy current map
@@ -24,9 +70,14 @@ func (m *ACLMap) Append(maps ...*ACLMap) ACLMap {
 
 type IAccessController interface {
 	Add(*ACLMap)
-	AddHandler(name string, handler ACLHandlerFunc)
+	AddHandler(name string, handler ACLHandlerFunc) error
+	AddDecisionHandler(name string, handler ACLDecisionHandlerFunc) error
 	HasAction(string) bool
 	IsHTTPRequestAuthorized(req *http.Request, ctx IContext, action string, user IUser) (bool, string)
 	NewContextHandler(string, http.HandlerFunc) http.HandlerFunc
+	AddResourceHandler(action string, handler ResourceACLHandlerFunc) error
+	AddFieldHandler(action string, field string, handler ResourceACLHandlerFunc) error
+	Authorize(req *http.Request, action string, user IUser, resource interface{}) (bool, string)
+	FilterFields(req *http.Request, action string, user IUser, resource interface{}) interface{}
 	//	Render(w http.ResponseWriter, req *http.Request, status int, v interface{})
 }
@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Accumulator operators of a Group stage
+const (
+	AccumulatorSum   = "sum"
+	AccumulatorAvg   = "avg"
+	AccumulatorMin   = "min"
+	AccumulatorMax   = "max"
+	AccumulatorCount = "count"
+)
+
+// Accumulator type computes a field of the groups of a Group stage from the values of a field of their documents.
+// `sum` and `avg` ignore values that are not numbers, `min` and `max` ignore missing values and `count` has no field.
+type Accumulator struct {
+	Operator string
+	Field    string
+}
+
+// Sum Returns an Accumulator of the sum of the values of a field
+func Sum(field string) Accumulator {
+	return Accumulator{AccumulatorSum, field}
+}
+
+// Avg Returns an Accumulator of the average of the values of a field
+func Avg(field string) Accumulator {
+	return Accumulator{AccumulatorAvg, field}
+}
+
+// Min Returns an Accumulator of the minimum value of a field
+func Min(field string) Accumulator {
+	return Accumulator{AccumulatorMin, field}
+}
+
+// Max Returns an Accumulator of the maximum value of a field
+func Max(field string) Accumulator {
+	return Accumulator{AccumulatorMax, field}
+}
+
+// Count Returns an Accumulator of the number of documents
+func Count() Accumulator {
+	return Accumulator{AccumulatorCount, ""}
+}
+
+// Group type groups documents by the values of the By fields, or every document in a single group if By is empty.
+// The `_id` of a group is the value of the By field, or a document of the values of the By fields
+// keyed by field name with `.` replaced by `_`, for eg: `{"country": "SG", "profile_plan": "pro"}`.
+// Fields are the accumulators of the group, by output field name.
+type Group struct {
+	By     []string
+	Fields map[string]Accumulator
+}
+
+// Stage type is a stage of an aggregation pipeline, see IDatabase.Aggregate(); exactly one field is set.
+// Stages after a Group stage refer to the fields of the groups.
+type Stage struct {
+	Match   Query
+	Group   *Group
+	Sort    string
+	Limit   int
+	Project Projection
+}
+
+// MatchStage Returns a Stage that filters documents with a query
+func MatchStage(query Query) Stage {
+	return Stage{Match: query}
+}
+
+// GroupStage Returns a Stage that groups documents by the values of fields, see Group
+func GroupStage(by []string, fields map[string]Accumulator) Stage {
+	return Stage{Group: &Group{by, fields}}
+}
+
+// SortStage Returns a Stage that sorts documents by a sort string, see ParseSort()
+func SortStage(sort string) Stage {
+	return Stage{Sort: sort}
+}
+
+// LimitStage Returns a Stage that keeps the first n documents
+func LimitStage(n int) Stage {
+	return Stage{Limit: n}
+}
+
+// ProjectStage Returns a Stage that keeps only the given fields and `_id` of documents
+func ProjectStage(fields ...string) Stage {
+	return Stage{Project: Projection(fields)}
+}
+
+// GroupID Returns the `_id` field name of a Group.By field in a group of several fields, for eg: `profile_plan`
+func GroupID(field string) string {
+	return strings.Replace(field, ".", "_", -1)
+}
+
+// Validate Returns an error if the stage does not set exactly one field, or has an invalid group or limit
+func (stage Stage) Validate() error {
+	set := 0
+	if stage.Match != nil {
+		set++
+	}
+	if stage.Group != nil {
+		set++
+	}
+	if stage.Sort != "" {
+		set++
+	}
+	if stage.Limit != 0 {
+		set++
+	}
+	if stage.Project != nil {
+		set++
+	}
+	if set != 1 {
+		return errors.New("Invalid aggregation stage, expected exactly one of Match, Group, Sort, Limit or Project")
+	}
+	if stage.Project != nil && len(stage.Project) == 0 {
+		return errors.New("Invalid aggregation stage, no fields to project")
+	}
+	if stage.Limit < 0 {
+		return errors.New(fmt.Sprintf("Invalid aggregation stage, negative limit %v", stage.Limit))
+	}
+	if stage.Group == nil {
+		return nil
+	}
+	for name, accumulator := range stage.Group.Fields {
+		if name == "" || name == "_id" || strings.ContainsAny(name, ".$") {
+			return errors.New(fmt.Sprintf("Invalid group field name `%v`", name))
+		}
+		switch accumulator.Operator {
+		case AccumulatorSum, AccumulatorAvg, AccumulatorMin, AccumulatorMax:
+			if accumulator.Field == "" {
+				return errors.New(fmt.Sprintf("Invalid group field `%v`, %v requires a field", name, accumulator.Operator))
+			}
+		case AccumulatorCount:
+		default:
+			return errors.New(fmt.Sprintf("Invalid group field `%v`, unsupported accumulator `%v`", name, accumulator.Operator))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package domain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+)
+
+var _ = Describe("Aggregation Tests", func() {
+	Describe("Stage.Validate()", func() {
+		It("should accept stages that set one field", func() {
+			stages := []domain.Stage{
+				domain.MatchStage(domain.Query{"status": "active"}),
+				domain.GroupStage([]string{"country"}, map[string]domain.Accumulator{
+					"users": domain.Count(),
+					"total": domain.Sum("amount"),
+					"avg":   domain.Avg("amount"),
+					"first": domain.Min("createdAt"),
+					"last":  domain.Max("createdAt"),
+				}),
+				domain.SortStage("-users"),
+				domain.LimitStage(10),
+				domain.ProjectStage("users"),
+			}
+			for _, stage := range stages {
+				Expect(stage.Validate()).To(BeNil())
+			}
+		})
+		It("should return error for stages that do not set exactly one field", func() {
+			Expect(domain.Stage{}.Validate()).ToNot(BeNil())
+			Expect(domain.Stage{Sort: "name", Limit: 1}.Validate()).ToNot(BeNil())
+		})
+		It("should return error for invalid limits and projections", func() {
+			Expect(domain.LimitStage(-1).Validate()).ToNot(BeNil())
+			Expect(domain.ProjectStage().Validate()).ToNot(BeNil())
+		})
+		It("should return error for invalid group fields", func() {
+			for _, fields := range []map[string]domain.Accumulator{
+				{"_id": domain.Count()},
+				{"a.b": domain.Count()},
+				{"total": domain.Sum("")},
+				{"total": {Operator: "median", Field: "amount"}},
+			} {
+				Expect(domain.GroupStage(nil, fields).Validate()).ToNot(BeNil())
+			}
+		})
+	})
+	Describe("GroupID()", func() {
+		It("should replace dots of nested fields", func() {
+			Expect(domain.GroupID("country")).To(Equal("country"))
+			Expect(domain.GroupID("profile.plan")).To(Equal("profile_plan"))
+		})
+	})
+})
@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"time"
+)
+
+// RequestIDHeader is the header that identifies a request across services and audit entries
+const RequestIDHeader = "X-Request-ID"
+
+// AuditEntry records an authorization decision
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	UserID    string    `json:"userId,omitempty" bson:"userId,omitempty"`
+	Action    string    `json:"action" bson:"action"`
+	Method    string    `json:"method" bson:"method"`
+	Path      string    `json:"path" bson:"path"`
+	Allowed   bool      `json:"allowed" bson:"allowed"`
+	Message   string    `json:"message,omitempty" bson:"message,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	RequestID string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+}
+
+// IAuditSink stores audit entries
+type IAuditSink interface {
+	Write(entry *AuditEntry) error
+}
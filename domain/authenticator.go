@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"net/http"
+)
+
+// IAuthenticator authenticates requests with one authentication scheme
+type IAuthenticator interface {
+	// Scheme Returns the name of the authentication scheme, for eg: `bearer`, `apikey` or `basic`
+	Scheme() string
+	// Authenticate Returns the user of the request, or nil if the request does not carry credentials for the scheme.
+	// Returns an error if the credentials are invalid; an AuthenticationError defines the response.
+	Authenticate(req *http.Request) (IUser, error)
+}
+
+// IContextAuthenticator is implemented by authenticators that save more than the current user into the
+// request context, for eg: the API key of the request; the middleware that runs the authenticator calls
+// SetContext() with the user it returned.
+type IContextAuthenticator interface {
+	IAuthenticator
+	SetContext(ctx IContext, req *http.Request, user IUser)
+}
+
+// AuthenticationError type
+// Status is the response status code, for eg: 401 or 429, and Header the additional response headers,
+// for eg: a WWW-Authenticate challenge.
+type AuthenticationError struct {
+	Status  int
+	Message string
+	Header  http.Header
+}
+
+// NewAuthenticationError Returns a new AuthenticationError object answered with 401 and the challenge, if any
+func NewAuthenticationError(message string, challenge string) *AuthenticationError {
+	header := http.Header{}
+	if challenge != "" {
+		header.Set("WWW-Authenticate", challenge)
+	}
+	return &AuthenticationError{http.StatusUnauthorized, message, header}
+}
+
+// AuthenticationErrorOf Returns the AuthenticationError that defines the response of an error returned by
+// IAuthenticator.Authenticate(). Other errors, for eg: database errors, are answered with a 5xx status
+// and a generic message, so that they do not look like invalid credentials nor leak backend details.
+func AuthenticationErrorOf(err error) *AuthenticationError {
+	if authErr, ok := err.(*AuthenticationError); ok {
+		return authErr
+	}
+	status := DatabaseErrorStatus(err)
+	if status < http.StatusInternalServerError {
+		status = http.StatusInternalServerError
+	}
+	return &AuthenticationError{status, http.StatusText(status), http.Header{}}
+}
+
+func (err *AuthenticationError) Error() string {
+	return err.Message
+}
+
+// authenticationErrorResponse is the response body of an AuthenticationError
+type authenticationErrorResponse struct {
+	Message string `json:"message,omitempty"`
+	Success bool   `json:"success"`
+}
+
+// Write writes the error response with the renderer, or as plain text if renderer is nil
+func (err *AuthenticationError) Write(w http.ResponseWriter, req *http.Request, renderer IRenderer) {
+	for key, values := range err.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	status := err.Status
+	if status == 0 {
+		status = http.StatusUnauthorized
+	}
+	if renderer == nil {
+		http.Error(w, err.Message, status)
+		return
+	}
+	renderer.Render(w, req, status, authenticationErrorResponse{
+		Message: err.Message,
+		Success: false,
+	})
+}
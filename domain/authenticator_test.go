@@ -0,0 +1,25 @@
+package domain_test
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+var _ = Describe("Authenticator Tests", func() {
+	Describe("AuthenticationErrorOf()", func() {
+		It("should return authentication errors as-is", func() {
+			err := domain.NewAuthenticationError("Invalid API key", "ApiKey")
+			Expect(domain.AuthenticationErrorOf(err)).To(Equal(err))
+		})
+		It("should answer other errors with 5xx without their message", func() {
+			err := domain.AuthenticationErrorOf(errors.New("connection refused"))
+			Expect(err.Status).To(Equal(http.StatusInternalServerError))
+			Expect(err.Message).ToNot(ContainSubstring("connection refused"))
+			Expect(domain.AuthenticationErrorOf(domain.ErrTimeout).Status).To(Equal(http.StatusGatewayTimeout))
+			Expect(domain.AuthenticationErrorOf(domain.ErrNotFound).Status).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP Returns the IP address of the client that sent the request.
+// Proxy headers are only used if trustProxyHeaders is true, since clients can set them to any value.
+// The server is expected to run behind a single trusted proxy, which appends the address of its client
+// to `X-Forwarded-For`: the rightmost entry is used, the entries before it are sent by the client.
+func ClientIP(req *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if forwarded := strings.Join(req.Header["X-Forwarded-For"], ","); strings.TrimSpace(forwarded) != "" {
+			entries := strings.Split(forwarded, ",")
+			for i := len(entries) - 1; i >= 0; i-- {
+				if entry := strings.TrimSpace(entries[i]); entry != "" {
+					return entry
+				}
+			}
+		}
+		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,37 @@
+package domain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+var _ = Describe("ClientIP Tests", func() {
+	var request *http.Request
+
+	BeforeEach(func() {
+		request, _ = http.NewRequest("GET", "/api/users", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "192.168.0.1, 10.0.0.2")
+	})
+	It("should return the remote address without port", func() {
+		Expect(domain.ClientIP(request, false)).To(Equal("10.0.0.1"))
+		request.RemoteAddr = "10.0.0.1"
+		Expect(domain.ClientIP(request, false)).To(Equal("10.0.0.1"))
+	})
+	It("should only use proxy headers if trusted", func() {
+		Expect(domain.ClientIP(request, true)).To(Equal("10.0.0.2"))
+		request.Header.Del("X-Forwarded-For")
+		request.Header.Set("X-Real-IP", "192.168.0.2")
+		Expect(domain.ClientIP(request, true)).To(Equal("192.168.0.2"))
+	})
+	It("should ignore the forged leading entries of `X-Forwarded-For`", func() {
+		// the client sent `1.2.3.4` and the trusted proxy appended the address it received the request from
+		request.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7")
+		Expect(domain.ClientIP(request, true)).To(Equal("203.0.113.7"))
+		request.Header.Set("X-Forwarded-For", "1.2.3.4")
+		request.Header.Add("X-Forwarded-For", "203.0.113.8")
+		Expect(domain.ClientIP(request, true)).To(Equal("203.0.113.8"))
+	})
+})
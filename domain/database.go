@@ -1,25 +1,123 @@
 package domain
 
 import (
-	"gopkg.in/mgo.v2"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
 )
 
+// Query type is a document filter, for eg:
+//
+//	Query{"status": "active", "age": Query{"$gt": 30}, "$or": []Query{{"role": "admin"}, {"role": "owner"}}}
+//
+// Every IDatabase implementation supports:
+//
+//	equality                       {"field": value}, {"parent.child": value}
+//	comparison                     $eq, $ne, $gt, $gte, $lt, $lte
+//	membership                     $in, $nin
+//	existence                      $exists
+//	logical                        $and, $or (list of queries)
 type Query map[string]interface{}
-type Change mgo.Change
-type Index mgo.Index
+
+// Change type declares an update of a single document, see IDatabase.Update().
+// Update is either a document that replaces the matched document, or a query of update operators:
+//
+//	$set, $unset, $inc
+//
+// Upsert inserts the document if no document matches, Remove removes the matched document instead of updating it.
+// ReturnNew returns the updated document in the result instead of the document before the update.
+type Change struct {
+	Update    Query
+	Upsert    bool
+	Remove    bool
+	ReturnNew bool
+}
+
+// Index type declares an index of a collection, see IDatabase.EnsureIndex().
+// Fields are field names, prefixed with `-` for descending order.
+// Sparse indexes skip documents that do not have the indexed fields.
+// ExpireAfter removes documents once the time in the first field is older than the duration (TTL), if non-zero.
+type Index struct {
+	Name        string
+	Fields      []string
+	Unique      bool
+	Sparse      bool
+	ExpireAfter time.Duration
+}
+
+// SortField type is a field of a sort string, see ParseSort()
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// ParseSort Returns the fields of a sort string, a comma-separated list of field names prefixed
+// with `-` for descending order, for eg: `-createdAt,name`
+func ParseSort(sort string) []SortField {
+	fields := []SortField{}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		descending := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+		if field == "" {
+			continue
+		}
+		fields = append(fields, SortField{field, descending})
+	}
+	return fields
+}
+
+// Database errors
+// Every IDatabase implementation maps its errors to these, so that handlers do not depend on the backend.
+var ErrNotFound = errors.New("Not found")
+var ErrDuplicateKey = errors.New("Duplicate key")
+var ErrConflict = errors.New("Conflict")
+var ErrTimeout = errors.New("Timeout")
 
 // Database interface
+// Every method takes the context of the request. The context is checked before the operation starts, and
+// operations return ErrTimeout if its deadline is exceeded or context.Canceled if it is canceled; the deadline
+// also bounds the operation, but backends may not interrupt a running operation when the context is canceled.
 type IDatabase interface {
-	Insert(name string, obj interface{}) error
-	Update(name string, query Query, change Change, result interface{}) error
-	UpdateAll(name string, query Query, change Query) (int, error)
-	FindOne(name string, query Query, result interface{}) error
-	FindAll(name string, query Query, result interface{}, limit int, sort string) error
-	Count(name string, query Query) (int, error)
-	RemoveOne(name string, query Query) error
-	RemoveAll(name string, query Query) error
-	Exists(name string, query Query) bool
-	DropCollection(name string) error
-	DropDatabase() error
-	EnsureIndex(name string, index mgo.Index) error
+	Insert(ctx context.Context, name string, obj interface{}) error
+	Update(ctx context.Context, name string, query Query, change Change, result interface{}) error
+	UpdateAll(ctx context.Context, name string, query Query, change Query) (int, error)
+	FindOne(ctx context.Context, name string, query Query, result interface{}) error
+	FindOneProjection(ctx context.Context, name string, query Query, projection Projection, result interface{}) error
+	FindAll(ctx context.Context, name string, query Query, result interface{}, limit int, sort string) error
+	FindAllProjection(ctx context.Context, name string, query Query, projection Projection, result interface{}, limit int, sort string) error
+	FindPage(ctx context.Context, name string, query Query, result interface{}, sort string, page PageRequest) (*Page, error)
+	Count(ctx context.Context, name string, query Query) (int, error)
+	Aggregate(ctx context.Context, name string, pipeline []Stage, result interface{}) error
+	RemoveOne(ctx context.Context, name string, query Query) error
+	RemoveAll(ctx context.Context, name string, query Query) error
+	Exists(ctx context.Context, name string, query Query) bool
+	DropCollection(ctx context.Context, name string) error
+	DropDatabase(ctx context.Context) error
+	EnsureIndex(ctx context.Context, name string, index Index) error
+	// WithTransaction runs fn with a database whose operations are committed together if fn returns no error,
+	// or undone if fn returns an error, which WithTransaction returns.
+	// tx must not be used after fn returns, nor concurrently; nested calls run in the same transaction.
+	WithTransaction(ctx context.Context, fn func(tx IDatabase) error) error
+}
+
+// DatabaseErrorStatus Returns the HTTP status code for a database error, for eg: 404 for ErrNotFound
+func DatabaseErrorStatus(err error) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrInvalidCursor:
+		return http.StatusBadRequest
+	case ErrDuplicateKey, ErrConflict:
+		return http.StatusConflict
+	case ErrTimeout:
+		return http.StatusGatewayTimeout
+	case context.Canceled:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
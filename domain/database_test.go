@@ -0,0 +1,40 @@
+package domain_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+var _ = Describe("Database Tests", func() {
+	Describe("DatabaseErrorStatus()", func() {
+		It("should map database errors to HTTP status codes", func() {
+			Expect(domain.DatabaseErrorStatus(nil)).To(Equal(http.StatusOK))
+			Expect(domain.DatabaseErrorStatus(domain.ErrNotFound)).To(Equal(http.StatusNotFound))
+			Expect(domain.DatabaseErrorStatus(domain.ErrInvalidCursor)).To(Equal(http.StatusBadRequest))
+			Expect(domain.DatabaseErrorStatus(domain.ErrDuplicateKey)).To(Equal(http.StatusConflict))
+			Expect(domain.DatabaseErrorStatus(domain.ErrConflict)).To(Equal(http.StatusConflict))
+			Expect(domain.DatabaseErrorStatus(domain.ErrTimeout)).To(Equal(http.StatusGatewayTimeout))
+			Expect(domain.DatabaseErrorStatus(context.Canceled)).To(Equal(http.StatusServiceUnavailable))
+		})
+		It("should map other errors to internal server error", func() {
+			Expect(domain.DatabaseErrorStatus(errors.New("connection refused"))).To(Equal(http.StatusInternalServerError))
+		})
+	})
+	Describe("ParseSort()", func() {
+		It("should parse sort fields and directions", func() {
+			Expect(domain.ParseSort("-createdAt, name,+age")).To(Equal([]domain.SortField{
+				{"createdAt", true},
+				{"name", false},
+				{"age", false},
+			}))
+		})
+		It("should skip empty fields", func() {
+			Expect(domain.ParseSort("")).To(BeEmpty())
+			Expect(domain.ParseSort("name,,-")).To(Equal([]domain.SortField{{"name", false}}))
+		})
+	})
+})
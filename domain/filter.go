@@ -0,0 +1,234 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterType type is the type of the values of a filterable field, see FilterField
+type FilterType string
+
+// Filter types; time values are formatted as RFC 3339, for eg: `2015-06-09T15:04:05Z`
+const (
+	FilterString FilterType = "string"
+	FilterNumber FilterType = "number"
+	FilterBool   FilterType = "bool"
+	FilterTime   FilterType = "time"
+)
+
+// filterOperators are the operators allowed for each filter type, `eq` being the operator of `filter[field]=value`
+var filterOperators = map[FilterType][]string{
+	FilterString: {"eq", "ne", "in", "nin", "exists"},
+	FilterNumber: {"eq", "ne", "gt", "gte", "lt", "lte", "in", "nin", "exists"},
+	FilterBool:   {"eq", "ne", "exists"},
+	FilterTime:   {"eq", "ne", "gt", "gte", "lt", "lte", "exists"},
+}
+
+// FilterField type declares a field that can be filtered by a route, and whether it can also be sorted by
+type FilterField struct {
+	Type     FilterType
+	Sortable bool
+}
+
+// FilterFields type is the whitelist of fields of a route, by field name, for eg:
+//
+//	FilterFields{
+//		"status":  {FilterString, false},
+//		"age":     {FilterNumber, true},
+//		"created": {FilterTime, true},
+//	}
+type FilterFields map[string]FilterField
+
+// QueryParamError type is an invalid query parameter of a QueryError
+type QueryParamError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+// QueryError type is returned for invalid query parameters, answered with 400
+type QueryError struct {
+	Errors []QueryParamError
+}
+
+func (err *QueryError) Error() string {
+	messages := []string{}
+	for _, e := range err.Errors {
+		messages = append(messages, fmt.Sprintf("%v: %v", e.Param, e.Message))
+	}
+	return "Invalid query parameters; " + strings.Join(messages, "; ")
+}
+
+func (err *QueryError) add(param string, message string) {
+	err.Errors = append(err.Errors, QueryParamError{param, message})
+}
+
+// queryErrorResponse is the response body of a QueryError
+type queryErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  []QueryParamError `json:"errors"`
+	Success bool              `json:"success"`
+}
+
+// Write writes the error response with 400 with the renderer, or as plain text if renderer is nil
+func (err *QueryError) Write(w http.ResponseWriter, req *http.Request, renderer IRenderer) {
+	if renderer == nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	renderer.Render(w, req, http.StatusBadRequest, queryErrorResponse{
+		Message: "Invalid query parameters",
+		Errors:  err.Errors,
+		Success: false,
+	})
+}
+
+var filterParamRegexp = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([a-z]+)\])?$`)
+
+// ParseFilter Returns the Query and the sort string of the `filter` and `sort` query parameters of a request, for eg:
+//
+//	?filter[status]=active&filter[age][gt]=30&filter[role][in]=admin,owner&sort=-created,name
+//
+// Operators are `eq` (default), `ne`, `gt`, `gte`, `lt`, `lte`, `in` and `nin` (comma-separated values) and `exists`.
+// Fields and operators must be allowed by the fields whitelist, and values must be of the type of the field;
+// returns a *QueryError with every invalid parameter otherwise.
+// Other query parameters are ignored.
+func ParseFilter(req *http.Request, fields FilterFields) (Query, string, error) {
+	values := req.URL.Query()
+	params := []string{}
+	for param := range values {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	queryErr := &QueryError{}
+	conditions := map[string]Query{}
+	for _, param := range params {
+		if param != "filter" && !strings.HasPrefix(param, "filter[") {
+			continue
+		}
+		match := filterParamRegexp.FindStringSubmatch(param)
+		if match == nil {
+			queryErr.add(param, "Invalid filter, expected `filter[field]` or `filter[field][operator]`")
+			continue
+		}
+		name, operator := match[1], match[2]
+		if operator == "" {
+			operator = "eq"
+		}
+		field, ok := fields[name]
+		if !ok {
+			queryErr.add(param, fmt.Sprintf("Field `%v` can not be filtered", name))
+			continue
+		}
+		if !isFilterOperator(field.Type, operator) {
+			queryErr.add(param, fmt.Sprintf("Invalid operator `%v` for %v field `%v`, expected one of: %v",
+				operator, field.Type, name, strings.Join(filterOperators[field.Type], ", ")))
+			continue
+		}
+		if len(values[param]) > 1 {
+			queryErr.add(param, "Expected a single value, use the `in` operator to filter by several values")
+			continue
+		}
+		value, err := parseFilterValue(field.Type, operator, values[param][0])
+		if err != nil {
+			queryErr.add(param, err.Error())
+			continue
+		}
+		if conditions[name] == nil {
+			conditions[name] = Query{}
+		}
+		conditions[name]["$"+operator] = value
+	}
+
+	sortString, sortErr := parseFilterSort(values.Get("sort"), fields)
+	if sortErr != nil {
+		queryErr.add("sort", sortErr.Error())
+	}
+	if len(queryErr.Errors) > 0 {
+		return nil, "", queryErr
+	}
+
+	query := Query{}
+	for name, condition := range conditions {
+		if value, ok := condition["$eq"]; ok && len(condition) == 1 {
+			query[name] = value
+		} else {
+			query[name] = condition
+		}
+	}
+	return query, sortString, nil
+}
+
+func isFilterOperator(filterType FilterType, operator string) bool {
+	for _, op := range filterOperators[filterType] {
+		if op == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFilterValue(filterType FilterType, operator string, value string) (interface{}, error) {
+	switch operator {
+	case "exists":
+		return parseFilterScalar(FilterBool, value)
+	case "in", "nin":
+		list := []interface{}{}
+		for _, item := range strings.Split(value, ",") {
+			v, err := parseFilterScalar(filterType, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	return parseFilterScalar(filterType, value)
+}
+
+func parseFilterScalar(filterType FilterType, value string) (interface{}, error) {
+	switch filterType {
+	case FilterNumber:
+		if n, err := strconv.Atoi(value); err == nil {
+			return n, nil
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n, nil
+		}
+		return nil, errors.New(fmt.Sprintf("Invalid value `%v`, expected a number", value))
+	case FilterBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid value `%v`, expected `true` or `false`", value))
+		}
+		return b, nil
+	case FilterTime:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid value `%v`, expected a RFC 3339 time", value))
+		}
+		return t, nil
+	}
+	return value, nil
+}
+
+func parseFilterSort(sortString string, fields FilterFields) (string, error) {
+	sortFields := []string{}
+	for _, sortField := range ParseSort(sortString) {
+		if field, ok := fields[sortField.Field]; !ok || !field.Sortable {
+			return "", errors.New(fmt.Sprintf("Field `%v` can not be sorted by", sortField.Field))
+		}
+		if sortField.Descending {
+			sortFields = append(sortFields, "-"+sortField.Field)
+		} else {
+			sortFields = append(sortFields, sortField.Field)
+		}
+	}
+	return strings.Join(sortFields, ","), nil
+}
@@ -0,0 +1,92 @@
+package domain_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/renderer"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Filter Tests", func() {
+	fields := domain.FilterFields{
+		"status":  {domain.FilterString, false},
+		"age":     {domain.FilterNumber, true},
+		"admin":   {domain.FilterBool, false},
+		"created": {domain.FilterTime, true},
+		"name":    {domain.FilterString, true},
+	}
+	parse := func(url string) (domain.Query, string, error) {
+		req, _ := http.NewRequest("GET", url, nil)
+		return domain.ParseFilter(req, fields)
+	}
+
+	Describe("ParseFilter()", func() {
+		It("should parse filters and sort", func() {
+			query, sort, err := parse("/api/users?filter[status]=active&filter[age][gt]=30&sort=-created,name&limit=5")
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(domain.Query{
+				"status": "active",
+				"age":    domain.Query{"$gt": 30},
+			}))
+			Expect(sort).To(Equal("-created,name"))
+		})
+		It("should combine operators of the same field", func() {
+			query, _, err := parse("/api/users?filter[age][gte]=18&filter[age][lt]=65.5&filter[age][ne]=30")
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(domain.Query{
+				"age": domain.Query{"$gte": 18, "$lt": 65.5, "$ne": 30},
+			}))
+		})
+		It("should parse values of the type of the field", func() {
+			query, _, err := parse("/api/users?filter[admin]=true&filter[created][lt]=2015-06-09T15:04:05Z" +
+				"&filter[status][in]=active,pending&filter[name][exists]=false")
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(domain.Query{
+				"admin":   true,
+				"created": domain.Query{"$lt": time.Date(2015, 6, 9, 15, 4, 5, 0, time.UTC)},
+				"status":  domain.Query{"$in": []interface{}{"active", "pending"}},
+				"name":    domain.Query{"$exists": false},
+			}))
+		})
+		It("should return an empty query without filters", func() {
+			query, sort, err := parse("/api/users")
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(domain.Query{}))
+			Expect(sort).To(Equal(""))
+		})
+		It("should return a QueryError with every invalid parameter", func() {
+			_, _, err := parse("/api/users?filter[password]=x&filter[age][gt]=old&filter[admin][gt]=true" +
+				"&filter[status]=a&filter[status]=b&filter[name]]=x&sort=status")
+			Expect(err).ToNot(BeNil())
+			queryErr, ok := err.(*domain.QueryError)
+			Expect(ok).To(BeTrue())
+			params := []string{}
+			for _, e := range queryErr.Errors {
+				params = append(params, e.Param)
+			}
+			Expect(params).To(Equal([]string{
+				"filter[admin][gt]", "filter[age][gt]", "filter[name]]", "filter[password]", "filter[status]", "sort",
+			}))
+		})
+	})
+	Describe("QueryError.Write()", func() {
+		It("should answer with 400 and the invalid parameters", func() {
+			_, _, err := parse("/api/users?sort=unknown")
+			req, _ := http.NewRequest("GET", "/api/users?sort=unknown", nil)
+			recorder := httptest.NewRecorder()
+			err.(*domain.QueryError).Write(recorder, req, renderer.New(&renderer.Options{}, renderer.JSON))
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+			var body map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(BeNil())
+			Expect(body["success"]).To(BeFalse())
+			Expect(body["errors"]).To(Equal([]interface{}{
+				map[string]interface{}{"param": "sort", "message": "Field `unknown` can not be sorted by"},
+			}))
+		})
+	})
+})
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 )
 
@@ -29,3 +31,38 @@ type IMiddleware interface {
 type IContextMiddleware interface {
 	Handler(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc, ctx IContext)
 }
+
+// ComposeMiddlewares Returns a handler that runs the middlewares in the given order before calling handler.
+// Each middleware must be either an IMiddleware, an IContextMiddleware, a MiddlewareFunc or a ContextMiddlewareFunc.
+func ComposeMiddlewares(ctx IContext, middlewares []interface{}, handler http.HandlerFunc) (http.HandlerFunc, error) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next := handler
+		switch m := middlewares[i].(type) {
+		case MiddlewareFunc:
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				m(w, r, next)
+			}
+		case ContextMiddlewareFunc:
+			if ctx == nil {
+				return nil, errors.New("Context is required for ContextMiddlewareFunc")
+			}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				m(w, r, next, ctx)
+			}
+		case IMiddleware:
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				m.Handler(w, r, next)
+			}
+		case IContextMiddleware:
+			if ctx == nil {
+				return nil, errors.New(fmt.Sprintf("Context is required for IContextMiddleware %T", m))
+			}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				m.Handler(w, r, next, ctx)
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown middleware type %T", m))
+		}
+	}
+	return handler, nil
+}
@@ -86,4 +86,36 @@ var _ = Describe("Middleware Tests", func() {
 		})
 
 	})
+	Describe("ComposeMiddlewares()", func() {
+		handler := func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("handler"))
+		}
+		It("should run middlewares in order", func() {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/test", nil)
+			composed, err := domain.ComposeMiddlewares(context.New(), []interface{}{
+				&testGroupMiddleware{"a"},
+				&testGroupContextMiddleware{"b"},
+			}, handler)
+			Expect(err).To(BeNil())
+			composed(recorder, request)
+			Expect(recorder.Body.String()).To(Equal("a>b>handler"))
+		})
+		It("should return handler if there is no middlewares", func() {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/test", nil)
+			composed, err := domain.ComposeMiddlewares(nil, nil, handler)
+			Expect(err).To(BeNil())
+			composed(recorder, request)
+			Expect(recorder.Body.String()).To(Equal("handler"))
+		})
+		It("should return error for unknown middleware", func() {
+			_, err := domain.ComposeMiddlewares(context.New(), []interface{}{"not a middleware"}, handler)
+			Expect(err).ToNot(BeNil())
+		})
+		It("should return error for IContextMiddleware without context", func() {
+			_, err := domain.ComposeMiddlewares(nil, []interface{}{&testGroupContextMiddleware{"b"}}, handler)
+			Expect(err).ToNot(BeNil())
+		})
+	})
 })
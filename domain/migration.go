@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Migration type is a versioned step of the schema or the documents of the database, for eg: creating an index.
+// Version orders the migrations of every resource, and must be unique; use the date of the migration,
+// for eg: 20150610120000.
+// Up applies the migration and Down reverts it; a migration without Down can not be reverted.
+// Up and Down run in a transaction with the record of the migration, see IDatabase.WithTransaction(),
+// unless NoTransaction is set, for eg: to drop collections with MongoDB; they must only use the database they are given.
+type Migration struct {
+	Version       int64
+	Description   string
+	Up            func(ctx context.Context, db IDatabase) error
+	Down          func(ctx context.Context, db IDatabase) error
+	NoTransaction bool
+}
+
+// IMigrationSource is implemented by resources and stores that declare the migrations of their collections
+type IMigrationSource interface {
+	Migrations() []Migration
+}
+
+// Migrations type is a list of migrations
+type Migrations []Migration
+
+// Sorted Returns the migrations sorted by version.
+// Returns an error if a version is not positive or is not unique, or if a migration has no Up.
+func (migrations Migrations) Sorted() (Migrations, error) {
+	sorted := append(Migrations{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid migration `%v`, version must be positive", migration.Description))
+		}
+		if migration.Up == nil {
+			return nil, errors.New(fmt.Sprintf("Invalid migration %v, no Up function", migration.Version))
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, errors.New(fmt.Sprintf("Duplicate migration version %v: `%v` and `%v`",
+				migration.Version, sorted[i-1].Description, migration.Description))
+		}
+	}
+	return sorted, nil
+}
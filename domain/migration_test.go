@@ -0,0 +1,41 @@
+package domain_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+)
+
+var _ = Describe("Migration Tests", func() {
+	up := func(ctx context.Context, db domain.IDatabase) error {
+		return nil
+	}
+
+	Describe("Migrations.Sorted()", func() {
+		It("should sort migrations by version", func() {
+			migrations := domain.Migrations{
+				{Version: 20150612000000, Description: "second", Up: up},
+				{Version: 20150610000000, Description: "first", Up: up},
+			}
+			sorted, err := migrations.Sorted()
+			Expect(err).To(BeNil())
+			Expect(sorted[0].Description).To(Equal("first"))
+			Expect(sorted[1].Description).To(Equal("second"))
+			Expect(migrations[0].Description).To(Equal("second"))
+		})
+		It("should return error for duplicate versions", func() {
+			_, err := domain.Migrations{
+				{Version: 20150610000000, Description: "first", Up: up},
+				{Version: 20150610000000, Description: "other", Up: up},
+			}.Sorted()
+			Expect(err).ToNot(BeNil())
+		})
+		It("should return error for invalid migrations", func() {
+			_, err := domain.Migrations{{Version: 0, Description: "no version", Up: up}}.Sorted()
+			Expect(err).ToNot(BeNil())
+			_, err = domain.Migrations{{Version: 20150610000000, Description: "no up"}}.Sorted()
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
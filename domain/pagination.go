@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const DefaultPageLimit = 20
+const MaxPageLimit = 100
+
+// ErrInvalidCursor is returned by IDatabase.FindPage() for cursors that were not returned for the same sort
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// PageRequest type
+// Cursor is the opaque Page.NextCursor of the previous page, empty for the first page.
+// Fields is the projection of the documents of the page, see ParseProjection().
+type PageRequest struct {
+	Limit  int
+	Cursor string
+	Fields Projection
+}
+
+// Page type
+// It describes a page of results returned by IDatabase.FindPage()
+type Page struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// PageEnvelope type is the response body of a page of results
+type PageEnvelope struct {
+	Data interface{} `json:"data"`
+	Page *Page       `json:"page"`
+}
+
+// ParsePageRequest Returns the PageRequest of the `limit` and `cursor` query parameters of a request.
+// limit defaults to defaultLimit and returns an error if it is not a number between 1 and maxLimit.
+func ParsePageRequest(req *http.Request, defaultLimit int, maxLimit int) (PageRequest, error) {
+	page := PageRequest{Limit: defaultLimit, Cursor: req.URL.Query().Get("cursor")}
+	limit := req.URL.Query().Get("limit")
+	if limit == "" {
+		return page, nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > maxLimit {
+		return page, errors.New(fmt.Sprintf("Invalid limit `%v`, expected a number between 1 and %v", limit, maxLimit))
+	}
+	page.Limit = n
+	return page, nil
+}
+
+// NextURL Returns the URL of the next page of a request, or an empty string if there are no more results
+func (page *Page) NextURL(req *http.Request) string {
+	if page == nil || !page.HasMore || page.NextCursor == "" {
+		return ""
+	}
+	query := req.URL.Query()
+	query.Set("cursor", page.NextCursor)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	return req.URL.Path + "?" + query.Encode()
+}
+
+// SetPageLinkHeader sets the `Link` header of a response to the next page, for eg:
+//
+//	Link: </api/users?cursor=...&limit=20>; rel="next"
+func SetPageLinkHeader(w http.ResponseWriter, req *http.Request, page *Page) {
+	if next := page.NextURL(req); next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%v>; rel="next"`, next))
+	}
+}
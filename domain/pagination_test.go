@@ -0,0 +1,47 @@
+package domain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Pagination Tests", func() {
+	Describe("ParsePageRequest()", func() {
+		It("should parse limit and cursor", func() {
+			req, _ := http.NewRequest("GET", "/api/users?limit=5&cursor=abc", nil)
+			page, err := domain.ParsePageRequest(req, domain.DefaultPageLimit, domain.MaxPageLimit)
+			Expect(err).To(BeNil())
+			Expect(page).To(Equal(domain.PageRequest{Limit: 5, Cursor: "abc"}))
+		})
+		It("should default to the default limit", func() {
+			req, _ := http.NewRequest("GET", "/api/users", nil)
+			page, err := domain.ParsePageRequest(req, 10, 50)
+			Expect(err).To(BeNil())
+			Expect(page).To(Equal(domain.PageRequest{Limit: 10}))
+		})
+		It("should return error for invalid limits", func() {
+			for _, limit := range []string{"0", "-1", "101", "ten"} {
+				req, _ := http.NewRequest("GET", "/api/users?limit="+limit, nil)
+				_, err := domain.ParsePageRequest(req, domain.DefaultPageLimit, domain.MaxPageLimit)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+	})
+	Describe("SetPageLinkHeader()", func() {
+		It("should link to the next page, keeping other query parameters", func() {
+			req, _ := http.NewRequest("GET", "/api/users?status=active&limit=5", nil)
+			recorder := httptest.NewRecorder()
+			domain.SetPageLinkHeader(recorder, req, &domain.Page{Limit: 5, NextCursor: "abc", HasMore: true})
+			Expect(recorder.Header().Get("Link")).To(Equal(`</api/users?cursor=abc&limit=5&status=active>; rel="next"`))
+		})
+		It("should not link to the next page of the last page", func() {
+			req, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder := httptest.NewRecorder()
+			domain.SetPageLinkHeader(recorder, req, &domain.Page{Limit: 5})
+			Expect(recorder.Header().Get("Link")).To(Equal(""))
+		})
+	})
+})
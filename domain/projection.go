@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Projection type lists the fields of documents to load or render, for eg: `name`, `profile.email`.
+// An empty Projection selects whole documents.
+// Databases always load `_id`, so that loaded documents can still be identified.
+type Projection []string
+
+// ParseProjection Returns the Projection of the `fields` query parameter of a request, for eg: `?fields=name,email`.
+// If allowed fields are given, returns an error for fields that are not allowed.
+func ParseProjection(req *http.Request, allowed ...string) (Projection, error) {
+	projection := Projection{}
+	for _, field := range strings.Split(req.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if len(allowed) > 0 && !isAllowedField(field, allowed) {
+			return nil, errors.New(fmt.Sprintf("Invalid fields, `%v` is not one of: %v", field, strings.Join(allowed, ", ")))
+		}
+		projection = append(projection, field)
+	}
+	return projection, nil
+}
+
+func isAllowedField(field string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == field || strings.HasPrefix(field, a+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter Returns the JSON representation of v, an object or a list of objects, with only the projected fields.
+// Returns v unchanged if the projection is empty or if v is not an object or a list of objects.
+func (projection Projection) Filter(v interface{}) interface{} {
+	if len(projection) == 0 || v == nil {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return v
+	}
+	switch decoded := decoded.(type) {
+	case map[string]interface{}:
+		return projection.filterObject(decoded)
+	case []interface{}:
+		list := make([]interface{}, len(decoded))
+		for i, item := range decoded {
+			if object, ok := item.(map[string]interface{}); ok {
+				list[i] = projection.filterObject(object)
+			} else {
+				list[i] = item
+			}
+		}
+		return list
+	}
+	return v
+}
+
+func (projection Projection) filterObject(object map[string]interface{}) map[string]interface{} {
+	filtered := map[string]interface{}{}
+	for _, field := range projection {
+		keys := strings.Split(field, ".")
+		source, target := object, filtered
+		for i, key := range keys {
+			value, ok := source[key]
+			if !ok {
+				break
+			}
+			if i == len(keys)-1 {
+				target[key] = value
+				break
+			}
+			child, ok := value.(map[string]interface{})
+			if !ok {
+				break
+			}
+			if _, ok := target[key].(map[string]interface{}); !ok {
+				target[key] = map[string]interface{}{}
+			}
+			source, target = child, target[key].(map[string]interface{})
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,73 @@
+package domain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+type projectionProfile struct {
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+type projectionUser struct {
+	ID      string            `json:"_id"`
+	Name    string            `json:"name"`
+	Status  string            `json:"status"`
+	Profile projectionProfile `json:"profile"`
+}
+
+var _ = Describe("Projection Tests", func() {
+	Describe("ParseProjection()", func() {
+		It("should parse the fields query parameter", func() {
+			req, _ := http.NewRequest("GET", "/api/users?fields=name,%20profile.email,", nil)
+			projection, err := domain.ParseProjection(req)
+			Expect(err).To(BeNil())
+			Expect(projection).To(Equal(domain.Projection{"name", "profile.email"}))
+		})
+		It("should return an empty projection without fields", func() {
+			req, _ := http.NewRequest("GET", "/api/users", nil)
+			projection, err := domain.ParseProjection(req, "name")
+			Expect(err).To(BeNil())
+			Expect(projection).To(BeEmpty())
+		})
+		It("should allow nested fields of allowed fields", func() {
+			req, _ := http.NewRequest("GET", "/api/users?fields=name,profile.email", nil)
+			projection, err := domain.ParseProjection(req, "name", "profile")
+			Expect(err).To(BeNil())
+			Expect(projection).To(Equal(domain.Projection{"name", "profile.email"}))
+		})
+		It("should return error for fields that are not allowed", func() {
+			req, _ := http.NewRequest("GET", "/api/users?fields=name,password", nil)
+			_, err := domain.ParseProjection(req, "name", "profile")
+			Expect(err).ToNot(BeNil())
+		})
+	})
+	Describe("Projection.Filter()", func() {
+		user := projectionUser{"1", "John", "active", projectionProfile{"john@example.com", "555"}}
+
+		It("should keep only the projected fields of an object", func() {
+			filtered := domain.Projection{"name", "profile.email"}.Filter(user)
+			Expect(filtered).To(Equal(map[string]interface{}{
+				"name":    "John",
+				"profile": map[string]interface{}{"email": "john@example.com"},
+			}))
+		})
+		It("should keep only the projected fields of a list of objects", func() {
+			filtered := domain.Projection{"_id", "status"}.Filter([]projectionUser{user, user})
+			Expect(filtered).To(Equal([]interface{}{
+				map[string]interface{}{"_id": "1", "status": "active"},
+				map[string]interface{}{"_id": "1", "status": "active"},
+			}))
+		})
+		It("should ignore fields that do not exist", func() {
+			filtered := domain.Projection{"name", "name.first", "missing"}.Filter(&user)
+			Expect(filtered).To(Equal(map[string]interface{}{"name": "John"}))
+		})
+		It("should return the value unchanged for an empty projection", func() {
+			Expect(domain.Projection{}.Filter(user)).To(Equal(user))
+		})
+	})
+})
@@ -0,0 +1,42 @@
+package domain
+
+// RouteParameter describes a path, query or header parameter of a route
+// Type is a sample value of the parameter type, for eg: `""` or `0`; defaults to string
+type RouteParameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Type        interface{}
+}
+
+// RouteResponse describes a response of a route
+// Body is a sample value of the response body type, for eg: `User{}`
+type RouteResponse struct {
+	Description string
+	Body        interface{}
+}
+
+// RouteDoc type describes a route for generated API documents.
+// Request is a sample value of the request body type, for eg: `CreateUserRequest{}`.
+// Versions overrides the documentation of the route for the specified handler versions.
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Parameters  []RouteParameter
+	Request     interface{}
+	Responses   map[int]RouteResponse
+	Versions    map[RouteHandlerVersion]*RouteDoc
+}
+
+// ForVersion Returns the documentation for the specified route handler version
+func (doc *RouteDoc) ForVersion(version RouteHandlerVersion) *RouteDoc {
+	if doc == nil {
+		return nil
+	}
+	if d, ok := doc.Versions[version]; ok && d != nil {
+		return d
+	}
+	return doc
+}
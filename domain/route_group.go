@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RouteGroup type
+// A group applies its Prefix, Middlewares, ACLHandler and DefaultVersion to its Routes,
+// the routes of its Resources and its nested Groups.
+// ACLHandler and DefaultVersion are defaults; a route keeps its own (or its ACLDecisionHandler) if it is defined.
+// Middlewares is an ordered list of IMiddleware or IContextMiddleware prepended to the route middlewares,
+// outer groups' middlewares runs first.
+type RouteGroup struct {
+	Prefix         string
+	Middlewares    []interface{}
+	ACLHandler     ACLHandlerFunc
+	DefaultVersion RouteHandlerVersion
+	Routes         Routes
+	Resources      []IResource
+	Groups         []RouteGroup
+}
+
+// Flatten Returns the routes of the group and its nested groups, with the group settings applied
+func (g *RouteGroup) Flatten() (Routes, error) {
+	return g.flatten(&RouteGroup{})
+}
+
+func (g *RouteGroup) flatten(parent *RouteGroup) (Routes, error) {
+	// inherit parent settings
+	group := RouteGroup{
+		Prefix:         joinRoutePattern(parent.Prefix, g.Prefix),
+		Middlewares:    append(append([]interface{}{}, parent.Middlewares...), g.Middlewares...),
+		ACLHandler:     g.ACLHandler,
+		DefaultVersion: g.DefaultVersion,
+	}
+	if group.ACLHandler == nil {
+		group.ACLHandler = parent.ACLHandler
+	}
+	if group.DefaultVersion == "" {
+		group.DefaultVersion = parent.DefaultVersion
+	}
+
+	routes := g.Routes.Append()
+	for _, resource := range g.Resources {
+		if resource.Routes() == nil {
+			return nil, errors.New(fmt.Sprintf("Routes definition missing: %v", resource))
+		}
+		routes = routes.Append(resource.Routes())
+	}
+
+	res := Routes{}
+	for _, route := range routes {
+		res = append(res, group.apply(route))
+	}
+	for _, child := range g.Groups {
+		childRoutes, err := child.flatten(&group)
+		if err != nil {
+			return nil, err
+		}
+		res = res.Append(&childRoutes)
+	}
+	return res, nil
+}
+
+// apply Returns a copy of the route with the group settings applied
+func (g *RouteGroup) apply(route Route) Route {
+	route.Pattern = joinRoutePattern(g.Prefix, route.Pattern)
+	if route.ACLHandler == nil && route.ACLDecisionHandler == nil {
+		route.ACLHandler = g.ACLHandler
+	}
+	if route.DefaultVersion == "" {
+		route.DefaultVersion = g.DefaultVersion
+	}
+	route.Middlewares = append(append([]interface{}{}, g.Middlewares...), route.Middlewares...)
+	return route
+}
+
+// joinRoutePattern joins a group prefix and a route pattern, for eg: `/api` + `/users` => `/api/users`
+func joinRoutePattern(prefix string, pattern string) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if pattern == "" || pattern == "/" {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	return prefix + pattern
+}
@@ -0,0 +1,146 @@
+package domain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/test_helpers"
+	"net/http"
+	"net/http/httptest"
+)
+
+type testGroupMiddleware struct {
+	name string
+}
+
+func (m *testGroupMiddleware) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	w.Write([]byte(m.name + ">"))
+	next(w, req)
+}
+
+type testGroupContextMiddleware struct {
+	name string
+}
+
+func (m *testGroupContextMiddleware) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+	w.Write([]byte(m.name + ">"))
+	next(w, req)
+}
+
+var _ = Describe("RouteGroup Tests", func() {
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("handler"))
+	}
+	groupAcl := func(req *http.Request, user domain.IUser) (bool, string) {
+		return false, "group"
+	}
+	routeAcl := func(req *http.Request, user domain.IUser) (bool, string) {
+		return false, "route"
+	}
+	serve := func(route domain.Route) string {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(route.Method, route.Pattern, nil)
+		route.RouteHandlers[route.DefaultVersion](recorder, request)
+		return recorder.Body.String()
+	}
+
+	Describe("Flatten()", func() {
+		var group domain.RouteGroup
+		BeforeEach(func() {
+			group = domain.RouteGroup{
+				Prefix:         "/api/",
+				Middlewares:    []interface{}{&testGroupMiddleware{"a"}},
+				ACLHandler:     groupAcl,
+				DefaultVersion: "0.1",
+				Routes: domain.Routes{
+					domain.Route{
+						Name:          "Root",
+						Method:        "GET",
+						Pattern:       "/",
+						RouteHandlers: domain.RouteHandlers{"0.1": handler},
+					},
+					domain.Route{
+						Name:           "Users",
+						Method:         "GET",
+						Pattern:        "/users",
+						DefaultVersion: "0.2",
+						RouteHandlers:  domain.RouteHandlers{"0.2": handler},
+						ACLHandler:     routeAcl,
+					},
+				},
+				Groups: []domain.RouteGroup{
+					domain.RouteGroup{
+						Prefix:      "/admin",
+						Middlewares: []interface{}{&testGroupContextMiddleware{"b"}},
+						Routes: domain.Routes{
+							domain.Route{
+								Name:          "Admins",
+								Method:        "GET",
+								Pattern:       "/admins",
+								RouteHandlers: domain.RouteHandlers{"0.1": handler},
+							},
+						},
+					},
+				},
+			}
+		})
+		It("should apply prefix to routes and nested groups", func() {
+			routes, err := group.Flatten()
+			Expect(err).To(BeNil())
+			Expect(routes).To(HaveLen(3))
+			Expect(routes[0].Pattern).To(Equal("/api"))
+			Expect(routes[1].Pattern).To(Equal("/api/users"))
+			Expect(routes[2].Pattern).To(Equal("/api/admin/admins"))
+		})
+		It("should apply default ACL handler and version", func() {
+			routes, _ := group.Flatten()
+			_, message := routes[0].ACLHandler(nil, nil)
+			Expect(message).To(Equal("group"))
+			Expect(routes[0].DefaultVersion).To(Equal(domain.RouteHandlerVersion("0.1")))
+
+			_, message = routes[1].ACLHandler(nil, nil)
+			Expect(message).To(Equal("route"))
+			Expect(routes[1].DefaultVersion).To(Equal(domain.RouteHandlerVersion("0.2")))
+
+			_, message = routes[2].ACLHandler(nil, nil)
+			Expect(message).To(Equal("group"))
+			Expect(routes[2].DefaultVersion).To(Equal(domain.RouteHandlerVersion("0.1")))
+		})
+		It("should prepend middlewares in order", func() {
+			a := group.Middlewares[0]
+			b := group.Groups[0].Middlewares[0]
+			c := &testGroupMiddleware{"c"}
+			group.Groups[0].Routes[0].Middlewares = []interface{}{c}
+
+			routes, _ := group.Flatten()
+			Expect(routes[0].Middlewares).To(Equal([]interface{}{a}))
+			Expect(routes[2].Middlewares).To(Equal([]interface{}{a, b, c}))
+		})
+		It("should not modify the group routes", func() {
+			group.Flatten()
+			Expect(group.Routes[0].Pattern).To(Equal("/"))
+			Expect(group.Routes[0].Middlewares).To(BeEmpty())
+			Expect(group.Routes[0].DefaultVersion).To(BeEmpty())
+			Expect(serve(group.Routes[1])).To(Equal("handler"))
+		})
+		It("should include routes of resources", func() {
+			ctx := context.New()
+			group.Resources = []domain.IResource{
+				test_helpers.NewTestResource(ctx, nil, &test_helpers.TestResourceOptions{}),
+			}
+			routes, err := group.Flatten()
+			Expect(err).To(BeNil())
+			Expect(routes).To(HaveLen(5))
+			Expect(routes[2].Pattern).To(Equal("/api/api/test"))
+		})
+		It("should return error for resources without routes", func() {
+			ctx := context.New()
+			group.Resources = []domain.IResource{
+				test_helpers.NewTestResource(ctx, nil, &test_helpers.TestResourceOptions{NilRoutes: true}),
+			}
+			_, err := group.Flatten()
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -8,16 +8,32 @@ type RouteHandlerVersion string
 // RouteHandlers is a map of route version to its handler
 type RouteHandlers map[RouteHandlerVersion]http.HandlerFunc
 
+// MiddlewaresPosition defines where the route middlewares are composed, relative to the ACL check
+type MiddlewaresPosition int
+
+const (
+	// MiddlewaresInsideACL runs route middlewares only after the request has been authorized (default)
+	MiddlewaresInsideACL MiddlewaresPosition = iota
+	// MiddlewaresOutsideACL runs route middlewares before the ACL check
+	MiddlewaresOutsideACL
+)
+
 // Route type
 // Note that DefaultVersion must exists in RouteHandlers map
+// ACLDecisionHandler can be used instead of ACLHandler to return richer decisions, for eg: to require authentication
+// Middlewares is an ordered list of IMiddleware or IContextMiddleware composed around the versioned handler
 // See routes.go for examples
 type Route struct {
-	Name           string
-	Method         string
-	Pattern        string
-	DefaultVersion RouteHandlerVersion
-	RouteHandlers  RouteHandlers
-	ACLHandler     ACLHandlerFunc
+	Name                string
+	Method              string
+	Pattern             string
+	DefaultVersion      RouteHandlerVersion
+	RouteHandlers       RouteHandlers
+	ACLHandler          ACLHandlerFunc
+	ACLDecisionHandler  ACLDecisionHandlerFunc
+	Middlewares         []interface{}
+	MiddlewaresPosition MiddlewaresPosition
+	Doc                 *RouteDoc
 }
 
 // Routes type
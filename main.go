@@ -2,19 +2,31 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/sogko/slumber-sessions"
 	"github.com/sogko/slumber-users"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/apikey"
+	"github.com/sogko/slumber/middlewares/audit"
 	"github.com/sogko/slumber/middlewares/context"
-	"github.com/sogko/slumber/middlewares/mongodb"
+	"github.com/sogko/slumber/middlewares/migrations"
+	"github.com/sogko/slumber/middlewares/multiauth"
 	"github.com/sogko/slumber/middlewares/renderer"
 	"github.com/sogko/slumber/server"
 	"io/ioutil"
+	"os"
 	"time"
 )
 
+var databaseFlag = flag.String("database", DatabaseMongoDB, "database backend: `mongodb`, `bolt` (embedded file), `postgres` or `sqlite` (embedded file)")
+var databaseURLFlag = flag.String("database-url", "", "MongoDB server, Bolt or SQLite file or PostgreSQL connection string")
+
 func main() {
 
+	flag.Usage = usage
+	flag.Parse()
+
 	// try to load signing keys for token authority
 	// NOTE: DO NOT USE THESE KEYS FOR PRODUCTION! FOR DEMO ONLY
 	privateSigningKey, err := ioutil.ReadFile("keys/demo.rsa")
@@ -29,12 +41,11 @@ func main() {
 	// create current project context
 	ctx := context.New()
 
-	// set up DB session
-	db := mongodb.New(&mongodb.Options{
-		ServerName:   "localhost",
-		DatabaseName: "test-go-app",
-	})
-	_ = db.NewSession()
+	// set up DB
+	db, connectDatabase, err := newDatabase(*databaseFlag, *databaseURLFlag)
+	if err != nil {
+		panic(err)
+	}
 
 	// set up Renderer (unrolled_render)
 	renderer := renderer.New(&renderer.Options{
@@ -63,19 +74,84 @@ func main() {
 
 	// set up router
 	ac := server.NewAccessController(ctx, renderer)
+
+	// record every authorization decision into the `audit` collection
+	ac.UseAuditSink(audit.NewDatabaseSink(db, nil))
 	router := server.NewRouter(s.Context, ac)
 
+	// set up OpenAPI document resource, generated from the routes added to the router
+	openAPIResource := server.NewOpenAPIResource(ctx, renderer, router, &server.OpenAPIResourceOptions{
+		Info: server.OpenAPIInfo{
+			Title: "slumber",
+		},
+	})
+
 	// add REST resources to router
-	router.AddResources(sessionsResource, usersResource)
+	router.MustAddResources(sessionsResource, usersResource, openAPIResource)
+
+	// set up API keys store
+	apiKeyStore := apikey.NewStore(db, nil)
+
+	// register the migrations of resources and stores
+	migrator := migrations.New(db, nil)
+	if err := migrator.RegisterResources(sessionsResource, usersResource, openAPIResource); err != nil {
+		panic(err)
+	}
+	if err := migrator.Register(apiKeyStore); err != nil {
+		panic(err)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "", "serve":
+	case "routes":
+		printRoutes(os.Stdout, router)
+		return
+	case "acl-matrix":
+		if err := runACLMatrix(os.Stdout, flag.Args()[1:], router, ac); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	case "migrate":
+		if err := connectDatabase(); err != nil {
+			panic(errors.New(fmt.Sprintf("Error connecting to database: %v", err.Error())))
+		}
+		if err := runMigrate(os.Stdout, flag.Args()[1:], migrator); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %v\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	// connect to DB
+	if err := connectDatabase(); err != nil {
+		panic(errors.New(fmt.Sprintf("Error connecting to database: %v", err.Error())))
+	}
 
 	// add middlewares
-	s.UseMiddleware(sessionsResource.NewAuthenticator())
+	s.UseMiddleware(audit.NewRequestID())
+
+	// authenticate requests with a JWT bearer token (sessions) or an API key
+	s.UseContextMiddleware(multiauth.New(&multiauth.Options{
+		Authenticators: []domain.IAuthenticator{
+			multiauth.FromMiddleware(multiauth.SchemeBearer, sessionsResource.NewAuthenticator(), ctx),
+			apikey.New(&apikey.Options{
+				Store:    apiKeyStore,
+				Renderer: renderer,
+			}),
+		},
+		Renderer: renderer,
+	}))
 
 	// setup router
 	s.UseRouter(router)
 
 	// bam!
 	s.Run(":3001", server.Options{
-		Timeout: 10*time.Second,
+		Timeout: 10 * time.Second,
 	})
 }
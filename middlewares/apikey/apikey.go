@@ -0,0 +1,113 @@
+package apikey
+
+import (
+	"github.com/sogko/slumber/domain"
+	"log"
+	"net/http"
+)
+
+const APIKeyCtxKey domain.ContextKey = "slumber-mddlwr-apikey-key"
+const Scheme = "apikey"
+const DefaultHeader = "X-API-Key"
+const DefaultQueryParam = "api_key"
+
+// Options type
+// Header and QueryParam are where the key is read from, in that order; set QueryParam to "-" to only accept
+// keys from the header, since query parameters might end up in access logs.
+// Renderer is used to render errors of requests with invalid keys; plain text errors are written if nil.
+type Options struct {
+	Store      *Store
+	Header     string
+	QueryParam string
+	Renderer   domain.IRenderer
+}
+
+// Authenticator type
+// implements IContextMiddleware and IContextAuthenticator
+// Requests without a key are passed through without a current user, so that ACL handlers decide on anonymous access.
+// Requests with an invalid, expired or revoked key are answered with 401, and store errors with 5xx.
+type Authenticator struct {
+	options *Options
+}
+
+// New Returns a new Authenticator object
+func New(options *Options) *Authenticator {
+	if options.Header == "" {
+		options.Header = DefaultHeader
+	}
+	if options.QueryParam == "" {
+		options.QueryParam = DefaultQueryParam
+	}
+	return &Authenticator{options}
+}
+
+// Key Returns the API key sent with the request, if any
+func (authenticator *Authenticator) Key(req *http.Request) string {
+	if key := req.Header.Get(authenticator.options.Header); key != "" {
+		return key
+	}
+	if authenticator.options.QueryParam != "-" {
+		return req.URL.Query().Get(authenticator.options.QueryParam)
+	}
+	return ""
+}
+
+func (authenticator *Authenticator) Scheme() string {
+	return Scheme
+}
+
+// Authenticate Returns the Principal of the API key sent with the request, if any.
+// Returns an AuthenticationError if the key is invalid, expired or revoked, or the error of the store.
+func (authenticator *Authenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	key := authenticator.Key(req)
+	if key == "" {
+		return nil, nil
+	}
+	apiKey, err := authenticator.options.Store.Lookup(req.Context(), key)
+	switch err {
+	case nil:
+	case ErrInvalidKey, ErrExpiredKey, ErrRevokedKey:
+		return nil, domain.NewAuthenticationError(err.Error(), "ApiKey")
+	default:
+		return nil, err
+	}
+	if err := authenticator.options.Store.Touch(req.Context(), apiKey); err != nil {
+		log.Printf("Error updating last-used date of API key `%v`: %v", apiKey.ID.Hex(), err.Error())
+	}
+	return &Principal{apiKey}, nil
+}
+
+func (authenticator *Authenticator) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+	user, err := authenticator.Authenticate(req)
+	if err != nil {
+		if _, ok := err.(*domain.AuthenticationError); !ok {
+			log.Printf("Error looking up API key: %v", err.Error())
+		}
+		domain.AuthenticationErrorOf(err).Write(w, req, authenticator.options.Renderer)
+		return
+	}
+	if user != nil {
+		authenticator.SetContext(ctx, req, user)
+		ctx.SetCurrentUserCtx(req, user)
+	}
+	next(w, req)
+}
+
+// SetContext saves the API key of the Principal returned by Authenticate() into the request context
+func (authenticator *Authenticator) SetContext(ctx domain.IContext, req *http.Request, user domain.IUser) {
+	if principal, ok := user.(*Principal); ok {
+		SetAPIKeyCtx(ctx, req, principal.Key)
+	}
+}
+
+func SetAPIKeyCtx(ctx domain.IContext, r *http.Request, apiKey *APIKey) {
+	ctx.Set(r, APIKeyCtxKey, apiKey)
+}
+
+// GetAPIKeyCtx Returns the API key the request has been authenticated with, if any
+func GetAPIKeyCtx(ctx domain.IContext, r *http.Request) *APIKey {
+	if apiKey := ctx.Get(r, APIKeyCtxKey); apiKey != nil {
+		return apiKey.(*APIKey)
+	}
+	return nil
+}
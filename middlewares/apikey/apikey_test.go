@@ -0,0 +1,213 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/apikey"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	ctx "github.com/sogko/slumber/middlewares/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// failingDatabase fails every lookup, like a database that is down
+type failingDatabase struct {
+	domain.IDatabase
+}
+
+func (db *failingDatabase) FindOne(ctx context.Context, name string, query domain.Query, result interface{}) error {
+	return errors.New("connection refused")
+}
+
+var _ = Describe("APIKey", func() {
+
+	var dir string
+	var db *boltdb.BoltDB
+	var store *apikey.Store
+	var now time.Time
+	background := context.Background()
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "apikey")
+		Expect(err).To(BeNil())
+		db = boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+		Expect(db.Open()).To(BeNil())
+		now = time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC)
+		store = apikey.NewStore(db, &apikey.StoreOptions{
+			Now: func() time.Time {
+				return now
+			},
+		})
+		Expect(store.EnsureIndex(background)).To(BeNil())
+	})
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("Store", func() {
+		It("should look up created keys by key only", func() {
+			key, created, err := store.Create(background, "ci", "user", []string{"user"}, []string{"users:read"}, time.Time{})
+			Expect(err).To(BeNil())
+			Expect(key).To(HavePrefix(apikey.KeyPrefix))
+			Expect(created.Hash).ToNot(ContainSubstring(key))
+
+			found, err := store.Lookup(background, key)
+			Expect(err).To(BeNil())
+			Expect(found.ID).To(Equal(created.ID))
+			Expect(found.PrincipalID).To(Equal("user"))
+
+			_, err = store.Lookup(background, key+"0")
+			Expect(err).To(Equal(apikey.ErrInvalidKey))
+			_, err = store.Lookup(background, "")
+			Expect(err).To(Equal(apikey.ErrInvalidKey))
+		})
+		It("should not look up expired or revoked keys", func() {
+			expiring, _, err := store.Create(background, "expiring", "", nil, nil, now.Add(time.Hour))
+			Expect(err).To(BeNil())
+			revoked, apiKey, err := store.Create(background, "revoked", "", nil, nil, time.Time{})
+			Expect(err).To(BeNil())
+			Expect(store.Revoke(background, apiKey.ID.Hex())).To(BeNil())
+
+			_, err = store.Lookup(background, expiring)
+			Expect(err).To(BeNil())
+			now = now.Add(time.Hour)
+			_, err = store.Lookup(background, expiring)
+			Expect(err).To(Equal(apikey.ErrExpiredKey))
+			_, err = store.Lookup(background, revoked)
+			Expect(err).To(Equal(apikey.ErrRevokedKey))
+		})
+		It("should update the last-used date at most once per interval", func() {
+			key, _, err := store.Create(background, "ci", "", nil, nil, time.Time{})
+			Expect(err).To(BeNil())
+			apiKey, _ := store.Lookup(background, key)
+			Expect(store.Touch(background, apiKey)).To(BeNil())
+			used := now
+
+			now = now.Add(30 * time.Second)
+			apiKey, _ = store.Lookup(background, key)
+			Expect(apiKey.LastUsedAt.Equal(used)).To(BeTrue())
+			Expect(store.Touch(background, apiKey)).To(BeNil())
+			apiKey, _ = store.Lookup(background, key)
+			Expect(apiKey.LastUsedAt.Equal(used)).To(BeTrue())
+
+			now = now.Add(time.Minute)
+			Expect(store.Touch(background, apiKey)).To(BeNil())
+			apiKey, _ = store.Lookup(background, key)
+			Expect(apiKey.LastUsedAt.Equal(now)).To(BeTrue())
+		})
+		It("should rotate keys with a grace period", func() {
+			previous, apiKey, err := store.Create(background, "ci", "user", []string{"user"}, []string{"users:*"}, time.Time{})
+			Expect(err).To(BeNil())
+			key, rotated, err := store.Rotate(background, apiKey.ID.Hex(), time.Hour)
+			Expect(err).To(BeNil())
+			Expect(key).ToNot(Equal(previous))
+			Expect(rotated.Name).To(Equal("ci"))
+			Expect(rotated.PrincipalID).To(Equal("user"))
+			Expect(rotated.Scopes).To(Equal([]string{"users:*"}))
+
+			found, err := store.Lookup(background, previous)
+			Expect(err).To(BeNil())
+			Expect(found.RotatedTo).To(Equal(rotated.ID))
+			now = now.Add(time.Hour)
+			_, err = store.Lookup(background, previous)
+			Expect(err).To(Equal(apikey.ErrExpiredKey))
+			_, err = store.Lookup(background, key)
+			Expect(err).To(BeNil())
+		})
+		It("should not rotate revoked or unknown keys", func() {
+			_, apiKey, err := store.Create(background, "ci", "", nil, nil, time.Time{})
+			Expect(err).To(BeNil())
+			Expect(store.Revoke(background, apiKey.ID.Hex())).To(BeNil())
+			_, _, err = store.Rotate(background, apiKey.ID.Hex(), time.Hour)
+			Expect(err).To(Equal(apikey.ErrRevokedKey))
+			_, _, err = store.Rotate(background, "unknown", time.Hour)
+			Expect(err).To(Equal(apikey.ErrInvalidKey))
+		})
+	})
+
+	Describe("APIKey.HasScope()", func() {
+		It("should grant exact, prefixed and global scopes", func() {
+			key := &apikey.APIKey{Scopes: []string{"users:*", "posts:read"}}
+			Expect(key.HasScope("users:delete")).To(BeTrue())
+			Expect(key.HasScope("posts:read")).To(BeTrue())
+			Expect(key.HasScope("posts:delete")).To(BeFalse())
+			Expect(key.HasScope("usersx:read")).To(BeFalse())
+			Expect((&apikey.APIKey{Scopes: []string{"*"}}).HasScope("posts:delete")).To(BeTrue())
+		})
+	})
+
+	Describe("RequireScopes()", func() {
+		It("should require an API key with all the scopes", func() {
+			handler := apikey.RequireScopes("users:read", "users:update")
+			result, _ := handler(nil, &apikey.Principal{Key: &apikey.APIKey{Scopes: []string{"users:*"}}})
+			Expect(result).To(BeTrue())
+			result, message := handler(nil, &apikey.Principal{Key: &apikey.APIKey{Scopes: []string{"users:read"}}})
+			Expect(result).To(BeFalse())
+			Expect(message).To(ContainSubstring("users:update"))
+			result, _ = handler(nil, nil)
+			Expect(result).To(BeFalse())
+		})
+	})
+
+	Describe("Authenticator", func() {
+		var authenticator *apikey.Authenticator
+		var key string
+
+		serve := func(header string) (*httptest.ResponseRecorder, domain.IUser, *apikey.APIKey) {
+			context := ctx.New()
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			if header != "" {
+				request.Header.Set(apikey.DefaultHeader, header)
+			}
+			var user domain.IUser
+			var apiKey *apikey.APIKey
+			authenticator.Handler(recorder, request, func(w http.ResponseWriter, req *http.Request) {
+				user = context.GetCurrentUserCtx(req)
+				apiKey = apikey.GetAPIKeyCtx(context, req)
+			}, context)
+			return recorder, user, apiKey
+		}
+
+		BeforeEach(func() {
+			var err error
+			key, _, err = store.Create(background, "ci", "user", []string{"user"}, nil, time.Time{})
+			Expect(err).To(BeNil())
+			authenticator = apikey.New(&apikey.Options{Store: store})
+		})
+		It("should authenticate requests with a valid key", func() {
+			recorder, user, apiKey := serve(key)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(user.GetID()).To(Equal("user"))
+			Expect(user.HasRole("user")).To(BeTrue())
+			Expect(apiKey.Name).To(Equal("ci"))
+		})
+		It("should pass through requests without a key", func() {
+			recorder, user, _ := serve("")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(user).To(BeNil())
+		})
+		It("should answer invalid keys with 401", func() {
+			recorder, user, _ := serve("sk_invalid")
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal("ApiKey"))
+			Expect(user).To(BeNil())
+		})
+		It("should answer store errors with 5xx without their message", func() {
+			authenticator = apikey.New(&apikey.Options{Store: apikey.NewStore(&failingDatabase{}, nil)})
+			recorder, user, _ := serve(key)
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring("connection refused"))
+			Expect(user).To(BeNil())
+		})
+	})
+})
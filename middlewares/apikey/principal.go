@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+// Principal type
+// implements IUser
+// It is the current user of requests authenticated with an API key.
+type Principal struct {
+	Key *APIKey
+}
+
+// GetID Returns the principal ID of the key, or the key ID if it does not have one
+func (principal *Principal) GetID() string {
+	if principal.Key.PrincipalID != "" {
+		return principal.Key.PrincipalID
+	}
+	return principal.Key.ID.Hex()
+}
+
+func (principal *Principal) IsValid() bool {
+	return !principal.Key.IsRevoked()
+}
+
+// IsCodeVerified Returns false, principals cannot confirm codes
+func (principal *Principal) IsCodeVerified(code string) bool {
+	return false
+}
+
+// IsCredentialsVerified Returns false, principals do not have passwords
+func (principal *Principal) IsCredentialsVerified(password string) bool {
+	return false
+}
+
+// SetPassword is not supported for principals
+func (principal *Principal) SetPassword(password string) error {
+	return errors.New(fmt.Sprintf("Cannot set password of API key `%v`", principal.Key.Name))
+}
+
+func (principal *Principal) GenerateConfirmationCode() {}
+
+// HasRole Returns true if the key has been granted the role; roles are stored by name in APIKey.Roles,
+// so a role matches the key role named after its string representation
+func (principal *Principal) HasRole(r domain.IRole) bool {
+	for _, role := range principal.Key.Roles {
+		if role == fmt.Sprint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope Returns true if the key of the principal has been granted the scope
+func (principal *Principal) HasScope(scope string) bool {
+	return principal.Key.HasScope(scope)
+}
+
+// RequireScopes Returns an ACLHandlerFunc that authorizes requests authenticated with an API key that has
+// all the scopes; requests from other users are not authorized.
+func RequireScopes(scopes ...string) domain.ACLHandlerFunc {
+	return func(req *http.Request, user domain.IUser) (bool, string) {
+		principal, ok := user.(*Principal)
+		if !ok {
+			return false, "Forbidden (403), requires an API key"
+		}
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				return false, fmt.Sprintf("Forbidden (403), API key requires `%v` scope", scope)
+			}
+		}
+		return true, ""
+	}
+}
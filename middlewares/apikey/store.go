@@ -0,0 +1,256 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+)
+
+const DefaultCollection = "apikeys"
+
+// KeyPrefix is prepended to generated keys, so that leaked keys are easy to identify
+const KeyPrefix = "sk_"
+
+var ErrInvalidKey = errors.New("Invalid API key")
+var ErrExpiredKey = errors.New("API key has expired")
+var ErrRevokedKey = errors.New("API key has been revoked")
+
+// APIKey type
+// Only the SHA-256 hash of the key is stored, the key itself is returned once when created.
+// Hint is the beginning of the key, to help identify keys without storing them.
+type APIKey struct {
+	ID          bson.ObjectId `json:"_id" bson:"_id"`
+	Name        string        `json:"name" bson:"name"`
+	Hint        string        `json:"hint" bson:"hint"`
+	Hash        string        `json:"-" bson:"hash"`
+	PrincipalID string        `json:"principalId,omitempty" bson:"principalId,omitempty"`
+	Roles       []string      `json:"roles" bson:"roles"`
+	Scopes      []string      `json:"scopes" bson:"scopes"`
+	CreatedAt   time.Time     `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time     `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	RevokedAt   time.Time     `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	LastUsedAt  time.Time     `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RotatedTo   bson.ObjectId `json:"rotatedTo,omitempty" bson:"rotatedTo,omitempty"`
+}
+
+// IsExpired Returns true if the key has an expiry date in the past
+func (key *APIKey) IsExpired(now time.Time) bool {
+	return !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt)
+}
+
+// IsRevoked Returns true if the key has been revoked
+func (key *APIKey) IsRevoked() bool {
+	return !key.RevokedAt.IsZero()
+}
+
+// HasScope Returns true if the key has been granted the scope; `users:*` grants every `users:` scope and `*` grants all
+func (key *APIKey) HasScope(scope string) bool {
+	for _, granted := range key.Scopes {
+		if granted == "*" || granted == scope {
+			return true
+		}
+		if strings.HasSuffix(granted, ":*") && strings.HasPrefix(scope, strings.TrimSuffix(granted, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreOptions type
+// LastUsedInterval is the minimum interval between two updates of the last-used date of a key,
+// to avoid a database write for every request; defaults to 1 minute.
+type StoreOptions struct {
+	Collection       string
+	LastUsedInterval time.Duration
+	Now              func() time.Time
+}
+
+// Store type
+// It stores API keys in an IDatabase collection
+type Store struct {
+	db      domain.IDatabase
+	options *StoreOptions
+}
+
+// NewStore Returns a new Store object
+func NewStore(db domain.IDatabase, options *StoreOptions) *Store {
+	if options == nil {
+		options = &StoreOptions{}
+	}
+	if options.Collection == "" {
+		options.Collection = DefaultCollection
+	}
+	if options.LastUsedInterval <= 0 {
+		options.LastUsedInterval = 1 * time.Minute
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return &Store{db, options}
+}
+
+// EnsureIndex creates the unique index on key hashes
+func (store *Store) EnsureIndex(ctx context.Context) error {
+	return store.db.EnsureIndex(ctx, store.options.Collection, domain.Index{
+		Fields: []string{"hash"},
+		Unique: true,
+	})
+}
+
+// Migrations Returns the migrations of the API keys collection
+func (store *Store) Migrations() []domain.Migration {
+	return []domain.Migration{
+		{
+			Version:     20150610000000,
+			Description: "Create the unique index on API key hashes",
+			Up: func(ctx context.Context, db domain.IDatabase) error {
+				return NewStore(db, store.options).EnsureIndex(ctx)
+			},
+		},
+	}
+}
+
+// HashKey Returns the hex-encoded SHA-256 hash of the key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KeyPrefix + hex.EncodeToString(b), nil
+}
+
+// Create Returns a new key and its stored APIKey.
+// The key is not stored and cannot be retrieved later. A zero expiresAt never expires.
+func (store *Store) Create(ctx context.Context, name string, principalID string, roles []string, scopes []string, expiresAt time.Time) (string, *APIKey, error) {
+	key, err := generateKey()
+	if err != nil {
+		return "", nil, err
+	}
+	apiKey := &APIKey{
+		ID:          bson.NewObjectId(),
+		Name:        name,
+		Hint:        key[:len(KeyPrefix)+6],
+		Hash:        HashKey(key),
+		PrincipalID: principalID,
+		Roles:       roles,
+		Scopes:      scopes,
+		CreatedAt:   store.options.Now().UTC(),
+		ExpiresAt:   expiresAt,
+	}
+	if err := store.db.Insert(ctx, store.options.Collection, apiKey); err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// Get Returns the stored APIKey by its ID
+func (store *Store) Get(ctx context.Context, id string) (*APIKey, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrInvalidKey
+	}
+	var apiKey APIKey
+	if err := store.db.FindOne(ctx, store.options.Collection, domain.Query{"_id": bson.ObjectIdHex(id)}, &apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// Lookup Returns the stored APIKey of a key.
+// Returns ErrInvalidKey, ErrExpiredKey or ErrRevokedKey if the key cannot be used.
+func (store *Store) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	var apiKey APIKey
+	if err := store.db.FindOne(ctx, store.options.Collection, domain.Query{"hash": HashKey(key)}, &apiKey); err == domain.ErrNotFound {
+		return nil, ErrInvalidKey
+	} else if err != nil {
+		return nil, err
+	}
+	if apiKey.IsRevoked() {
+		return nil, ErrRevokedKey
+	}
+	if apiKey.IsExpired(store.options.Now()) {
+		return nil, ErrExpiredKey
+	}
+	return &apiKey, nil
+}
+
+// Touch updates the last-used date of the key, at most once per StoreOptions.LastUsedInterval
+func (store *Store) Touch(ctx context.Context, apiKey *APIKey) error {
+	now := store.options.Now().UTC()
+	if !apiKey.LastUsedAt.IsZero() && now.Sub(apiKey.LastUsedAt) < store.options.LastUsedInterval {
+		return nil
+	}
+	apiKey.LastUsedAt = now
+	_, err := store.db.UpdateAll(ctx, store.options.Collection, domain.Query{"_id": apiKey.ID}, domain.Query{
+		"$set": domain.Query{"lastUsedAt": now},
+	})
+	return err
+}
+
+// Rotate Returns a new key with the same name, principal, roles, scopes and expiry as the key with the given ID.
+// The previous key keeps working for the grace period, so that clients can be updated without downtime.
+// The new key is created and the previous key expired in a transaction.
+func (store *Store) Rotate(ctx context.Context, id string, gracePeriod time.Duration) (string, *APIKey, error) {
+	previous, err := store.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if previous.IsRevoked() {
+		return "", nil, ErrRevokedKey
+	}
+	var key string
+	var apiKey *APIKey
+	err = store.db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+		txStore := NewStore(tx, store.options)
+		var err error
+		key, apiKey, err = txStore.Create(ctx, previous.Name, previous.PrincipalID, previous.Roles, previous.Scopes, previous.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		set := domain.Query{"rotatedTo": apiKey.ID}
+		expiresAt := store.options.Now().UTC().Add(gracePeriod)
+		if previous.ExpiresAt.IsZero() || expiresAt.Before(previous.ExpiresAt) {
+			set["expiresAt"] = expiresAt
+		}
+		if _, err := tx.UpdateAll(ctx, store.options.Collection, domain.Query{"_id": previous.ID}, domain.Query{"$set": set}); err != nil {
+			return errors.New(fmt.Sprintf("Error expiring rotated API key: %v", err.Error()))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// Revoke revokes the key with the given ID immediately
+func (store *Store) Revoke(ctx context.Context, id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidKey
+	}
+	count, err := store.db.UpdateAll(ctx, store.options.Collection, domain.Query{"_id": bson.ObjectIdHex(id)}, domain.Query{
+		"$set": domain.Query{"revokedAt": store.options.Now().UTC()},
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/audit"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// blockingDB type is a database whose inserts wait until released or until their context is done
+type blockingDB struct {
+	domain.IDatabase
+	release chan struct{}
+	errors  chan error
+}
+
+func (db *blockingDB) Insert(ctx context.Context, name string, obj interface{}) error {
+	var err error
+	select {
+	case <-db.release:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	db.errors <- err
+	return err
+}
+
+var _ = Describe("Audit", func() {
+
+	entry := func(action string) *domain.AuditEntry {
+		return &domain.AuditEntry{
+			Timestamp: time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC),
+			UserID:    "user",
+			Action:    action,
+			Method:    "GET",
+			Path:      "/api/users",
+			Allowed:   true,
+		}
+	}
+
+	Describe("DatabaseSink", func() {
+		var dir string
+		var db *boltdb.BoltDB
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "audit")
+			Expect(err).To(BeNil())
+			db = boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+			Expect(db.Open()).To(BeNil())
+		})
+		AfterEach(func() {
+			db.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("should insert the entries into the collection", func() {
+			sink := audit.NewDatabaseSink(db, nil)
+			Expect(sink.Write(entry("GetUsers"))).To(BeNil())
+			Expect(sink.Write(entry("GetUser"))).To(BeNil())
+			Expect(sink.Close()).To(BeNil())
+			Expect(sink.Write(entry("GetUser"))).To(Equal(audit.ErrSinkClosed))
+
+			var entries []domain.AuditEntry
+			Expect(db.FindAll(context.Background(), audit.DefaultAuditCollection, nil, &entries, 0, "action")).To(BeNil())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Action).To(Equal("GetUser"))
+			Expect(entries[1].Action).To(Equal("GetUsers"))
+			Expect(entries[1].UserID).To(Equal("user"))
+		})
+		It("should not wait for a slow database and drop entries once the buffer is full", func() {
+			slow := &blockingDB{release: make(chan struct{}), errors: make(chan error, 3)}
+			sink := audit.NewDatabaseSink(slow, &audit.DatabaseSinkOptions{BufferSize: 1, WriteTimeout: time.Minute})
+			dropped := 0
+			for _, action := range []string{"a", "b", "c"} {
+				if err := sink.Write(entry(action)); err != nil {
+					Expect(err).To(Equal(audit.ErrBufferFull))
+					dropped++
+				}
+			}
+			Expect(dropped).To(BeNumerically(">=", 1))
+			close(slow.release)
+			Expect(sink.Close()).To(BeNil())
+			Expect(slow.errors).To(HaveLen(3 - dropped))
+		})
+		It("should bound inserts with the write timeout", func() {
+			slow := &blockingDB{release: make(chan struct{}), errors: make(chan error, 1)}
+			sink := audit.NewDatabaseSink(slow, &audit.DatabaseSinkOptions{WriteTimeout: 10 * time.Millisecond})
+			Expect(sink.Write(entry("GetUser"))).To(BeNil())
+			Eventually(slow.errors).Should(Receive(Equal(context.DeadlineExceeded)))
+			Expect(sink.Close()).To(BeNil())
+		})
+	})
+
+	Describe("FileSink", func() {
+		It("should write entries as JSON lines", func() {
+			var buffer bytes.Buffer
+			sink := audit.NewWriterSink(&buffer)
+			Expect(sink.Write(entry("GetUsers"))).To(BeNil())
+			Expect(sink.Write(entry("GetUser"))).To(BeNil())
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+			var written domain.AuditEntry
+			Expect(json.Unmarshal([]byte(lines[1]), &written)).To(BeNil())
+			Expect(written).To(Equal(*entry("GetUser")))
+			Expect(sink.Close()).To(BeNil())
+		})
+		It("should append entries to a file", func() {
+			dir, err := ioutil.TempDir("", "audit")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "audit.log")
+			for _, action := range []string{"GetUsers", "GetUser"} {
+				sink, err := audit.NewFileSink(filename)
+				Expect(err).To(BeNil())
+				Expect(sink.Write(entry(action))).To(BeNil())
+				Expect(sink.Close()).To(BeNil())
+			}
+			b, err := ioutil.ReadFile(filename)
+			Expect(err).To(BeNil())
+			Expect(strings.Count(string(b), "\n")).To(Equal(2))
+			Expect(string(b)).To(ContainSubstring(`"action":"GetUsers"`))
+			_, err = audit.NewFileSink(filepath.Join(dir, "missing", "audit.log"))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("RequestID", func() {
+		var requestID string
+		next := func(w http.ResponseWriter, req *http.Request) {
+			requestID = req.Header.Get(domain.RequestIDHeader)
+		}
+
+		It("should set a new request ID on the request and the response", func() {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder := httptest.NewRecorder()
+			audit.NewRequestID().Handler(recorder, request, next)
+			Expect(requestID).To(HaveLen(32))
+			Expect(recorder.Header().Get(domain.RequestIDHeader)).To(Equal(requestID))
+
+			other := httptest.NewRecorder()
+			request, _ = http.NewRequest("GET", "/api/users", nil)
+			audit.NewRequestID().Handler(other, request, next)
+			Expect(other.Header().Get(domain.RequestIDHeader)).ToNot(Equal(recorder.Header().Get(domain.RequestIDHeader)))
+		})
+		It("should keep the request ID of the request", func() {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			request.Header.Set(domain.RequestIDHeader, "abc")
+			recorder := httptest.NewRecorder()
+			audit.NewRequestID().Handler(recorder, request, next)
+			Expect(requestID).To(Equal("abc"))
+			Expect(recorder.Header().Get(domain.RequestIDHeader)).To(Equal("abc"))
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"github.com/sogko/slumber/domain"
+	"log"
+	"sync"
+	"time"
+)
+
+const DefaultAuditCollection = "audit"
+const DefaultBufferSize = 1024
+const DefaultWriteTimeout = 5 * time.Second
+
+// ErrBufferFull is returned by DatabaseSink.Write() when the entry is dropped because the database is too slow
+var ErrBufferFull = errors.New("Audit buffer is full, entry dropped")
+
+// ErrSinkClosed is returned by DatabaseSink.Write() after the sink has been closed
+var ErrSinkClosed = errors.New("Audit sink is closed")
+
+// DatabaseSinkOptions type
+// Collection defaults to DefaultAuditCollection, BufferSize to DefaultBufferSize
+// and WriteTimeout, the deadline of each insert, to DefaultWriteTimeout.
+type DatabaseSinkOptions struct {
+	Collection   string
+	BufferSize   int
+	WriteTimeout time.Duration
+}
+
+// DatabaseSink type
+// implements IAuditSink
+// It inserts audit entries into a database collection in the background, so that a slow database does not
+// stall requests: Write() queues the entry and returns ErrBufferFull if BufferSize entries are already queued.
+// Entries are inserted with a context bounded by WriteTimeout, independent of the request, and insert errors are logged.
+type DatabaseSink struct {
+	db      domain.IDatabase
+	options *DatabaseSinkOptions
+	entries chan *domain.AuditEntry
+	done    chan struct{}
+	mutex   sync.RWMutex
+	closed  bool
+}
+
+// NewDatabaseSink Returns a DatabaseSink that inserts audit entries into a collection of the database
+func NewDatabaseSink(db domain.IDatabase, options *DatabaseSinkOptions) *DatabaseSink {
+	if options == nil {
+		options = &DatabaseSinkOptions{}
+	}
+	if options.Collection == "" {
+		options.Collection = DefaultAuditCollection
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = DefaultWriteTimeout
+	}
+	sink := &DatabaseSink{
+		db:      db,
+		options: options,
+		entries: make(chan *domain.AuditEntry, options.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go sink.run()
+	return sink
+}
+
+func (sink *DatabaseSink) Write(entry *domain.AuditEntry) error {
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+	if sink.closed {
+		return ErrSinkClosed
+	}
+	select {
+	case sink.entries <- entry:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Close stops accepting entries and waits until the queued entries are inserted
+func (sink *DatabaseSink) Close() error {
+	sink.mutex.Lock()
+	if !sink.closed {
+		sink.closed = true
+		close(sink.entries)
+	}
+	sink.mutex.Unlock()
+	<-sink.done
+	return nil
+}
+
+// run inserts the queued entries until the sink is closed
+func (sink *DatabaseSink) run() {
+	defer close(sink.done)
+	for entry := range sink.entries {
+		ctx, cancel := context.WithTimeout(context.Background(), sink.options.WriteTimeout)
+		if err := sink.db.Insert(ctx, sink.options.Collection, entry); err != nil {
+			log.Printf("Error inserting audit entry for `%v`: %v", entry.Action, err.Error())
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/sogko/slumber/domain"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink type
+// implements IAuditSink
+// It writes audit entries as JSON, one entry per line.
+type FileSink struct {
+	w     io.Writer
+	mutex sync.Mutex
+}
+
+// NewFileSink Returns a FileSink that appends audit entries to the file, creating it if it does not exist
+func NewFileSink(filename string) (*FileSink, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(f), nil
+}
+
+// NewWriterSink Returns a FileSink that writes audit entries to w
+func NewWriterSink(w io.Writer) *FileSink {
+	return &FileSink{w: w}
+}
+
+func (sink *FileSink) Write(entry *domain.AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	_, err = sink.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer, if it is an io.Closer
+func (sink *FileSink) Close() error {
+	if closer, ok := sink.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+// RequestID type
+// implements IMiddleware
+// It sets the X-Request-ID header of the request, if missing, and of the response,
+// so that audit entries can be correlated with the request.
+type RequestID struct {
+}
+
+// NewRequestID Returns a new RequestID middleware
+func NewRequestID() *RequestID {
+	return &RequestID{}
+}
+
+func (m *RequestID) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	id := req.Header.Get(domain.RequestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		req.Header.Set(domain.RequestIDHeader, id)
+	}
+	w.Header().Set(domain.RequestIDHeader, id)
+	next(w, req)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,194 @@
+package basicauth
+
+import (
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const Scheme = "basic"
+const DefaultRealm = "Restricted"
+const DefaultMaxFailures = 5
+const DefaultLockoutDuration = 15 * time.Minute
+
+// UserLookupFunc Returns the user with the given username, or nil if it does not exist
+type UserLookupFunc func(username string) (domain.IUser, error)
+
+// Options type
+// After MaxFailures failed attempts for a username or from a client IP within LockoutDuration,
+// further attempts are answered with 429 until LockoutDuration has passed.
+// TrustProxyHeaders uses X-Forwarded-For / X-Real-IP headers for the client IP, see domain.ClientIP().
+// Renderer is used to render errors; plain text errors are written if nil.
+// DummyUser is a user whose credentials are verified when the username is unknown, so that the time to answer
+// does not reveal which usernames exist; its password should be hashed like the passwords of the users of Lookup.
+// It defaults to the last user found by Lookup, so the first attempts with unknown usernames are not padded.
+type Options struct {
+	Realm             string
+	Lookup            UserLookupFunc
+	DummyUser         domain.IUser
+	MaxFailures       int
+	LockoutDuration   time.Duration
+	TrustProxyHeaders bool
+	Renderer          domain.IRenderer
+	Now               func() time.Time
+}
+
+type failures struct {
+	count       int
+	since       time.Time
+	lockedUntil time.Time
+}
+
+// Authenticator type
+// implements IContextMiddleware and IAuthenticator
+// Requests without Basic credentials are passed through without a current user, so that ACL handlers decide
+// on anonymous access. Requests with invalid credentials are answered with 401 and a Basic challenge,
+// and Lookup errors with 5xx.
+type Authenticator struct {
+	options   *Options
+	mutex     sync.Mutex
+	failures  map[string]*failures
+	dummyUser domain.IUser
+}
+
+// New Returns a new Authenticator object, panics if Options.Lookup is nil
+func New(options *Options) *Authenticator {
+	if options == nil || options.Lookup == nil {
+		panic("basicauth: Options.Lookup is required")
+	}
+	if options.Realm == "" {
+		options.Realm = DefaultRealm
+	}
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = DefaultMaxFailures
+	}
+	if options.LockoutDuration <= 0 {
+		options.LockoutDuration = DefaultLockoutDuration
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return &Authenticator{options: options, failures: map[string]*failures{}, dummyUser: options.DummyUser}
+}
+
+func (authenticator *Authenticator) Scheme() string {
+	return Scheme
+}
+
+// Authenticate Returns the user of the Basic credentials sent with the request, if any.
+// Returns an AuthenticationError if the credentials are invalid or locked out, or the error of Lookup.
+func (authenticator *Authenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	keys := []string{"user:" + username, "ip:" + domain.ClientIP(req, authenticator.options.TrustProxyHeaders)}
+	if retryAfter := authenticator.lockedFor(keys); retryAfter > 0 {
+		header := http.Header{}
+		header.Set("Retry-After", fmt.Sprintf("%v", int(math.Ceil(retryAfter.Seconds()))))
+		return nil, &domain.AuthenticationError{Status: http.StatusTooManyRequests, Message: "Too many failed attempts, try again later", Header: header}
+	}
+
+	user, err := authenticator.options.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		authenticator.verifyDummy(password)
+	} else if authenticator.options.DummyUser == nil {
+		authenticator.setDummy(user)
+	}
+	if user == nil || !user.IsValid() || !user.IsCredentialsVerified(password) {
+		authenticator.recordFailure(keys)
+		return nil, domain.NewAuthenticationError("Invalid username or password", fmt.Sprintf("Basic realm=%q", authenticator.options.Realm))
+	}
+
+	// only the username failures are reset, so that valid credentials do not reset the failures of the client IP
+	authenticator.reset(keys[0])
+	return user, nil
+}
+
+func (authenticator *Authenticator) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+	user, err := authenticator.Authenticate(req)
+	if err != nil {
+		if _, ok := err.(*domain.AuthenticationError); !ok {
+			log.Printf("Error looking up user of Basic credentials: %v", err.Error())
+		}
+		domain.AuthenticationErrorOf(err).Write(w, req, authenticator.options.Renderer)
+		return
+	}
+	if user != nil {
+		ctx.SetCurrentUserCtx(req, user)
+	}
+	next(w, req)
+}
+
+// verifyDummy verifies the password against the dummy user, if any, and ignores the result
+func (authenticator *Authenticator) verifyDummy(password string) {
+	authenticator.mutex.Lock()
+	dummy := authenticator.dummyUser
+	authenticator.mutex.Unlock()
+	if dummy != nil {
+		dummy.IsCredentialsVerified(password)
+	}
+}
+
+func (authenticator *Authenticator) setDummy(user domain.IUser) {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	authenticator.dummyUser = user
+}
+
+// lockedFor Returns how long any of the keys is locked out for
+func (authenticator *Authenticator) lockedFor(keys []string) time.Duration {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	now := authenticator.options.Now()
+	var locked time.Duration
+	for _, key := range keys {
+		if f, ok := authenticator.failures[key]; ok && now.Before(f.lockedUntil) && f.lockedUntil.Sub(now) > locked {
+			locked = f.lockedUntil.Sub(now)
+		}
+	}
+	return locked
+}
+
+func (authenticator *Authenticator) recordFailure(keys []string) {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	now := authenticator.options.Now()
+	if len(authenticator.failures) > 1024 {
+		authenticator.prune(now)
+	}
+	for _, key := range keys {
+		f, ok := authenticator.failures[key]
+		if !ok || now.Sub(f.since) > authenticator.options.LockoutDuration {
+			f = &failures{since: now}
+			authenticator.failures[key] = f
+		}
+		f.count++
+		if f.count >= authenticator.options.MaxFailures {
+			f.lockedUntil = now.Add(authenticator.options.LockoutDuration)
+		}
+	}
+}
+
+func (authenticator *Authenticator) reset(key string) {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	delete(authenticator.failures, key)
+}
+
+// prune removes failures that are neither recent nor locked out, so that the map does not grow indefinitely
+func (authenticator *Authenticator) prune(now time.Time) {
+	for key, f := range authenticator.failures {
+		if now.Sub(f.since) > authenticator.options.LockoutDuration && !now.Before(f.lockedUntil) {
+			delete(authenticator.failures, key)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package basicauth_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/basicauth"
+	"github.com/sogko/slumber/middlewares/context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// testUser implements IUser
+type testUser struct {
+	name     string
+	password string
+}
+
+func (user *testUser) GetID() string                              { return user.name }
+func (user *testUser) IsValid() bool                              { return true }
+func (user *testUser) IsCodeVerified(code string) bool            { return false }
+func (user *testUser) IsCredentialsVerified(password string) bool { return password == user.password }
+func (user *testUser) SetPassword(password string) error          { return nil }
+func (user *testUser) GenerateConfirmationCode()                  {}
+func (user *testUser) HasRole(r domain.IRole) bool                { return false }
+
+// countingUser type counts the verifications of its credentials
+type countingUser struct {
+	testUser
+	verifications int
+}
+
+func (user *countingUser) IsCredentialsVerified(password string) bool {
+	user.verifications++
+	return user.testUser.IsCredentialsVerified(password)
+}
+
+var _ = Describe("BasicAuth", func() {
+
+	var authenticator *basicauth.Authenticator
+	var now time.Time
+	var lookupErr error
+	users := map[string]domain.IUser{
+		"alice": &testUser{"alice", "secret"},
+		"bob":   &testUser{"bob", "secret"},
+	}
+
+	serve := func(username string, password string, ip string) (*httptest.ResponseRecorder, domain.IUser) {
+		ctx := context.New()
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/api/users", nil)
+		request.RemoteAddr = ip + ":1234"
+		if username != "" {
+			request.SetBasicAuth(username, password)
+		}
+		var user domain.IUser
+		authenticator.Handler(recorder, request, func(w http.ResponseWriter, req *http.Request) {
+			user = ctx.GetCurrentUserCtx(req)
+		}, ctx)
+		return recorder, user
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC)
+		lookupErr = nil
+		authenticator = basicauth.New(&basicauth.Options{
+			Lookup: func(username string) (domain.IUser, error) {
+				if lookupErr != nil {
+					return nil, lookupErr
+				}
+				return users[username], nil
+			},
+			MaxFailures:     3,
+			LockoutDuration: time.Minute,
+			Now: func() time.Time {
+				return now
+			},
+		})
+	})
+
+	It("should panic without Lookup", func() {
+		Expect(func() { basicauth.New(&basicauth.Options{}) }).To(Panic())
+		Expect(func() { basicauth.New(nil) }).To(Panic())
+	})
+	Describe("unknown usernames", func() {
+		It("should verify the password of the dummy user", func() {
+			dummy := &countingUser{testUser: testUser{"dummy", "dummy"}}
+			authenticator = basicauth.New(&basicauth.Options{
+				Lookup: func(username string) (domain.IUser, error) {
+					return users[username], nil
+				},
+				DummyUser: dummy,
+			})
+			recorder, _ := serve("nobody", "dummy", "10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(dummy.verifications).To(Equal(1))
+			recorder, _ = serve("alice", "secret", "10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(dummy.verifications).To(Equal(1))
+		})
+		It("should default the dummy user to the last user found", func() {
+			carol := &countingUser{testUser: testUser{"carol", "secret"}}
+			authenticator = basicauth.New(&basicauth.Options{
+				Lookup: func(username string) (domain.IUser, error) {
+					if username == "carol" {
+						return carol, nil
+					}
+					return nil, nil
+				},
+			})
+			serve("nobody", "guess", "10.0.0.1")
+			Expect(carol.verifications).To(Equal(0))
+			serve("carol", "secret", "10.0.0.1")
+			Expect(carol.verifications).To(Equal(1))
+			recorder, _ := serve("nobody", "secret", "10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(carol.verifications).To(Equal(2))
+		})
+	})
+	It("should authenticate valid credentials", func() {
+		recorder, user := serve("alice", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(user.GetID()).To(Equal("alice"))
+	})
+	It("should pass through requests without credentials", func() {
+		recorder, user := serve("", "", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(user).To(BeNil())
+	})
+	It("should answer invalid credentials with 401 and a challenge", func() {
+		recorder, _ := serve("alice", "wrong", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="Restricted"`))
+		recorder, _ = serve("nobody", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+	It("should lock out a username after too many failures, until the lockout expires", func() {
+		for i := 0; i < 3; i++ {
+			recorder, _ := serve("alice", "wrong", fmt.Sprintf("10.0.0.%v", i))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		}
+		now = now.Add(20 * time.Second)
+		recorder, user := serve("alice", "secret", "10.0.1.1")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(recorder.Header().Get("Retry-After")).To(Equal("40"))
+		Expect(user).To(BeNil())
+
+		now = now.Add(40 * time.Second)
+		recorder, user = serve("alice", "secret", "10.0.1.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(user.GetID()).To(Equal("alice"))
+	})
+	It("should lock out a client IP after too many failures", func() {
+		serve("alice", "wrong", "10.0.0.1")
+		serve("bob", "wrong", "10.0.0.1")
+		serve("carol", "wrong", "10.0.0.1")
+		recorder, _ := serve("bob", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+		recorder, _ = serve("bob", "secret", "10.0.0.2")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+	It("should not reset the failures of the client IP on success", func() {
+		serve("bob", "wrong", "10.0.0.1")
+		serve("bob", "wrong", "10.0.0.1")
+		recorder, _ := serve("alice", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		serve("bob", "wrong", "10.0.0.1")
+		recorder, _ = serve("alice", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+	})
+	It("should reset the failures of the username on success", func() {
+		serve("alice", "wrong", "10.0.0.1")
+		serve("alice", "wrong", "10.0.0.2")
+		serve("alice", "secret", "10.0.0.3")
+		recorder, _ := serve("alice", "wrong", "10.0.0.4")
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		recorder, _ = serve("alice", "secret", "10.0.0.5")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+	It("should forget failures older than the lockout duration", func() {
+		serve("alice", "wrong", "10.0.0.1")
+		serve("alice", "wrong", "10.0.0.2")
+		now = now.Add(2 * time.Minute)
+		serve("alice", "wrong", "10.0.0.3")
+		recorder, _ := serve("alice", "secret", "10.0.0.4")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+	It("should keep lockouts when pruning failures", func() {
+		for i := 0; i < 3; i++ {
+			serve("alice", "wrong", "10.0.0.1")
+		}
+		for i := 0; i < 600; i++ {
+			serve(fmt.Sprintf("user%v", i), "wrong", fmt.Sprintf("10.1.%v.%v", i/256, i%256))
+		}
+		recorder, _ := serve("alice", "secret", "10.0.0.2")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+		now = now.Add(time.Minute)
+		recorder, _ = serve("alice", "secret", "10.0.0.2")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+	It("should answer lookup errors with 5xx without recording failures", func() {
+		lookupErr = errors.New("connection refused")
+		for i := 0; i < 3; i++ {
+			recorder, _ := serve("alice", "secret", "10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring("connection refused"))
+		}
+		lookupErr = nil
+		recorder, _ := serve("alice", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+	It("should only use proxy headers for the client IP if trusted", func() {
+		fail := func(i int) {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			request.RemoteAddr = "10.0.0.1:1234"
+			request.Header.Set("X-Forwarded-For", fmt.Sprintf("192.168.0.%v", i))
+			request.SetBasicAuth(fmt.Sprintf("user%v", i), "wrong")
+			authenticator.Authenticate(request)
+		}
+		for i := 0; i < 3; i++ {
+			fail(i)
+		}
+		recorder, _ := serve("bob", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+
+		now = now.Add(time.Minute)
+		authenticator = basicauth.New(&basicauth.Options{
+			Lookup: func(username string) (domain.IUser, error) {
+				return users[username], nil
+			},
+			MaxFailures:       3,
+			TrustProxyHeaders: true,
+		})
+		for i := 0; i < 3; i++ {
+			fail(i)
+		}
+		recorder, _ = serve("bob", "secret", "10.0.0.1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+})
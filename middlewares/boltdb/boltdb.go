@@ -0,0 +1,726 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metaBucket stores the indexes declared for each collection
+var metaBucket = []byte("$meta")
+
+// Options type
+// Path is the database file, created if it does not exist.
+// Timeout is how long Open() waits for the lock of a file opened by another process; defaults to 1 second.
+type Options struct {
+	Path    string
+	Timeout time.Duration
+}
+
+// BoltDB type
+// implements IDatabase
+// It stores the BSON encoded documents of a collection in a bucket, keyed by `_id`.
+// Queries are evaluated in memory, only unique and TTL indexes are maintained, to enforce them.
+type BoltDB struct {
+	db      *bolt.DB
+	tx      *bolt.Tx
+	options *Options
+	Now     func() time.Time
+}
+
+// New Returns a new BoltDB object, call Open() to open the database file
+func New(options *Options) *BoltDB {
+	if options.Timeout <= 0 {
+		options.Timeout = 1 * time.Second
+	}
+	return &BoltDB{options: options, Now: time.Now}
+}
+
+// Open opens the database file
+func (db *BoltDB) Open() error {
+	boltDb, err := bolt.Open(db.options.Path, 0600, &bolt.Options{Timeout: db.options.Timeout})
+	if err != nil {
+		return mapError(err)
+	}
+	if err := boltDb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	}); err != nil {
+		boltDb.Close()
+		return err
+	}
+	db.db = boltDb
+	return nil
+}
+
+// Close closes the database file
+func (db *BoltDB) Close() error {
+	if db.db == nil {
+		return nil
+	}
+	return db.db.Close()
+}
+
+// entry is a document read from a collection
+type entry struct {
+	key []byte
+	raw []byte
+	doc bson.M
+}
+
+func validCollection(name string) error {
+	if name == "" || strings.Contains(name, "$") {
+		return errors.New(fmt.Sprintf("Invalid collection name `%v`", name))
+	}
+	return nil
+}
+
+func indexBucket(name string, index domain.Index) []byte {
+	return []byte(name + "$" + index.Name)
+}
+
+// ttlBucket Returns the bucket of a TTL index, which maps the expiry time and key of documents to their key
+func ttlBucket(name string, index domain.Index) []byte {
+	return []byte(name + "$" + index.Name + "$ttl")
+}
+
+func isTTL(index domain.Index) bool {
+	return index.ExpireAfter > 0 && len(index.Fields) > 0
+}
+
+// expiryKey Returns a key of a time, in the same order as the time
+func expiryKey(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano())^(1<<63))
+	return b
+}
+
+// ttlKey Returns the key of a document in the bucket of a TTL index, and false if the document does not expire
+func ttlKey(index domain.Index, doc bson.M, docKey []byte) ([]byte, bool) {
+	value, _ := documents.Get(doc, strings.TrimPrefix(index.Fields[0], "-"))
+	t, ok := value.(time.Time)
+	if !ok {
+		return nil, false
+	}
+	return append(expiryKey(t.Add(index.ExpireAfter)), docKey...), true
+}
+
+// key Returns the key of a document `_id`, or of the values of an index
+func key(value interface{}) []byte {
+	b, err := json.Marshal(documents.Normalize(value))
+	if err != nil {
+		return []byte(fmt.Sprint(value))
+	}
+	return b
+}
+
+// view runs fn in a read-only transaction, mapping its errors to domain errors.
+// fn runs in the transaction of WithTransaction(), if any.
+func (db *BoltDB) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return mapError(err)
+	}
+	if db.tx != nil {
+		return mapError(fn(db.tx))
+	}
+	return mapError(db.db.View(fn))
+}
+
+// update runs fn in a read-write transaction, rolled back if fn returns an error.
+// fn runs in the transaction of WithTransaction(), if any.
+func (db *BoltDB) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return mapError(err)
+	}
+	if db.tx != nil {
+		return mapError(fn(db.tx))
+	}
+	return mapError(db.db.Update(func(tx *bolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		// do not commit if the request has been canceled while waiting for the write lock
+		return ctx.Err()
+	}))
+}
+
+// WithTransaction runs fn in a read-write transaction, committed if fn returns no error and rolled back otherwise.
+// Bolt allows a single read-write transaction at a time, other writes wait until fn returns.
+// An operation of tx that fails may have written part of its changes, fn should return its error.
+func (db *BoltDB) WithTransaction(ctx context.Context, fn func(tx domain.IDatabase) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		return fn(&BoltDB{db: db.db, tx: tx, options: db.options, Now: db.Now})
+	})
+}
+
+// indexes Returns the indexes declared for a collection
+func indexes(tx *bolt.Tx, name string) ([]domain.Index, error) {
+	indexes := []domain.Index{}
+	b := tx.Bucket(metaBucket).Get([]byte("indexes:" + name))
+	if b == nil {
+		return indexes, nil
+	}
+	if err := json.Unmarshal(b, &indexes); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading indexes of `%v`: %v", name, err.Error()))
+	}
+	return indexes, nil
+}
+
+// isExpired Returns true if the document has expired according to a TTL index of the collection
+func (db *BoltDB) isExpired(doc bson.M, indexes []domain.Index) bool {
+	for _, index := range indexes {
+		if index.ExpireAfter <= 0 || len(index.Fields) == 0 {
+			continue
+		}
+		value, _ := documents.Get(doc, strings.TrimPrefix(index.Fields[0], "-"))
+		if t, ok := value.(time.Time); ok && t.Add(index.ExpireAfter).Before(db.Now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// find Returns the documents of a collection that match the query, sorted and limited
+func (db *BoltDB) find(ctx context.Context, tx *bolt.Tx, name string, query domain.Query, limit int, sortFields string) ([]*entry, error) {
+	if err := validCollection(name); err != nil {
+		return nil, err
+	}
+	entries := []*entry{}
+	bucket := tx.Bucket([]byte(name))
+	if bucket == nil {
+		return entries, nil
+	}
+	collectionIndexes, err := indexes(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(v, doc); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+		}
+		if db.isExpired(doc, collectionIndexes) {
+			continue
+		}
+		matched, err := documents.Match(doc, query)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		entries = append(entries, &entry{append([]byte{}, k...), append([]byte{}, v...), doc})
+		// without sort, documents are in key order and the scan stops at the limit
+		if sortFields == "" && limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+	if fields := domain.ParseSort(sortFields); len(fields) > 0 {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return documents.Less(entries[i].doc, entries[j].doc, fields)
+		})
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// put stores the document, replacing previous, and maintains the unique indexes of the collection
+func (db *BoltDB) put(tx *bolt.Tx, name string, doc bson.M, previous *entry) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	id := documents.EnsureID(doc)
+	docKey := key(id)
+	if previous == nil && bucket.Get(docKey) != nil {
+		return domain.ErrDuplicateKey
+	}
+	collectionIndexes, err := indexes(tx, name)
+	if err != nil {
+		return err
+	}
+	for _, index := range collectionIndexes {
+		if isTTL(index) {
+			if err := ttlPut(tx, name, index, doc, docKey, previous); err != nil {
+				return err
+			}
+		}
+		if !index.Unique {
+			continue
+		}
+		if err := indexPut(tx, name, index, doc, docKey, previous); err != nil {
+			return err
+		}
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+	}
+	return bucket.Put(docKey, raw)
+}
+
+// remove removes the document and its unique and TTL index entries
+func (db *BoltDB) remove(tx *bolt.Tx, name string, e *entry) error {
+	collectionIndexes, err := indexes(tx, name)
+	if err != nil {
+		return err
+	}
+	for _, index := range collectionIndexes {
+		if isTTL(index) {
+			if expiry, ok := ttlKey(index, e.doc, e.key); ok {
+				if err := tx.Bucket(ttlBucket(name, index)).Delete(expiry); err != nil {
+					return err
+				}
+			}
+		}
+		if !index.Unique {
+			continue
+		}
+		if indexKey, ok := indexValues(index, e.doc); ok {
+			if err := tx.Bucket(indexBucket(name, index)).Delete(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Bucket([]byte(name)).Delete(e.key)
+}
+
+// indexValues Returns the index key of a document, and false if a sparse index skips the document
+func indexValues(index domain.Index, doc bson.M) ([]byte, bool) {
+	values := []interface{}{}
+	missing := 0
+	for _, field := range index.Fields {
+		value, exists := documents.Get(doc, strings.TrimPrefix(field, "-"))
+		if !exists {
+			missing++
+		}
+		values = append(values, value)
+	}
+	if index.Sparse && missing == len(index.Fields) {
+		return nil, false
+	}
+	return key(values), true
+}
+
+func indexPut(tx *bolt.Tx, name string, index domain.Index, doc bson.M, docKey []byte, previous *entry) error {
+	bucket := tx.Bucket(indexBucket(name, index))
+	if previous != nil {
+		if previousKey, ok := indexValues(index, previous.doc); ok && bytes.Equal(bucket.Get(previousKey), previous.key) {
+			if err := bucket.Delete(previousKey); err != nil {
+				return err
+			}
+		}
+	}
+	indexKey, ok := indexValues(index, doc)
+	if !ok {
+		return nil
+	}
+	if existing := bucket.Get(indexKey); existing != nil && !bytes.Equal(existing, docKey) {
+		return domain.ErrDuplicateKey
+	}
+	return bucket.Put(indexKey, docKey)
+}
+
+// ttlPut stores the expiry time of the document in the bucket of a TTL index, replacing the one of previous
+func ttlPut(tx *bolt.Tx, name string, index domain.Index, doc bson.M, docKey []byte, previous *entry) error {
+	bucket := tx.Bucket(ttlBucket(name, index))
+	if previous != nil {
+		if previousKey, ok := ttlKey(index, previous.doc, previous.key); ok {
+			if err := bucket.Delete(previousKey); err != nil {
+				return err
+			}
+		}
+	}
+	expiry, ok := ttlKey(index, doc, docKey)
+	if !ok {
+		return nil
+	}
+	return bucket.Put(expiry, docKey)
+}
+
+// purge removes the documents of a collection expired by its TTL indexes.
+// It only reads the expired entries of the TTL index buckets, which are sorted by expiry time.
+func (db *BoltDB) purge(ctx context.Context, tx *bolt.Tx, name string) error {
+	collectionIndexes, err := indexes(tx, name)
+	if err != nil {
+		return err
+	}
+	now := expiryKey(db.Now())
+	expired := map[string]bool{}
+	for _, index := range collectionIndexes {
+		if !isTTL(index) {
+			continue
+		}
+		bucket := tx.Bucket(ttlBucket(name, index))
+		if bucket == nil {
+			continue
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && bytes.Compare(k[:len(now)], now) < 0; k, v = cursor.Next() {
+			expired[string(v)] = true
+		}
+	}
+	for docKey := range expired {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		raw := tx.Bucket([]byte(name)).Get([]byte(docKey))
+		if raw == nil {
+			continue
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(raw, doc); err != nil {
+			return errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+		}
+		if err := db.remove(tx, name, &entry{[]byte(docKey), nil, doc}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *BoltDB) FindOne(ctx context.Context, name string, query domain.Query, result interface{}) error {
+	return db.FindOneProjection(ctx, name, query, nil, result)
+}
+
+// FindOneProjection decodes only the projected fields and `_id` of the first document that matches the query
+func (db *BoltDB) FindOneProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}) error {
+	return db.view(ctx, func(tx *bolt.Tx) error {
+		entries, err := db.find(ctx, tx, name, query, 1, "")
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return domain.ErrNotFound
+		}
+		return documents.Decode(documents.Project(entries[0].doc, projection), result)
+	})
+}
+
+func (db *BoltDB) FindAll(ctx context.Context, name string, query domain.Query, result interface{}, limit int, sort string) error {
+	return db.FindAllProjection(ctx, name, query, nil, result, limit, sort)
+}
+
+// FindAllProjection decodes only the projected fields and `_id` of the documents that match the query
+func (db *BoltDB) FindAllProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}, limit int, sort string) error {
+	if sort == "" {
+		sort = "-_id"
+	}
+	return db.view(ctx, func(tx *bolt.Tx) error {
+		entries, err := db.find(ctx, tx, name, query, limit, sort)
+		if err != nil {
+			return err
+		}
+		raws := make([][]byte, len(entries))
+		for i, e := range entries {
+			if raws[i], err = documents.ProjectRaw(e.raw, projection); err != nil {
+				return err
+			}
+		}
+		return documents.UnmarshalAll(raws, result)
+	})
+}
+
+// FindPage Returns a page of the documents that match the query, using keyset pagination on the sort fields
+func (db *BoltDB) FindPage(ctx context.Context, name string, query domain.Query, result interface{}, sort string, page domain.PageRequest) (*domain.Page, error) {
+	return documents.FindPage(ctx, db, name, query, result, sort, page)
+}
+
+// Aggregate runs the stages of the pipeline in memory, on the documents selected by its first Match stages
+func (db *BoltDB) Aggregate(ctx context.Context, name string, pipeline []domain.Stage, result interface{}) error {
+	return documents.Aggregate(ctx, db, name, pipeline, result)
+}
+
+func (db *BoltDB) Count(ctx context.Context, name string, query domain.Query) (int, error) {
+	count := 0
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		entries, err := db.find(ctx, tx, name, query, 0, "")
+		count = len(entries)
+		return err
+	})
+	return count, err
+}
+
+func (db *BoltDB) Insert(ctx context.Context, name string, obj interface{}) error {
+	if err := validCollection(name); err != nil {
+		return err
+	}
+	doc, err := documents.Encode(obj)
+	if err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		if err := db.purge(ctx, tx, name); err != nil {
+			return err
+		}
+		return db.put(tx, name, doc, nil)
+	})
+}
+
+// Update applies the change to the first document that matches the query, atomically
+func (db *BoltDB) Update(ctx context.Context, name string, query domain.Query, change domain.Change, result interface{}) error {
+	var resultDoc bson.M
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		if err := db.purge(ctx, tx, name); err != nil {
+			return err
+		}
+		entries, err := db.find(ctx, tx, name, query, 1, "")
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			if !change.Upsert || change.Remove {
+				return domain.ErrNotFound
+			}
+			doc, err := documents.FromQuery(query)
+			if err != nil {
+				return err
+			}
+			if doc, err = documents.ApplyUpdate(doc, change.Update); err != nil {
+				return err
+			}
+			if err := db.put(tx, name, doc, nil); err != nil {
+				return err
+			}
+			if change.ReturnNew {
+				resultDoc = doc
+			}
+			return nil
+		}
+
+		previous := entries[0]
+		resultDoc = previous.doc
+		if change.Remove {
+			return db.remove(tx, name, previous)
+		}
+		updated, err := documents.ApplyUpdate(previous.doc, change.Update)
+		if err != nil {
+			return err
+		}
+		if change.ReturnNew {
+			resultDoc = updated
+		}
+		return db.put(tx, name, updated, previous)
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil || resultDoc == nil {
+		return nil
+	}
+	return documents.Decode(resultDoc, result)
+}
+
+// UpdateAll applies the update operators to every document that matches the query, atomically
+func (db *BoltDB) UpdateAll(ctx context.Context, name string, query domain.Query, change domain.Query) (int, error) {
+	updated := 0
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		if err := db.purge(ctx, tx, name); err != nil {
+			return err
+		}
+		entries, err := db.find(ctx, tx, name, query, 0, "")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			doc, err := documents.ApplyUpdate(e.doc, change)
+			if err != nil {
+				return err
+			}
+			if err := db.put(tx, name, doc, e); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (db *BoltDB) RemoveOne(ctx context.Context, name string, query domain.Query) error {
+	return db.Update(ctx, name, query, domain.Change{Remove: true}, nil)
+}
+
+func (db *BoltDB) RemoveAll(ctx context.Context, name string, query domain.Query) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		entries, err := db.find(ctx, tx, name, query, 0, "")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := db.remove(tx, name, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *BoltDB) DropCollection(ctx context.Context, name string) error {
+	if err := validCollection(name); err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		return dropCollection(tx, name)
+	})
+}
+
+func dropCollection(tx *bolt.Tx, name string) error {
+	collectionIndexes, err := indexes(tx, name)
+	if err != nil {
+		return err
+	}
+	for _, index := range collectionIndexes {
+		for _, bucket := range [][]byte{indexBucket(name, index), ttlBucket(name, index)} {
+			if tx.Bucket(bucket) != nil {
+				if err := tx.DeleteBucket(bucket); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := tx.Bucket(metaBucket).Delete([]byte("indexes:" + name)); err != nil {
+		return err
+	}
+	if tx.Bucket([]byte(name)) == nil {
+		return nil
+	}
+	return tx.DeleteBucket([]byte(name))
+}
+
+func (db *BoltDB) Exists(ctx context.Context, name string, query domain.Query) bool {
+	var result bson.M
+	err := db.FindOne(ctx, name, query, &result)
+	return (err == nil)
+}
+
+func (db *BoltDB) DropDatabase(ctx context.Context) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		names := []string{}
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if validCollection(string(name)) == nil {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := dropCollection(tx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EnsureIndex declares an index of a collection.
+// Unique indexes are built from the existing documents, and return ErrDuplicateKey if two documents have the same key.
+// Documents older than Index.ExpireAfter are removed before the collection is written, and skipped when it is read;
+// TTL indexes are built from the existing documents, sorted by expiry time.
+func (db *BoltDB) EnsureIndex(ctx context.Context, name string, index domain.Index) error {
+	if err := validCollection(name); err != nil {
+		return err
+	}
+	if len(index.Fields) == 0 {
+		return errors.New("Invalid index, no fields")
+	}
+	if index.Name == "" {
+		index.Name = strings.Join(index.Fields, "_")
+	}
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		collectionIndexes, err := indexes(tx, name)
+		if err != nil {
+			return err
+		}
+		for _, existing := range collectionIndexes {
+			if existing.Name == index.Name {
+				return nil
+			}
+		}
+		bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		if index.Unique {
+			indexBucket, err := tx.CreateBucket(indexBucket(name, index))
+			if err != nil {
+				return err
+			}
+			err = bucket.ForEach(func(k, v []byte) error {
+				doc := bson.M{}
+				if err := bson.Unmarshal(v, doc); err != nil {
+					return err
+				}
+				indexKey, ok := indexValues(index, doc)
+				if !ok {
+					return nil
+				}
+				if indexBucket.Get(indexKey) != nil {
+					return domain.ErrDuplicateKey
+				}
+				return indexBucket.Put(indexKey, k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if isTTL(index) {
+			ttlBucket, err := tx.CreateBucket(ttlBucket(name, index))
+			if err != nil {
+				return err
+			}
+			err = bucket.ForEach(func(k, v []byte) error {
+				doc := bson.M{}
+				if err := bson.Unmarshal(v, doc); err != nil {
+					return err
+				}
+				if expiry, ok := ttlKey(index, doc, k); ok {
+					return ttlBucket.Put(expiry, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		b, err := json.Marshal(append(collectionIndexes, index))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put([]byte("indexes:"+name), b)
+	})
+}
+
+// mapError Returns the domain error of bolt and context errors
+func mapError(err error) error {
+	switch err {
+	case context.DeadlineExceeded, bolt.ErrTimeout:
+		return domain.ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+package boltdb_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestBoltDB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BoltDB Suite")
+}
@@ -0,0 +1,271 @@
+package boltdb_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	"github.com/sogko/slumber/test_helpers"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var _ = Describe("BoltDB", func() {
+
+	var dir string
+	var db *boltdb.BoltDB
+	ctx := context.Background()
+
+	findNames := func(query domain.Query, sort string) []string {
+		return test_helpers.FindUserNames(ctx, db, query, sort)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "boltdb")
+		Expect(err).To(BeNil())
+		db = boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+		Expect(db.Open()).To(BeNil())
+		test_helpers.InsertDatabaseUsers(ctx, db)
+	})
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("FindAll()", func() {
+		It("should evaluate the query subset", func() {
+			Expect(findNames(domain.Query{"age": 25}, "name")).To(Equal([]string{"bob", "dave"}))
+			Expect(findNames(domain.Query{"tags": "staff"}, "name")).To(Equal([]string{"alice", "bob"}))
+			Expect(findNames(domain.Query{"name": domain.Query{"$in": []string{"alice", "carol"}}}, "name")).To(Equal([]string{"alice", "carol"}))
+			Expect(findNames(domain.Query{"age": domain.Query{"$gt": 25, "$lt": 35}}, "")).To(Equal([]string{"alice"}))
+			Expect(findNames(domain.Query{"$or": []domain.Query{{"name": "dave"}, {"age": 35}}}, "name")).To(Equal([]string{"carol", "dave"}))
+		})
+		It("should sort and limit documents", func() {
+			Expect(findNames(nil, "-age,name")).To(Equal([]string{"carol", "alice", "bob", "dave"}))
+			var users []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "users", nil, &users, 2, "age,-name")).To(BeNil())
+			Expect(test_helpers.UserNames(users)).To(Equal([]string{"dave", "bob"}))
+		})
+		It("should return error for invalid queries and collection names", func() {
+			var users []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "users", domain.Query{"$where": "true"}, &users, 0, "")).ToNot(BeNil())
+			Expect(db.Insert(ctx, "", &test_helpers.DatabaseUser{Name: "erin"})).ToNot(BeNil())
+		})
+	})
+
+	Describe("FindOne(), Count() and Exists()", func() {
+		It("should find documents", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.FindOne(ctx, "users", domain.Query{"name": "carol"}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(35))
+			Expect(user.ID.Valid()).To(BeTrue())
+			Expect(db.FindOne(ctx, "users", domain.Query{"name": "nobody"}, &user)).To(Equal(domain.ErrNotFound))
+			Expect(db.FindOne(ctx, "unknown", nil, &user)).To(Equal(domain.ErrNotFound))
+			count, err := db.Count(ctx, "users", domain.Query{"age": 25})
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(2))
+			Expect(db.Exists(ctx, "users", domain.Query{"name": "bob"})).To(BeTrue())
+			Expect(db.Exists(ctx, "users", domain.Query{"name": "nobody"})).To(BeFalse())
+		})
+	})
+
+	Describe("Update()", func() {
+		It("should update the first document and return its previous or new version", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"age": 26}},
+			}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(25))
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update:    domain.Query{"$inc": domain.Query{"age": 1}},
+				ReturnNew: true,
+			}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(27))
+		})
+		It("should insert a document with Upsert and remove it with Remove", func() {
+			Expect(db.Update(ctx, "users", domain.Query{"name": "erin"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"age": 40}},
+				Upsert: true,
+			}, nil)).To(BeNil())
+			Expect(findNames(domain.Query{"age": 40}, "")).To(Equal([]string{"erin"}))
+			Expect(db.Update(ctx, "users", domain.Query{"name": "erin"}, domain.Change{Remove: true}, nil)).To(BeNil())
+			Expect(findNames(domain.Query{"age": 40}, "")).To(BeEmpty())
+			Expect(db.RemoveOne(ctx, "users", domain.Query{"name": "erin"})).To(Equal(domain.ErrNotFound))
+		})
+		It("should apply concurrent updates atomically", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+						Update: domain.Query{"$inc": domain.Query{"age": 1}},
+					}, nil)).To(BeNil())
+				}()
+			}
+			wg.Wait()
+			var user test_helpers.DatabaseUser
+			Expect(db.FindOne(ctx, "users", domain.Query{"name": "bob"}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(45))
+		})
+	})
+
+	Describe("UpdateAll() and RemoveAll()", func() {
+		It("should update and remove every document that matches the query", func() {
+			updated, err := db.UpdateAll(ctx, "users", domain.Query{"age": 25}, domain.Query{"$set": domain.Query{"age": 26}})
+			Expect(err).To(BeNil())
+			Expect(updated).To(Equal(2))
+			Expect(db.RemoveAll(ctx, "users", domain.Query{"age": 26})).To(BeNil())
+			Expect(findNames(nil, "name")).To(Equal([]string{"alice", "carol"}))
+		})
+	})
+
+	Describe("EnsureIndex()", func() {
+		It("should enforce unique indexes on inserts and updates", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"name"}, Unique: true})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "alice"})).To(Equal(domain.ErrDuplicateKey))
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"name": "alice"}},
+			}, nil)).To(Equal(domain.ErrDuplicateKey))
+			Expect(findNames(domain.Query{"name": "bob"}, "")).To(Equal([]string{"bob"}))
+
+			// the index entry of a removed or renamed document is released
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"name": "robert"}},
+			}, nil)).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "bob"})).To(BeNil())
+			Expect(db.RemoveOne(ctx, "users", domain.Query{"name": "carol"})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "carol"})).To(BeNil())
+		})
+		It("should skip documents without the fields of sparse indexes", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"email"}, Unique: true, Sparse: true})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "erin"})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "frank", Email: "bob@example.com"})).To(Equal(domain.ErrDuplicateKey))
+		})
+		It("should return error for unique indexes on duplicate values", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"age"}, Unique: true})).To(Equal(domain.ErrDuplicateKey))
+		})
+		It("should remove documents expired by a TTL index", func() {
+			now := time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC)
+			db.Now = func() time.Time {
+				return now
+			}
+			Expect(db.EnsureIndex(ctx, "sessions", domain.Index{Fields: []string{"createdAt"}, ExpireAfter: time.Hour})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "old", CreatedAt: now.Add(-30 * time.Minute)})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "new", CreatedAt: now})).To(BeNil())
+			var sessions []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "name")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"new", "old"}))
+			now = now.Add(45 * time.Minute)
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "name")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"new"}))
+		})
+		It("should remove documents expired by a TTL index on writes, using their updated expiry time", func() {
+			now := time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC)
+			db.Now = func() time.Time {
+				return now
+			}
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "stale", CreatedAt: now.Add(-30 * time.Minute)})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "refreshed", CreatedAt: now.Add(-30 * time.Minute)})).To(BeNil())
+			Expect(db.EnsureIndex(ctx, "sessions", domain.Index{Fields: []string{"createdAt"}, ExpireAfter: time.Hour})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", bson.M{"name": "forever"})).To(BeNil())
+			Expect(db.Update(ctx, "sessions", domain.Query{"name": "refreshed"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"createdAt": now}},
+			}, nil)).To(BeNil())
+
+			now = now.Add(45 * time.Minute)
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "new", CreatedAt: now})).To(BeNil())
+			now = now.Add(-45 * time.Minute)
+			var sessions []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "name")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"forever", "new", "refreshed"}))
+
+			now = now.Add(2 * time.Hour)
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "newest", CreatedAt: now})).To(BeNil())
+			now = now.Add(-2 * time.Hour)
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "name")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"forever", "newest"}))
+		})
+	})
+
+	Describe("FindPage()", func() {
+		It("should walk every document once across pages with ties on the sort field", func() {
+			for _, name := range []string{"erin", "frank", "grace"} {
+				Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: name, Age: 25})).To(BeNil())
+			}
+			seen := []string{}
+			cursor := ""
+			for pages := 1; ; pages++ {
+				Expect(pages).To(BeNumerically("<=", 4))
+				var users []test_helpers.DatabaseUser
+				page, err := db.FindPage(ctx, "users", nil, &users, "age", domain.PageRequest{Limit: 2, Cursor: cursor})
+				Expect(err).To(BeNil())
+				Expect(len(users)).To(BeNumerically("<=", 2))
+				seen = append(seen, test_helpers.UserNames(users)...)
+				if !page.HasMore {
+					Expect(pages).To(Equal(4))
+					break
+				}
+				cursor = page.NextCursor
+			}
+			Expect(seen).To(HaveLen(7))
+			Expect(seen).To(ConsistOf("alice", "bob", "carol", "dave", "erin", "frank", "grace"))
+			// the five users aged 25 come first, in any order
+			Expect(seen[5:]).To(Equal([]string{"alice", "carol"}))
+		})
+		It("should return ErrInvalidCursor for a cursor of another sort", func() {
+			var users []test_helpers.DatabaseUser
+			page, err := db.FindPage(ctx, "users", nil, &users, "age", domain.PageRequest{Limit: 1})
+			Expect(err).To(BeNil())
+			_, err = db.FindPage(ctx, "users", nil, &users, "name", domain.PageRequest{Limit: 1, Cursor: page.NextCursor})
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+		})
+	})
+
+	Describe("Aggregate()", func() {
+		It("should run the pipeline on the documents of the collection", func() {
+			var groups []struct {
+				Age   int `bson:"_id"`
+				Users int `bson:"users"`
+			}
+			Expect(db.Aggregate(ctx, "users", []domain.Stage{
+				domain.MatchStage(domain.Query{"age": domain.Query{"$lt": 35}}),
+				domain.GroupStage([]string{"age"}, map[string]domain.Accumulator{"users": domain.Count()}),
+				domain.SortStage("-users,_id"),
+			}, &groups)).To(BeNil())
+			Expect(groups).To(HaveLen(2))
+			Expect(groups[0].Age).To(Equal(25))
+			Expect(groups[0].Users).To(Equal(2))
+			Expect(groups[1].Age).To(Equal(30))
+			Expect(groups[1].Users).To(Equal(1))
+		})
+	})
+
+	Describe("DropCollection()", func() {
+		It("should remove the documents and indexes of the collection", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"name"}, Unique: true})).To(BeNil())
+			Expect(db.DropCollection(ctx, "users")).To(BeNil())
+			Expect(findNames(nil, "")).To(BeEmpty())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "alice"})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "alice"})).To(BeNil())
+		})
+	})
+})
+
+var _ = test_helpers.DescribeDatabaseConformance("BoltDB", func() (domain.IDatabase, func()) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	Expect(err).To(BeNil())
+	db := boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+	Expect(db.Open()).To(BeNil())
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+})
@@ -0,0 +1,195 @@
+package documents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Aggregate runs an aggregation pipeline in memory on the documents of a collection, see Pipeline().
+// The first stages, if they are Match stages, select the documents loaded from the database.
+func Aggregate(ctx context.Context, db domain.IDatabase, name string, pipeline []domain.Stage, result interface{}) error {
+	for _, stage := range pipeline {
+		if err := stage.Validate(); err != nil {
+			return err
+		}
+	}
+	queries := []domain.Query{}
+	for len(pipeline) > 0 && pipeline[0].Match != nil {
+		queries = append(queries, pipeline[0].Match)
+		pipeline = pipeline[1:]
+	}
+	var query domain.Query
+	switch len(queries) {
+	case 0:
+	case 1:
+		query = queries[0]
+	default:
+		query = domain.Query{"$and": queries}
+	}
+
+	var docs []bson.M
+	if err := db.FindAll(ctx, name, query, &docs, 0, IDField); err != nil {
+		return err
+	}
+	docs, err := Pipeline(docs, pipeline)
+	if err != nil {
+		return err
+	}
+	raws := make([][]byte, len(docs))
+	for i, doc := range docs {
+		if raws[i], err = bson.Marshal(doc); err != nil {
+			return errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+		}
+	}
+	return UnmarshalAll(raws, result)
+}
+
+// Pipeline Returns the documents resulting of the stages of an aggregation pipeline
+func Pipeline(docs []bson.M, pipeline []domain.Stage) ([]bson.M, error) {
+	for _, stage := range pipeline {
+		if err := stage.Validate(); err != nil {
+			return nil, err
+		}
+		switch {
+		case stage.Match != nil:
+			matched := []bson.M{}
+			for _, doc := range docs {
+				ok, err := Match(doc, stage.Match)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, doc)
+				}
+			}
+			docs = matched
+		case stage.Group != nil:
+			docs = group(docs, stage.Group)
+		case stage.Sort != "":
+			Sort(docs, stage.Sort)
+		case stage.Limit > 0:
+			if len(docs) > stage.Limit {
+				docs = docs[:stage.Limit]
+			}
+		case stage.Project != nil:
+			for i, doc := range docs {
+				docs[i] = Project(doc, stage.Project)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// accumulator is the state of an Accumulator for a group
+type accumulator struct {
+	domain.Accumulator
+	sum     float64
+	integer bool
+	count   int
+	value   interface{}
+}
+
+func (acc *accumulator) add(doc bson.M) {
+	if acc.Operator == domain.AccumulatorCount {
+		acc.count++
+		return
+	}
+	value, ok := Get(doc, acc.Field)
+	if !ok || value == nil {
+		return
+	}
+	switch acc.Operator {
+	case domain.AccumulatorSum, domain.AccumulatorAvg:
+		n, ok := toFloat(value)
+		if !ok {
+			return
+		}
+		switch value.(type) {
+		case float32, float64:
+			acc.integer = false
+		}
+		acc.sum += n
+		acc.count++
+	case domain.AccumulatorMin, domain.AccumulatorMax:
+		result := sortCompare(Normalize(value), Normalize(acc.value))
+		if acc.count == 0 || (acc.Operator == domain.AccumulatorMin && result < 0) || (acc.Operator == domain.AccumulatorMax && result > 0) {
+			acc.value = value
+		}
+		acc.count++
+	}
+}
+
+func (acc *accumulator) result() interface{} {
+	switch acc.Operator {
+	case domain.AccumulatorCount:
+		return acc.count
+	case domain.AccumulatorSum:
+		if acc.integer {
+			return int64(acc.sum)
+		}
+		return acc.sum
+	case domain.AccumulatorAvg:
+		if acc.count == 0 {
+			return nil
+		}
+		return acc.sum / float64(acc.count)
+	}
+	return acc.value
+}
+
+// group Returns the groups of documents, in the order of their first document
+func group(docs []bson.M, g *domain.Group) []bson.M {
+	keys := []string{}
+	ids := map[string]interface{}{}
+	accumulators := map[string]map[string]*accumulator{}
+	for _, doc := range docs {
+		id := groupID(doc, g.By)
+		b, err := json.Marshal(Normalize(id))
+		if err != nil {
+			b = []byte(fmt.Sprint(id))
+		}
+		key := string(b)
+		if _, ok := accumulators[key]; !ok {
+			keys = append(keys, key)
+			ids[key] = id
+			accumulators[key] = map[string]*accumulator{}
+			for name, acc := range g.Fields {
+				accumulators[key][name] = &accumulator{Accumulator: acc, integer: true}
+			}
+		}
+		for _, acc := range accumulators[key] {
+			acc.add(doc)
+		}
+	}
+
+	groups := []bson.M{}
+	for _, key := range keys {
+		doc := bson.M{IDField: ids[key]}
+		for name, acc := range accumulators[key] {
+			doc[name] = acc.result()
+		}
+		groups = append(groups, doc)
+	}
+	return groups
+}
+
+// groupID Returns the `_id` of the group of a document, see domain.Group
+func groupID(doc bson.M, by []string) interface{} {
+	if len(by) == 0 {
+		return nil
+	}
+	if len(by) == 1 {
+		value, _ := Get(doc, by[0])
+		return value
+	}
+	id := bson.M{}
+	for _, field := range by {
+		value, _ := Get(doc, field)
+		id[domain.GroupID(field)] = value
+	}
+	return id
+}
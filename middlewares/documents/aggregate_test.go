@@ -0,0 +1,89 @@
+package documents_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Aggregate", func() {
+
+	var docs []bson.M
+
+	BeforeEach(func() {
+		docs = []bson.M{
+			{"country": "SG", "profile": bson.M{"plan": "pro"}, "amount": 10, "rating": 4.5, "code": "b"},
+			{"country": "US", "profile": bson.M{"plan": "free"}, "amount": 5, "rating": 3, "code": 7},
+			{"country": "SG", "profile": bson.M{"plan": "free"}, "amount": int64(2), "code": 2.5},
+			{"country": "SG", "profile": bson.M{"plan": "pro"}, "amount": 1.5, "rating": "n/a"},
+			{"country": "US", "profile": bson.M{"plan": "free"}, "amount": "none", "code": "a"},
+		}
+	})
+
+	Describe("Pipeline()", func() {
+		It("should sum integers as an integer and any float as a float", func() {
+			groups, err := documents.Pipeline(docs, []domain.Stage{
+				domain.GroupStage([]string{"country"}, map[string]domain.Accumulator{
+					"total": domain.Sum("amount"),
+					"avg":   domain.Avg("amount"),
+					"users": domain.Count(),
+				}),
+			})
+			Expect(err).To(BeNil())
+			Expect(groups).To(Equal([]bson.M{
+				{"_id": "SG", "total": 13.5, "avg": 4.5, "users": 3},
+				{"_id": "US", "total": int64(5), "avg": 5.0, "users": 2},
+			}))
+		})
+		It("should return the minimum and maximum of values of mixed types in sort order", func() {
+			groups, err := documents.Pipeline(docs, []domain.Stage{
+				domain.GroupStage(nil, map[string]domain.Accumulator{
+					"minCode":   domain.Min("code"),
+					"maxCode":   domain.Max("code"),
+					"minRating": domain.Min("rating"),
+					"maxRating": domain.Max("rating"),
+					"missing":   domain.Max("missing"),
+				}),
+			})
+			Expect(err).To(BeNil())
+			Expect(groups).To(Equal([]bson.M{
+				{"_id": nil, "minCode": 2.5, "maxCode": "b", "minRating": 3, "maxRating": "n/a", "missing": nil},
+			}))
+		})
+		It("should group by several fields with a document `_id`", func() {
+			groups, err := documents.Pipeline(docs, []domain.Stage{
+				domain.GroupStage([]string{"country", "profile.plan"}, map[string]domain.Accumulator{
+					"users": domain.Count(),
+				}),
+			})
+			Expect(err).To(BeNil())
+			Expect(groups).To(Equal([]bson.M{
+				{"_id": bson.M{"country": "SG", "profile_plan": "pro"}, "users": 2},
+				{"_id": bson.M{"country": "US", "profile_plan": "free"}, "users": 2},
+				{"_id": bson.M{"country": "SG", "profile_plan": "free"}, "users": 1},
+			}))
+		})
+		It("should match, sort, limit and project the groups", func() {
+			groups, err := documents.Pipeline(docs, []domain.Stage{
+				domain.MatchStage(domain.Query{"profile.plan": "free"}),
+				domain.GroupStage([]string{"country"}, map[string]domain.Accumulator{
+					"users": domain.Count(),
+					"total": domain.Sum("amount"),
+				}),
+				domain.SortStage("-users"),
+				domain.LimitStage(1),
+				domain.ProjectStage("users"),
+			})
+			Expect(err).To(BeNil())
+			Expect(groups).To(Equal([]bson.M{{"_id": "US", "users": 2}}))
+		})
+		It("should return error for invalid stages", func() {
+			_, err := documents.Pipeline(docs, []domain.Stage{{}})
+			Expect(err).ToNot(BeNil())
+			_, err = documents.Pipeline(docs, []domain.Stage{domain.MatchStage(domain.Query{"$where": "true"})})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,693 @@
+package documents
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IDField is the field that uniquely identifies a document in a collection
+const IDField = "_id"
+
+// TimeFormat is the format of times in normalized documents.
+// It has a fixed width so that normalized times sort in chronological order.
+const TimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Encode Returns the document of obj, using the same `bson` field tags as the MongoDB backend
+func Encode(obj interface{}) (bson.M, error) {
+	b, err := bson.Marshal(obj)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(b, doc); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+	}
+	return doc, nil
+}
+
+// Decode decodes the document into result, a pointer to a struct or a map
+func Decode(doc bson.M, result interface{}) error {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+	}
+	return bson.Unmarshal(b, result)
+}
+
+// UnmarshalAll decodes BSON encoded documents into result, a pointer to a slice
+func UnmarshalAll(raws [][]byte, result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return errors.New("Result argument must be a slice address")
+	}
+	slicev := resultv.Elem()
+	slicev = slicev.Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, raw := range raws {
+		elemp := reflect.New(elemt)
+		if err := bson.Unmarshal(raw, elemp.Interface()); err != nil {
+			return errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return nil
+}
+
+// EnsureID Returns the `_id` of the document, setting it to a new ObjectId if it has none
+func EnsureID(doc bson.M) interface{} {
+	id, ok := doc[IDField]
+	if !ok || id == nil || id == "" {
+		id = bson.NewObjectId()
+		doc[IDField] = id
+	}
+	return id
+}
+
+// Normalize Returns v converted to the values of a JSON document, for storing and comparing documents
+// in backends that do not support BSON types: ObjectIds are converted to their hex representation,
+// times to UTC in TimeFormat, and documents to map[string]interface{}.
+func Normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, float64, int, int64:
+		return v
+	case bson.ObjectId:
+		return v.Hex()
+	case time.Time:
+		return v.UTC().Format(TimeFormat)
+	case bson.M:
+		return normalizeMap(v)
+	case map[string]interface{}:
+		return normalizeMap(v)
+	case domain.Query:
+		return normalizeMap(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = Normalize(item)
+		}
+		return list
+	}
+	// convert other types, for eg: typed strings or structs, to the values they are stored as
+	doc, err := Encode(bson.M{"v": v})
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return Normalize(doc["v"])
+}
+
+func normalizeMap(m map[string]interface{}) map[string]interface{} {
+	normalized := map[string]interface{}{}
+	for key, value := range m {
+		normalized[key] = Normalize(value)
+	}
+	return normalized
+}
+
+// Get Returns the value of a field of the document, for eg: `profile.name`, and false if the field is missing
+func Get(doc bson.M, field string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(field, ".") {
+		m, ok := AsMap(value)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Set sets the value of a field of the document, creating the parent documents if they are missing
+func Set(doc bson.M, field string, value interface{}) error {
+	keys := strings.Split(field, ".")
+	m := map[string]interface{}(doc)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := m[key]
+		if !ok || child == nil {
+			child = bson.M{}
+			m[key] = child
+		}
+		if m, ok = AsMap(child); !ok {
+			return errors.New(fmt.Sprintf("Cannot set field `%v`, `%v` is not a document", field, key))
+		}
+	}
+	m[keys[len(keys)-1]] = value
+	return nil
+}
+
+// Unset removes a field of the document
+func Unset(doc bson.M, field string) {
+	keys := strings.Split(field, ".")
+	m := map[string]interface{}(doc)
+	for _, key := range keys[:len(keys)-1] {
+		var ok bool
+		if m, ok = AsMap(m[key]); !ok {
+			return
+		}
+	}
+	delete(m, keys[len(keys)-1])
+}
+
+// asMap Returns v if it is a document, other maps with string keys, for eg: domain.Query, are copied
+func AsMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case bson.M:
+		return v, true
+	case map[string]interface{}:
+		return v, true
+	case domain.Query:
+		return v, true
+	}
+	mapv := reflect.ValueOf(v)
+	if mapv.Kind() != reflect.Map || mapv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := map[string]interface{}{}
+	for _, key := range mapv.MapKeys() {
+		m[key.String()] = mapv.MapIndex(key).Interface()
+	}
+	return m, true
+}
+
+// Project Returns a copy of the document with only the projected fields and `_id`,
+// or the document itself if the projection is empty
+func Project(doc bson.M, projection domain.Projection) bson.M {
+	if len(projection) == 0 {
+		return doc
+	}
+	projected := bson.M{}
+	if id, ok := doc[IDField]; ok {
+		projected[IDField] = id
+	}
+	for _, field := range projection {
+		if value, ok := Get(doc, field); ok {
+			Set(projected, field, value)
+		}
+	}
+	return projected
+}
+
+// ProjectRaw Returns the BSON encoding of a BSON encoded document with only the projected fields, see Project()
+func ProjectRaw(raw []byte, projection domain.Projection) ([]byte, error) {
+	if len(projection) == 0 {
+		return raw, nil
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+	}
+	return bson.Marshal(Project(doc, projection))
+}
+
+// IsOperatorQuery Returns true if the keys of the query are operators, for eg: `$set` or `$gt`
+func IsOperatorQuery(query map[string]interface{}) bool {
+	for key := range query {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyUpdate Returns the document updated with a domain.Change update.
+// An update without operators replaces the document but keeps its `_id`;
+// otherwise `$set`, `$unset` and `$inc` operators are applied to a copy of the document.
+func ApplyUpdate(doc bson.M, update map[string]interface{}) (bson.M, error) {
+	if !IsOperatorQuery(update) {
+		updated, err := Encode(update)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := doc[IDField]; ok {
+			updated[IDField] = id
+		}
+		return updated, nil
+	}
+
+	updated, err := Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for operator, arguments := range update {
+		fields, ok := AsMap(arguments)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Invalid update, `%v` expects a document", operator))
+		}
+		for field, value := range fields {
+			if field == IDField {
+				return nil, errors.New("Invalid update, `_id` cannot be updated")
+			}
+			switch operator {
+			case "$set":
+				err = Set(updated, field, value)
+			case "$unset":
+				Unset(updated, field)
+			case "$inc":
+				err = inc(updated, field, value)
+			default:
+				return nil, errors.New(fmt.Sprintf("Invalid update, unsupported operator `%v`", operator))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return updated, nil
+}
+
+func inc(doc bson.M, field string, value interface{}) error {
+	delta, ok := toFloat(value)
+	if !ok {
+		return errors.New(fmt.Sprintf("Invalid update, `$inc` of field `%v` expects a number", field))
+	}
+	current, exists := Get(doc, field)
+	if !exists || current == nil {
+		return Set(doc, field, value)
+	}
+	switch current := current.(type) {
+	case int:
+		if d, ok := value.(int); ok {
+			return Set(doc, field, current+d)
+		}
+	case int64:
+		if d, ok := value.(int64); ok {
+			return Set(doc, field, current+d)
+		}
+	}
+	number, ok := toFloat(current)
+	if !ok {
+		return errors.New(fmt.Sprintf("Invalid update, cannot `$inc` non-numeric field `%v`", field))
+	}
+	return Set(doc, field, number+delta)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// FromQuery Returns the document inserted by an upsert: the fields of the query that are matched by equality
+func FromQuery(query map[string]interface{}) (bson.M, error) {
+	doc := bson.M{}
+	for field, value := range query {
+		if strings.HasPrefix(field, "$") {
+			continue
+		}
+		if m, ok := AsMap(value); ok && IsOperatorQuery(m) {
+			if eq, ok := m["$eq"]; ok {
+				value = eq
+			} else {
+				continue
+			}
+		}
+		if err := Set(doc, field, value); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// Match Returns true if the document matches the query, see domain.Query for the supported operators
+func Match(doc bson.M, query map[string]interface{}) (bool, error) {
+	for key, value := range query {
+		var matched bool
+		var err error
+		switch key {
+		case "$and", "$or":
+			matched, err = matchLogical(doc, key, value)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, errors.New(fmt.Sprintf("Invalid query, unsupported operator `%v`", key))
+			}
+			matched, err = matchField(doc, key, value)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, operator string, value interface{}) (bool, error) {
+	listv := reflect.ValueOf(value)
+	if listv.Kind() != reflect.Slice || listv.Len() == 0 {
+		return false, errors.New(fmt.Sprintf("Invalid query, `%v` expects a non-empty list of queries", operator))
+	}
+	for i := 0; i < listv.Len(); i++ {
+		subquery, ok := AsMap(listv.Index(i).Interface())
+		if !ok {
+			return false, errors.New(fmt.Sprintf("Invalid query, `%v` expects a list of queries", operator))
+		}
+		matched, err := Match(doc, subquery)
+		if err != nil {
+			return false, err
+		}
+		if operator == "$or" && matched {
+			return true, nil
+		}
+		if operator == "$and" && !matched {
+			return false, nil
+		}
+	}
+	return operator == "$and", nil
+}
+
+func matchField(doc bson.M, field string, value interface{}) (bool, error) {
+	fieldValue, exists := Get(doc, field)
+	fieldValue = Normalize(fieldValue)
+	operators, ok := AsMap(value)
+	if !ok || !IsOperatorQuery(operators) {
+		return equal(fieldValue, Normalize(value)), nil
+	}
+	for operator, argument := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = equal(fieldValue, Normalize(argument))
+		case "$ne":
+			matched = !equal(fieldValue, Normalize(argument))
+		case "$gt", "$gte", "$lt", "$lte":
+			result, comparable := Compare(fieldValue, Normalize(argument))
+			matched = comparable && exists && ((operator == "$gt" && result > 0) || (operator == "$gte" && result >= 0) ||
+				(operator == "$lt" && result < 0) || (operator == "$lte" && result <= 0))
+		case "$in", "$nin":
+			listv := reflect.ValueOf(argument)
+			if listv.Kind() != reflect.Slice {
+				return false, errors.New(fmt.Sprintf("Invalid query, `%v` expects a list", operator))
+			}
+			for i := 0; i < listv.Len() && !matched; i++ {
+				matched = equal(fieldValue, Normalize(listv.Index(i).Interface()))
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			expected, _ := argument.(bool)
+			matched = exists == expected
+		default:
+			return false, errors.New(fmt.Sprintf("Invalid query, unsupported operator `%v`", operator))
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// equal Returns true if the normalized field value equals the normalized value,
+// or if the field is an array that contains the value
+func equal(fieldValue interface{}, value interface{}) bool {
+	if reflect.DeepEqual(numeric(fieldValue), numeric(value)) {
+		return true
+	}
+	if list, ok := fieldValue.([]interface{}); ok {
+		for _, item := range list {
+			if reflect.DeepEqual(numeric(item), numeric(value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numeric Returns numbers of a normalized value as float64, so that `1` equals `1.0`
+func numeric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[key] = numeric(value)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = numeric(item)
+		}
+		return list
+	}
+	if number, ok := toFloat(v); ok {
+		return number
+	}
+	return v
+}
+
+// Compare Returns -1, 0 or 1 if the normalized value a is less than, equal to or greater than b.
+// Values of different types are not comparable, except numbers; returns false if they are not comparable.
+func Compare(a interface{}, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x < y, x > y), true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x < y, x > y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(!x && y, x && !y), true
+	case nil:
+		return 0, b == nil
+	}
+	return 0, false
+}
+
+func compareOrdered(less bool, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// Sort sorts documents by a sort string, for eg: `-createdAt,name`, see Less()
+func Sort(docs []bson.M, sortFields string) {
+	fields := domain.ParseSort(sortFields)
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return Less(docs[i], docs[j], fields)
+	})
+}
+
+// Less Returns true if document a sorts before b.
+// Missing fields sort first, and values of different types are ordered null < numbers < strings < documents < arrays < booleans.
+func Less(a bson.M, b bson.M, fields []domain.SortField) bool {
+	for _, field := range fields {
+		x, _ := Get(a, field.Field)
+		y, _ := Get(b, field.Field)
+		result := sortCompare(Normalize(x), Normalize(y))
+		if result == 0 {
+			continue
+		}
+		if field.Descending {
+			return result > 0
+		}
+		return result < 0
+	}
+	return false
+}
+
+func sortCompare(a interface{}, b interface{}) int {
+	if result, ok := Compare(a, b); ok {
+		return result
+	}
+	return compareOrdered(typeOrder(a) < typeOrder(b), typeOrder(a) > typeOrder(b))
+}
+
+func typeOrder(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if _, ok := toFloat(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case string:
+		return 2
+	case map[string]interface{}:
+		return 3
+	case []interface{}:
+		return 4
+	case bool:
+		return 5
+	}
+	return 6
+}
+
+// cursor type is the content of an opaque page cursor: the sort and the sort values of the last document of a page
+type cursor struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+// pageSort Returns the sort fields of a page, with `_id` appended as a tie-breaker so that the order is total
+func pageSort(sort string) []domain.SortField {
+	fields := domain.ParseSort(sort)
+	for _, field := range fields {
+		if field.Field == IDField {
+			return fields
+		}
+	}
+	descending := true
+	if len(fields) > 0 {
+		descending = fields[len(fields)-1].Descending
+	}
+	return append(fields, domain.SortField{Field: IDField, Descending: descending})
+}
+
+func sortString(fields []domain.SortField) string {
+	parts := []string{}
+	for _, field := range fields {
+		if field.Descending {
+			parts = append(parts, "-"+field.Field)
+		} else {
+			parts = append(parts, field.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// EncodeCursor Returns the opaque cursor of the page that starts after the document
+func EncodeCursor(doc bson.M, sort string) (string, error) {
+	fields := pageSort(sort)
+	c := cursor{sortString(fields), []interface{}{}}
+	for _, field := range fields {
+		value, _ := Get(doc, field.Field)
+		c.Values = append(c.Values, value)
+	}
+	b, err := bson.Marshal(c)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error encoding cursor: %v", err.Error()))
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CursorQuery Returns the query that matches the documents after the cursor, in the order of the sort (keyset pagination).
+// Returns domain.ErrInvalidCursor if the cursor was not encoded for the same sort, or has document or array values.
+func CursorQuery(encoded string, sort string) (domain.Query, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, domain.ErrInvalidCursor
+	}
+	var c cursor
+	if err := bson.Unmarshal(b, &c); err != nil {
+		return nil, domain.ErrInvalidCursor
+	}
+	fields := pageSort(sort)
+	if c.Sort != sortString(fields) || len(c.Values) != len(fields) {
+		return nil, domain.ErrInvalidCursor
+	}
+	// the cursor is supplied by the client, its values must not inject operator documents in the query
+	for _, value := range c.Values {
+		switch Normalize(value).(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, domain.ErrInvalidCursor
+		}
+	}
+
+	// (f1 > v1) or (f1 = v1 and f2 > v2) or ... with `<` for descending fields
+	or := []domain.Query{}
+	for i, field := range fields {
+		query := domain.Query{}
+		for j := 0; j < i; j++ {
+			query[fields[j].Field] = c.Values[j]
+		}
+		operator := "$gt"
+		if field.Descending {
+			operator = "$lt"
+		}
+		query[field.Field] = domain.Query{operator: c.Values[i]}
+		or = append(or, query)
+	}
+	return domain.Query{"$or": or}, nil
+}
+
+// FindPage Returns a page of the documents that match the query, decoded into result, a pointer to a slice.
+// It implements IDatabase.FindPage() with IDatabase.FindAll(), for databases that support the sort and comparison
+// operators on every field; the sort fields must not be missing or null in the documents.
+func FindPage(ctx context.Context, db domain.IDatabase, name string, query domain.Query, result interface{}, sort string, page domain.PageRequest) (*domain.Page, error) {
+	if page.Limit <= 0 {
+		page.Limit = domain.DefaultPageLimit
+	}
+	if sort == "" {
+		sort = "-" + IDField
+	}
+	sort = sortString(pageSort(sort))
+	if page.Cursor != "" {
+		after, err := CursorQuery(page.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		if len(query) > 0 {
+			after = domain.Query{"$and": []domain.Query{query, after}}
+		}
+		query = after
+	}
+
+	// load the sort fields of projected documents for the cursor
+	projection := page.Fields
+	if len(projection) > 0 {
+		projection = append(domain.Projection{}, page.Fields...)
+		for _, field := range pageSort(sort) {
+			projection = append(projection, field.Field)
+		}
+	}
+	var docs []bson.M
+	if err := db.FindAllProjection(ctx, name, query, projection, &docs, page.Limit+1, sort); err != nil {
+		return nil, err
+	}
+	resultPage := &domain.Page{Limit: page.Limit}
+	if len(docs) > page.Limit {
+		docs = docs[:page.Limit]
+		next, err := EncodeCursor(docs[len(docs)-1], sort)
+		if err != nil {
+			return nil, err
+		}
+		resultPage.HasMore = true
+		resultPage.NextCursor = next
+	}
+
+	raws := make([][]byte, len(docs))
+	for i, doc := range docs {
+		raw, err := bson.Marshal(Project(doc, page.Fields))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error decoding document: %v", err.Error()))
+		}
+		raws[i] = raw
+	}
+	return resultPage, UnmarshalAll(raws, result)
+}
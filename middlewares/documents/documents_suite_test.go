@@ -0,0 +1,12 @@
+package documents_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Documents Suite")
+}
@@ -0,0 +1,230 @@
+package documents_test
+
+import (
+	"encoding/base64"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+var _ = Describe("Documents", func() {
+
+	doc := bson.M{
+		"_id":     bson.ObjectIdHex("5577d5d5b5a8a60c9f000001"),
+		"name":    "alice",
+		"age":     30,
+		"score":   7.5,
+		"active":  true,
+		"tags":    []interface{}{"admin", "staff"},
+		"profile": bson.M{"plan": "pro", "seats": int64(3)},
+		"created": time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC),
+	}
+	match := func(query domain.Query) bool {
+		matched, err := documents.Match(doc, query)
+		Expect(err).To(BeNil())
+		return matched
+	}
+
+	Describe("Match()", func() {
+		It("should match equal values, nested fields and array elements", func() {
+			Expect(match(domain.Query{"name": "alice"})).To(BeTrue())
+			Expect(match(domain.Query{"name": "bob"})).To(BeFalse())
+			Expect(match(domain.Query{"age": 30.0})).To(BeTrue())
+			Expect(match(domain.Query{"profile.plan": "pro"})).To(BeTrue())
+			Expect(match(domain.Query{"profile.seats": 3})).To(BeTrue())
+			Expect(match(domain.Query{"tags": "staff"})).To(BeTrue())
+			Expect(match(domain.Query{"_id": bson.ObjectIdHex("5577d5d5b5a8a60c9f000001")})).To(BeTrue())
+			Expect(match(domain.Query{"created": time.Date(2015, time.June, 10, 20, 0, 0, 0, time.FixedZone("SGT", 8*3600))})).To(BeTrue())
+			Expect(match(domain.Query{"missing": nil})).To(BeTrue())
+		})
+		It("should match comparison operators", func() {
+			Expect(match(domain.Query{"age": domain.Query{"$gt": 29, "$lte": 30}})).To(BeTrue())
+			Expect(match(domain.Query{"age": domain.Query{"$lt": 30}})).To(BeFalse())
+			Expect(match(domain.Query{"score": domain.Query{"$gte": 7}})).To(BeTrue())
+			Expect(match(domain.Query{"name": domain.Query{"$gt": "a", "$lt": "b"}})).To(BeTrue())
+			Expect(match(domain.Query{"name": domain.Query{"$gt": 1}})).To(BeFalse())
+			Expect(match(domain.Query{"missing": domain.Query{"$lt": 1}})).To(BeFalse())
+			Expect(match(domain.Query{"created": domain.Query{"$lt": time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)}})).To(BeTrue())
+		})
+		It("should match `$eq`, `$ne`, `$in`, `$nin` and `$exists`", func() {
+			Expect(match(domain.Query{"name": domain.Query{"$eq": "alice"}})).To(BeTrue())
+			Expect(match(domain.Query{"name": domain.Query{"$ne": "alice"}})).To(BeFalse())
+			Expect(match(domain.Query{"name": domain.Query{"$in": []string{"bob", "alice"}}})).To(BeTrue())
+			Expect(match(domain.Query{"tags": domain.Query{"$in": []string{"staff"}}})).To(BeTrue())
+			Expect(match(domain.Query{"name": domain.Query{"$nin": []string{"bob", "alice"}}})).To(BeFalse())
+			Expect(match(domain.Query{"profile.plan": domain.Query{"$exists": true}})).To(BeTrue())
+			Expect(match(domain.Query{"missing": domain.Query{"$exists": false}})).To(BeTrue())
+		})
+		It("should combine queries with `$and` and `$or`", func() {
+			Expect(match(domain.Query{"$or": []domain.Query{{"name": "bob"}, {"age": 30}}})).To(BeTrue())
+			Expect(match(domain.Query{"$and": []domain.Query{{"name": "alice"}, {"age": 31}}})).To(BeFalse())
+			Expect(match(domain.Query{"$or": []interface{}{bson.M{"name": "bob"}, map[string]interface{}{"active": true}}})).To(BeTrue())
+		})
+		It("should return error for invalid queries", func() {
+			for _, query := range []domain.Query{
+				{"$where": "true"},
+				{"age": domain.Query{"$regex": "3"}},
+				{"$or": []domain.Query{}},
+				{"$and": "name"},
+				{"name": domain.Query{"$in": "alice"}},
+			} {
+				_, err := documents.Match(doc, query)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+	})
+
+	Describe("Compare()", func() {
+		It("should compare numbers of any type, strings and booleans", func() {
+			for _, c := range []struct {
+				a, b   interface{}
+				result int
+			}{
+				{1, 2.5, -1},
+				{int64(3), 3.0, 0},
+				{"b", "a", 1},
+				{false, true, -1},
+				{nil, nil, 0},
+			} {
+				result, ok := documents.Compare(c.a, c.b)
+				Expect(ok).To(BeTrue())
+				Expect(result).To(Equal(c.result))
+			}
+		})
+		It("should not compare values of different types", func() {
+			_, ok := documents.Compare("1", 1)
+			Expect(ok).To(BeFalse())
+			_, ok = documents.Compare(nil, 1)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Sort() and Less()", func() {
+		It("should sort by several fields and directions", func() {
+			docs := []bson.M{
+				{"name": "carol", "age": 35},
+				{"name": "bob", "age": 25},
+				{"name": "dave", "age": 25},
+				{"name": "alice", "age": 30},
+			}
+			documents.Sort(docs, "-age,name")
+			Expect(docs).To(Equal([]bson.M{
+				{"name": "carol", "age": 35},
+				{"name": "alice", "age": 30},
+				{"name": "bob", "age": 25},
+				{"name": "dave", "age": 25},
+			}))
+		})
+		It("should sort missing fields first and order values by type", func() {
+			docs := []bson.M{
+				{"v": true},
+				{"v": []interface{}{1}},
+				{"v": bson.M{"a": 1}},
+				{"v": "a"},
+				{"v": 1.5},
+				{"v": nil},
+				{},
+			}
+			documents.Sort(docs, "v")
+			Expect(docs[2:]).To(Equal([]bson.M{{"v": 1.5}, {"v": "a"}, {"v": bson.M{"a": 1}}, {"v": []interface{}{1}}, {"v": true}}))
+			Expect(documents.Less(bson.M{}, bson.M{"v": 0}, domain.ParseSort("v"))).To(BeTrue())
+			Expect(documents.Less(bson.M{"v": 0}, bson.M{}, domain.ParseSort("-v"))).To(BeTrue())
+		})
+	})
+
+	Describe("ApplyUpdate()", func() {
+		It("should apply `$set`, `$unset` and `$inc` to a copy of the document", func() {
+			updated, err := documents.ApplyUpdate(doc, map[string]interface{}{
+				"$set":   bson.M{"name": "alicia", "profile.plan": "team", "settings.theme": "dark"},
+				"$unset": bson.M{"tags": ""},
+				"$inc":   bson.M{"age": 1, "score": 0.5, "profile.seats": int64(2), "logins": 1},
+			})
+			Expect(err).To(BeNil())
+			Expect(updated["name"]).To(Equal("alicia"))
+			Expect(updated["profile"]).To(Equal(bson.M{"plan": "team", "seats": int64(5)}))
+			Expect(updated["settings"]).To(Equal(bson.M{"theme": "dark"}))
+			Expect(updated).ToNot(HaveKey("tags"))
+			Expect(updated["age"]).To(Equal(31))
+			Expect(updated["score"]).To(Equal(8.0))
+			Expect(updated["logins"]).To(Equal(1))
+			Expect(doc["name"]).To(Equal("alice"))
+		})
+		It("should replace the document but keep its `_id` without operators", func() {
+			updated, err := documents.ApplyUpdate(doc, map[string]interface{}{"name": "bob"})
+			Expect(err).To(BeNil())
+			Expect(updated).To(Equal(bson.M{"_id": doc["_id"], "name": "bob"}))
+		})
+		It("should return error for invalid updates", func() {
+			for _, update := range []map[string]interface{}{
+				{"$set": bson.M{"_id": "other"}},
+				{"$push": bson.M{"tags": "new"}},
+				{"$set": "name"},
+				{"$inc": bson.M{"name": 1}},
+				{"$inc": bson.M{"age": "1"}},
+				{"$set": bson.M{"name.first": "alice"}},
+			} {
+				_, err := documents.ApplyUpdate(doc, update)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+	})
+
+	Describe("FromQuery()", func() {
+		It("should return the fields matched by equality", func() {
+			upserted, err := documents.FromQuery(domain.Query{
+				"name":         "erin",
+				"profile.plan": domain.Query{"$eq": "pro"},
+				"age":          domain.Query{"$gt": 18},
+				"$or":          []domain.Query{{"active": true}},
+			})
+			Expect(err).To(BeNil())
+			Expect(upserted).To(Equal(bson.M{"name": "erin", "profile": bson.M{"plan": "pro"}}))
+		})
+	})
+
+	Describe("EncodeCursor() and CursorQuery()", func() {
+		forge := func(sort string, values ...interface{}) string {
+			b, err := bson.Marshal(bson.M{"s": sort, "v": values})
+			Expect(err).To(BeNil())
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+		It("should return the keyset query of the documents after the cursor", func() {
+			cursor, err := documents.EncodeCursor(doc, "-age")
+			Expect(err).To(BeNil())
+			query, err := documents.CursorQuery(cursor, "-age")
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(domain.Query{"$or": []domain.Query{
+				{"age": domain.Query{"$lt": 30}},
+				{"age": 30, "_id": domain.Query{"$lt": doc["_id"]}},
+			}}))
+		})
+		It("should return ErrInvalidCursor for cursors of another sort or malformed cursors", func() {
+			cursor, err := documents.EncodeCursor(doc, "-age")
+			Expect(err).To(BeNil())
+			_, err = documents.CursorQuery(cursor, "age")
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+			_, err = documents.CursorQuery("not a cursor", "-age")
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+			_, err = documents.CursorQuery(forge("-age,-_id", 30), "-age")
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+		})
+		It("should return ErrInvalidCursor for forged cursors with document or array values", func() {
+			_, err := documents.CursorQuery(forge("-age,-_id", bson.M{"$ne": nil}, "id"), "-age")
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+			_, err = documents.CursorQuery(forge("-age,-_id", 30, []interface{}{"id"}), "-age")
+			Expect(err).To(Equal(domain.ErrInvalidCursor))
+		})
+	})
+
+	Describe("Normalize()", func() {
+		It("should convert BSON values to JSON values", func() {
+			Expect(documents.Normalize(doc["_id"])).To(Equal("5577d5d5b5a8a60c9f000001"))
+			Expect(documents.Normalize(doc["created"])).To(Equal("2015-06-10T12:00:00.000000000Z"))
+			Expect(documents.Normalize(bson.M{"seats": int64(3)})).To(Equal(map[string]interface{}{"seats": int64(3)}))
+			Expect(documents.Normalize([]string{"a"})).To(Equal([]interface{}{"a"}))
+		})
+	})
+})
@@ -0,0 +1,310 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+const DefaultCollection = "migrations"
+
+// lockID is the `_id` of the lock document in the lock collection
+const lockID = "lock"
+
+var ErrLocked = errors.New("Migrations are locked by another instance")
+var ErrLockLost = errors.New("Migrations lock has expired and been taken by another instance")
+
+// Options type
+// Collection records the applied migrations, and `<Collection>_lock` holds the lock of the instance
+// that is migrating; defaults to DefaultCollection.
+// LockTimeout is how long the lock is held without being refreshed, so that the lock of an instance that
+// died while migrating expires; defaults to 5 minutes. The lock is refreshed every third of LockTimeout while
+// migrating, and the run stops with ErrLockLost if the lock has been taken by another instance.
+// Now and Tick default to the clock and to a time.Ticker, Tick Returns the ticks and the function that stops them.
+type Options struct {
+	Collection  string
+	LockTimeout time.Duration
+	Now         func() time.Time
+	Tick        func(d time.Duration) (<-chan time.Time, func())
+}
+
+// Record type is the record of an applied migration
+type Record struct {
+	Version     int64     `json:"version" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"appliedAt" bson:"appliedAt"`
+}
+
+// Status type is a registered migration and its record, nil if the migration is pending
+type Status struct {
+	domain.Migration
+	Record *Record
+}
+
+// lock type is the lock document
+type lock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Migrator type
+// It applies and reverts the migrations registered by resources, one instance at a time.
+type Migrator struct {
+	db         domain.IDatabase
+	options    *Options
+	migrations domain.Migrations
+	owner      string
+}
+
+// New Returns a new Migrator object
+func New(db domain.IDatabase, options *Options) *Migrator {
+	if options == nil {
+		options = &Options{}
+	}
+	if options.Collection == "" {
+		options.Collection = DefaultCollection
+	}
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = 5 * time.Minute
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	if options.Tick == nil {
+		options.Tick = func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		}
+	}
+	return &Migrator{db, options, domain.Migrations{}, bson.NewObjectId().Hex()}
+}
+
+// Register adds the migrations of the sources.
+// Returns an error if a migration is invalid or has the version of another migration.
+func (m *Migrator) Register(sources ...domain.IMigrationSource) error {
+	migrations := m.migrations
+	for _, source := range sources {
+		migrations = append(migrations, source.Migrations()...)
+	}
+	sorted, err := migrations.Sorted()
+	if err != nil {
+		return err
+	}
+	m.migrations = sorted
+	return nil
+}
+
+// RegisterResources adds the migrations of the resources that implement IMigrationSource
+func (m *Migrator) RegisterResources(resources ...domain.IResource) error {
+	sources := []domain.IMigrationSource{}
+	for _, resource := range resources {
+		if source, ok := resource.(domain.IMigrationSource); ok {
+			sources = append(sources, source)
+		}
+	}
+	return m.Register(sources...)
+}
+
+// Status Returns the registered migrations, sorted by version, and their records
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	var records []Record
+	if err := m.db.FindAll(ctx, m.options.Collection, nil, &records, 0, "_id"); err != nil {
+		return nil, err
+	}
+	applied := map[int64]*Record{}
+	for i := range records {
+		applied[records[i].Version] = &records[i]
+	}
+	statuses := []*Status{}
+	for _, migration := range m.migrations {
+		statuses = append(statuses, &Status{migration, applied[migration.Version]})
+	}
+	return statuses, nil
+}
+
+// Up applies the pending migrations up to the target version, or every pending migration if target is 0.
+// Returns the applied migrations, even if a migration fails.
+func (m *Migrator) Up(ctx context.Context, target int64) ([]domain.Migration, error) {
+	applied := []domain.Migration{}
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Record != nil {
+				continue
+			}
+			if target > 0 && status.Version > target {
+				break
+			}
+			if err := m.refreshLock(ctx); err != nil {
+				return err
+			}
+			record := &Record{status.Version, status.Description, m.options.Now().UTC()}
+			if err := m.run(ctx, status.Migration, status.Up, func(db domain.IDatabase) error {
+				return db.Insert(ctx, m.options.Collection, record)
+			}); err != nil {
+				return errors.New(fmt.Sprintf("Error applying migration %v `%v`: %v", status.Version, status.Description, err.Error()))
+			}
+			applied = append(applied, status.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the applied migrations with a version greater than the target version, from the last one.
+// Returns the reverted migrations, even if a migration fails.
+func (m *Migrator) Down(ctx context.Context, target int64) ([]domain.Migration, error) {
+	reverted := []domain.Migration{}
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0; i-- {
+			status := statuses[i]
+			if status.Version <= target {
+				break
+			}
+			if status.Record == nil {
+				continue
+			}
+			if status.Down == nil {
+				return errors.New(fmt.Sprintf("Migration %v `%v` can not be reverted", status.Version, status.Description))
+			}
+			if err := m.refreshLock(ctx); err != nil {
+				return err
+			}
+			if err := m.run(ctx, status.Migration, status.Down, func(db domain.IDatabase) error {
+				return db.RemoveOne(ctx, m.options.Collection, domain.Query{"_id": status.Version})
+			}); err != nil {
+				return errors.New(fmt.Sprintf("Error reverting migration %v `%v`: %v", status.Version, status.Description, err.Error()))
+			}
+			reverted = append(reverted, status.Migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// PreviousVersion Returns the version of the applied migration before the last one, or 0 if there is none,
+// the target version of Down() to revert the last applied migration only
+func (m *Migrator) PreviousVersion(ctx context.Context) (int64, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	applied := []int64{0}
+	for _, status := range statuses {
+		if status.Record != nil {
+			applied = append(applied, status.Version)
+		}
+	}
+	if len(applied) < 2 {
+		return 0, nil
+	}
+	return applied[len(applied)-2], nil
+}
+
+// run runs the function of a migration and updates its record, in a transaction unless NoTransaction is set.
+// The transaction is rolled back if ctx is canceled because the lock has been lost.
+func (m *Migrator) run(ctx context.Context, migration domain.Migration, fn func(ctx context.Context, db domain.IDatabase) error, record func(db domain.IDatabase) error) error {
+	if migration.NoTransaction {
+		if err := fn(ctx, m.db); err != nil {
+			return err
+		}
+		return record(m.db)
+	}
+	return m.db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+		if err := record(tx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+func (m *Migrator) lockCollection() string {
+	return m.options.Collection + "_lock"
+}
+
+// withLock runs fn while holding the lock, returns ErrLocked if another instance holds it.
+// fn is given a context that is canceled if the lock is lost, and ErrLockLost is returned.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	now := m.options.Now()
+	err := m.db.Insert(ctx, m.lockCollection(), &lock{lockID, m.owner, now.Add(m.options.LockTimeout)})
+	if err == domain.ErrDuplicateKey {
+		// take over the lock of an instance that died while migrating
+		err = m.db.Update(ctx, m.lockCollection(), domain.Query{
+			"_id":       lockID,
+			"expiresAt": domain.Query{"$lt": now},
+		}, domain.Change{Update: domain.Query{
+			"$set": domain.Query{"owner": m.owner, "expiresAt": now.Add(m.options.LockTimeout)},
+		}}, nil)
+		if err == domain.ErrNotFound {
+			return ErrLocked
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer m.db.RemoveAll(context.Background(), m.lockCollection(), domain.Query{"_id": lockID, "owner": m.owner})
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := m.keepLock(lockCtx, cancel)
+	err = fn(lockCtx)
+	if lockErr := stop(); lockErr != nil {
+		return lockErr
+	}
+	return err
+}
+
+// keepLock refreshes the lock in the background every third of LockTimeout, so that it does not expire during
+// a long migration, and cancels the context if the lock is lost.
+// stop ends the refreshes and Returns ErrLockLost if the lock has been lost.
+func (m *Migrator) keepLock(ctx context.Context, cancel func()) (stop func() error) {
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	ticks, stopTicks := m.options.Tick(m.options.LockTimeout / 3)
+	go func() {
+		defer stopTicks()
+		for {
+			select {
+			case <-done:
+				result <- nil
+				return
+			case <-ticks:
+				// other errors are retried at the next tick, the lock expires if they persist
+				if err := m.refreshLock(ctx); err == ErrLockLost {
+					cancel()
+					result <- err
+					return
+				}
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		return <-result
+	}
+}
+
+// refreshLock extends the lock held by the instance, returns ErrLockLost if it has been taken by another instance
+func (m *Migrator) refreshLock(ctx context.Context) error {
+	err := m.db.Update(ctx, m.lockCollection(), domain.Query{"_id": lockID, "owner": m.owner}, domain.Change{
+		Update: domain.Query{"$set": domain.Query{"expiresAt": m.options.Now().Add(m.options.LockTimeout)}},
+	}, nil)
+	if err == domain.ErrNotFound {
+		return ErrLockLost
+	}
+	return err
+}
@@ -0,0 +1,270 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	"github.com/sogko/slumber/middlewares/migrations"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type migrationSource []domain.Migration
+
+func (source migrationSource) Migrations() []domain.Migration {
+	return source
+}
+
+// itemMigration Returns a migration that inserts an item named after its version, and removes it when reverted
+func itemMigration(version int64) domain.Migration {
+	return domain.Migration{
+		Version:     version,
+		Description: "add item",
+		Up: func(ctx context.Context, db domain.IDatabase) error {
+			return db.Insert(ctx, "items", bson.M{"version": version})
+		},
+		Down: func(ctx context.Context, db domain.IDatabase) error {
+			return db.RemoveOne(ctx, "items", domain.Query{"version": version})
+		},
+	}
+}
+
+// versions Returns the versions of the migrations
+func versions(migrations []domain.Migration) []int64 {
+	result := []int64{}
+	for _, migration := range migrations {
+		result = append(result, migration.Version)
+	}
+	return result
+}
+
+var _ = Describe("Migrations", func() {
+
+	var dir string
+	var db *boltdb.BoltDB
+	var now time.Time
+	var ticks chan time.Time
+	var migrator *migrations.Migrator
+	ctx := context.Background()
+
+	// the lock is refreshed when the spec sends to ticks, at the fake time now
+	options := func(lockTimeout time.Duration) *migrations.Options {
+		return &migrations.Options{
+			LockTimeout: lockTimeout,
+			Now: func() time.Time {
+				return now
+			},
+			Tick: func(d time.Duration) (<-chan time.Time, func()) {
+				Expect(d).To(Equal(lockTimeout / 3))
+				return ticks, func() {}
+			},
+		}
+	}
+	items := func() []int64 {
+		var docs []bson.M
+		Expect(db.FindAll(ctx, "items", nil, &docs, 0, "version")).To(BeNil())
+		result := []int64{}
+		for _, doc := range docs {
+			result = append(result, doc["version"].(int64))
+		}
+		return result
+	}
+	insertLock := func(owner string, expiresAt time.Time) {
+		Expect(db.Insert(ctx, "migrations_lock", bson.M{"_id": "lock", "owner": owner, "expiresAt": expiresAt})).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "migrations")
+		Expect(err).To(BeNil())
+		db = boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+		Expect(db.Open()).To(BeNil())
+		now = time.Date(2015, time.June, 10, 12, 0, 0, 0, time.UTC)
+		ticks = make(chan time.Time)
+		migrator = migrations.New(db, options(time.Minute))
+		Expect(migrator.Register(migrationSource{itemMigration(3), itemMigration(1), itemMigration(2)})).To(BeNil())
+	})
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("Register()", func() {
+		It("should return error for duplicate versions", func() {
+			Expect(migrator.Register(migrationSource{itemMigration(2)})).ToNot(BeNil())
+		})
+	})
+
+	Describe("Up()", func() {
+		It("should apply the pending migrations up to the target version, in order", func() {
+			applied, err := migrator.Up(ctx, 2)
+			Expect(err).To(BeNil())
+			Expect(versions(applied)).To(Equal([]int64{1, 2}))
+			Expect(items()).To(Equal([]int64{1, 2}))
+
+			statuses, err := migrator.Status(ctx)
+			Expect(err).To(BeNil())
+			Expect(statuses).To(HaveLen(3))
+			Expect(statuses[0].Record.AppliedAt.Equal(now)).To(BeTrue())
+			Expect(statuses[2].Record).To(BeNil())
+
+			applied, err = migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(versions(applied)).To(Equal([]int64{3}))
+			applied, err = migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(applied).To(BeEmpty())
+		})
+		It("should roll back a failing migration and stop", func() {
+			Expect(migrator.Register(migrationSource{{
+				Version:     4,
+				Description: "fail",
+				Up: func(ctx context.Context, db domain.IDatabase) error {
+					if err := db.Insert(ctx, "items", bson.M{"version": int64(4)}); err != nil {
+						return err
+					}
+					return errors.New("failed")
+				},
+			}, itemMigration(5)})).To(BeNil())
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).ToNot(BeNil())
+			Expect(versions(applied)).To(Equal([]int64{1, 2, 3}))
+			Expect(items()).To(Equal([]int64{1, 2, 3}))
+		})
+		It("should return ErrLocked while another instance holds the lock", func() {
+			insertLock("other", now.Add(time.Second))
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).To(Equal(migrations.ErrLocked))
+			Expect(applied).To(BeEmpty())
+			Expect(items()).To(BeEmpty())
+		})
+		It("should take over the expired lock of another instance and release it", func() {
+			insertLock("other", now.Add(-time.Second))
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(versions(applied)).To(Equal([]int64{1, 2, 3}))
+			count, err := db.Count(ctx, "migrations_lock", nil)
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+		It("should return ErrLockLost once another instance has taken the lock", func() {
+			Expect(migrator.Register(migrationSource{{
+				Version:       4,
+				Description:   "lose the lock",
+				NoTransaction: true,
+				Up: func(ctx context.Context, db domain.IDatabase) error {
+					_, err := db.UpdateAll(ctx, "migrations_lock", nil, domain.Query{"$set": domain.Query{"owner": "other"}})
+					return err
+				},
+			}, itemMigration(5)})).To(BeNil())
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).To(Equal(migrations.ErrLockLost))
+			Expect(versions(applied)).To(Equal([]int64{1, 2, 3, 4}))
+			Expect(items()).To(Equal([]int64{1, 2, 3}))
+		})
+		It("should refresh the lock while a migration runs longer than LockTimeout", func() {
+			migrator = migrations.New(db, options(time.Minute))
+			var otherErr error
+			Expect(migrator.Register(migrationSource{{
+				Version:       1,
+				Description:   "long",
+				NoTransaction: true,
+				Up: func(ctx context.Context, db domain.IDatabase) error {
+					now = now.Add(50 * time.Second)
+					// the second tick is received once the lock has been refreshed after the first one
+					ticks <- now
+					ticks <- now
+					later := now.Add(50 * time.Second)
+					other := migrations.New(db, &migrations.Options{LockTimeout: time.Minute, Now: func() time.Time {
+						return later
+					}})
+					_, otherErr = other.Up(ctx, 0)
+					return nil
+				},
+			}})).To(BeNil())
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(versions(applied)).To(Equal([]int64{1}))
+			Expect(otherErr).To(Equal(migrations.ErrLocked))
+		})
+		It("should cancel a migration and return ErrLockLost if the lock is lost while it runs", func() {
+			migrator = migrations.New(db, options(time.Minute))
+			var migrationErr error
+			Expect(migrator.Register(migrationSource{{
+				Version:       1,
+				Description:   "long",
+				NoTransaction: true,
+				Up: func(ctx context.Context, db domain.IDatabase) error {
+					if _, err := db.UpdateAll(ctx, "migrations_lock", nil, domain.Query{"$set": domain.Query{"owner": "other"}}); err != nil {
+						return err
+					}
+					ticks <- now
+					select {
+					case <-ctx.Done():
+						migrationErr = ctx.Err()
+					case <-time.After(time.Second):
+					}
+					return migrationErr
+				},
+			}})).To(BeNil())
+			applied, err := migrator.Up(ctx, 0)
+			Expect(err).To(Equal(migrations.ErrLockLost))
+			Expect(applied).To(BeEmpty())
+			Expect(migrationErr).To(Equal(context.Canceled))
+			statuses, err := migrator.Status(ctx)
+			Expect(err).To(BeNil())
+			Expect(statuses[0].Record).To(BeNil())
+		})
+	})
+
+	Describe("Down() and PreviousVersion()", func() {
+		BeforeEach(func() {
+			_, err := migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+		})
+		It("should revert the applied migrations after the target version, from the last one", func() {
+			previous, err := migrator.PreviousVersion(ctx)
+			Expect(err).To(BeNil())
+			Expect(previous).To(Equal(int64(2)))
+			reverted, err := migrator.Down(ctx, previous)
+			Expect(err).To(BeNil())
+			Expect(versions(reverted)).To(Equal([]int64{3}))
+			Expect(items()).To(Equal([]int64{1, 2}))
+
+			reverted, err = migrator.Down(ctx, 0)
+			Expect(err).To(BeNil())
+			Expect(versions(reverted)).To(Equal([]int64{2, 1}))
+			Expect(items()).To(BeEmpty())
+			previous, err = migrator.PreviousVersion(ctx)
+			Expect(err).To(BeNil())
+			Expect(previous).To(Equal(int64(0)))
+		})
+		It("should return error for a migration that can not be reverted", func() {
+			Expect(migrator.Register(migrationSource{{
+				Version:     4,
+				Description: "irreversible",
+				Up: func(ctx context.Context, db domain.IDatabase) error {
+					return nil
+				},
+			}})).To(BeNil())
+			_, err := migrator.Up(ctx, 0)
+			Expect(err).To(BeNil())
+			reverted, err := migrator.Down(ctx, 0)
+			Expect(err).ToNot(BeNil())
+			Expect(reverted).To(BeEmpty())
+			Expect(items()).To(Equal([]int64{1, 2, 3}))
+		})
+		It("should return ErrLocked while another instance holds the lock", func() {
+			insertLock("other", now.Add(time.Second))
+			_, err := migrator.Down(ctx, 0)
+			Expect(err).To(Equal(migrations.ErrLocked))
+			Expect(items()).To(Equal([]int64{1, 2, 3}))
+		})
+	})
+})
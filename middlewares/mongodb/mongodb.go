@@ -1,9 +1,14 @@
 package mongodb
 
 import (
+	"context"
 	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -44,62 +49,272 @@ func (db *MongoDB) NewSession() *MongoDBSession {
 	return &MongoDBSession{session, mongoOptions}
 }
 
-func (db *MongoDB) FindOne(name string, query domain.Query, result interface{}) error {
-	return db.currentDb.C(name).Find(query).One(result)
+func (db *MongoDB) FindOne(ctx context.Context, name string, query domain.Query, result interface{}) error {
+	return db.FindOneProjection(ctx, name, query, nil, result)
 }
 
-func (db *MongoDB) FindAll(name string, query domain.Query, result interface{}, limit int, sort string) error {
+// FindOneProjection loads only the projected fields and `_id` of the first document that matches the query
+func (db *MongoDB) FindOneProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return find(database, name, query, projection).One(result)
+	})
+}
+
+func (db *MongoDB) FindAll(ctx context.Context, name string, query domain.Query, result interface{}, limit int, sort string) error {
+	return db.FindAllProjection(ctx, name, query, nil, result, limit, sort)
+}
+
+// FindAllProjection loads only the projected fields and `_id` of the documents that match the query
+func (db *MongoDB) FindAllProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}, limit int, sort string) error {
 	if sort == "" {
 		sort = "-_id"
 	}
-	return db.currentDb.C(name).Find(query).Sort(sort).Limit(limit).All(result)
+	sortFields := strings.Split(sort, ",")
+	return db.run(ctx, func(database *mgo.Database) error {
+		return find(database, name, query, projection).Sort(sortFields...).Limit(limit).All(result)
+	})
 }
 
-func (db *MongoDB) Count(name string, query domain.Query) (int, error) {
-	return db.currentDb.C(name).Find(query).Count()
+func find(database *mgo.Database, name string, query domain.Query, projection domain.Projection) *mgo.Query {
+	q := database.C(name).Find(query)
+	if len(projection) > 0 {
+		selector := bson.M{}
+		for _, field := range projection {
+			selector[field] = 1
+		}
+		q = q.Select(selector)
+	}
+	return q
 }
 
-func (db *MongoDB) Insert(name string, obj interface{}) error {
-	return db.currentDb.C(name).Insert(obj)
+// FindPage Returns a page of the documents that match the query, using keyset pagination on the sort fields
+func (db *MongoDB) FindPage(ctx context.Context, name string, query domain.Query, result interface{}, sort string, page domain.PageRequest) (*domain.Page, error) {
+	return documents.FindPage(ctx, db, name, query, result, sort, page)
 }
 
-func (db *MongoDB) Update(name string, query domain.Query, change domain.Change, result interface{}) error {
-	_, err := db.currentDb.C(name).Find(query).Apply(mgo.Change(change), result)
-	return err
+func (db *MongoDB) Count(ctx context.Context, name string, query domain.Query) (int, error) {
+	var count int
+	err := db.run(ctx, func(database *mgo.Database) (err error) {
+		count, err = database.C(name).Find(query).Count()
+		return err
+	})
+	return count, err
 }
 
-func (db *MongoDB) UpdateAll(name string, query domain.Query, change domain.Query) (int, error) {
-	changeInfo, err := db.currentDb.C(name).UpdateAll(query, change)
-	if changeInfo == nil {
-		return 0, err
+// Aggregate runs the stages of the pipeline with the aggregation pipeline of MongoDB
+func (db *MongoDB) Aggregate(ctx context.Context, name string, pipeline []domain.Stage, result interface{}) error {
+	stages, err := mgoPipeline(pipeline)
+	if err != nil {
+		return err
 	}
-	return changeInfo.Updated, err
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.C(name).Pipe(stages).All(result)
+	})
 }
 
-func (db *MongoDB) RemoveOne(name string, query domain.Query) error {
-	return db.currentDb.C(name).Remove(query)
+func (db *MongoDB) Insert(ctx context.Context, name string, obj interface{}) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.C(name).Insert(obj)
+	})
 }
 
-func (db *MongoDB) RemoveAll(name string, query domain.Query) error {
-	_, err := db.currentDb.C(name).RemoveAll(query)
-	return err
+func (db *MongoDB) Update(ctx context.Context, name string, query domain.Query, change domain.Change, result interface{}) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		_, err := database.C(name).Find(query).Apply(mgoChange(change), result)
+		return err
+	})
+}
+
+func (db *MongoDB) UpdateAll(ctx context.Context, name string, query domain.Query, change domain.Query) (int, error) {
+	var updated int
+	err := db.run(ctx, func(database *mgo.Database) error {
+		changeInfo, err := database.C(name).UpdateAll(query, change)
+		if changeInfo != nil {
+			updated = changeInfo.Updated
+		}
+		return err
+	})
+	return updated, err
+}
+
+func (db *MongoDB) RemoveOne(ctx context.Context, name string, query domain.Query) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.C(name).Remove(query)
+	})
 }
 
-func (db *MongoDB) DropCollection(name string) error {
-	return db.currentDb.C(name).DropCollection()
+func (db *MongoDB) RemoveAll(ctx context.Context, name string, query domain.Query) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		_, err := database.C(name).RemoveAll(query)
+		return err
+	})
 }
 
-func (db *MongoDB) Exists(name string, query domain.Query) bool {
-	var result interface{}
-	err := db.currentDb.C(name).Find(query).One(result)
+func (db *MongoDB) DropCollection(ctx context.Context, name string) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.C(name).DropCollection()
+	})
+}
+
+func (db *MongoDB) Exists(ctx context.Context, name string, query domain.Query) bool {
+	err := db.run(ctx, func(database *mgo.Database) error {
+		var result interface{}
+		return database.C(name).Find(query).One(&result)
+	})
 	return (err == nil)
 }
-func (db *MongoDB) DropDatabase() error {
-	return db.currentDb.DropDatabase()
+
+func (db *MongoDB) DropDatabase(ctx context.Context) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.DropDatabase()
+	})
+}
+
+func (db *MongoDB) EnsureIndex(ctx context.Context, name string, index domain.Index) error {
+	return db.run(ctx, func(database *mgo.Database) error {
+		return database.C(name).EnsureIndex(mgoIndex(index))
+	})
+}
+
+// mgoChange Returns the mgo.Change of a domain.Change
+func mgoChange(change domain.Change) mgo.Change {
+	var update interface{}
+	if change.Update != nil {
+		update = map[string]interface{}(change.Update)
+	}
+	return mgo.Change{
+		Update:    update,
+		Upsert:    change.Upsert,
+		Remove:    change.Remove,
+		ReturnNew: change.ReturnNew,
+	}
+}
+
+// mgoPipeline Returns the stages of the MongoDB aggregation pipeline of a domain.Stage pipeline
+func mgoPipeline(pipeline []domain.Stage) ([]bson.M, error) {
+	stages := []bson.M{}
+	for _, stage := range pipeline {
+		if err := stage.Validate(); err != nil {
+			return nil, err
+		}
+		switch {
+		case stage.Match != nil:
+			stages = append(stages, bson.M{"$match": stage.Match})
+		case stage.Group != nil:
+			stages = append(stages, bson.M{"$group": mgoGroup(stage.Group)})
+		case stage.Sort != "":
+			sort := bson.D{}
+			for _, field := range domain.ParseSort(stage.Sort) {
+				order := 1
+				if field.Descending {
+					order = -1
+				}
+				sort = append(sort, bson.DocElem{Name: field.Field, Value: order})
+			}
+			stages = append(stages, bson.M{"$sort": sort})
+		case stage.Limit > 0:
+			stages = append(stages, bson.M{"$limit": stage.Limit})
+		case stage.Project != nil:
+			project := bson.M{}
+			for _, field := range stage.Project {
+				project[field] = 1
+			}
+			stages = append(stages, bson.M{"$project": project})
+		}
+	}
+	return stages, nil
+}
+
+// mgoGroup Returns the `$group` stage of a domain.Group
+func mgoGroup(group *domain.Group) bson.M {
+	var id interface{}
+	if len(group.By) == 1 {
+		id = "$" + group.By[0]
+	} else if len(group.By) > 1 {
+		fields := bson.M{}
+		for _, field := range group.By {
+			fields[domain.GroupID(field)] = "$" + field
+		}
+		id = fields
+	}
+	mgoGroup := bson.M{"_id": id}
+	for name, accumulator := range group.Fields {
+		if accumulator.Operator == domain.AccumulatorCount {
+			mgoGroup[name] = bson.M{"$sum": 1}
+		} else {
+			mgoGroup[name] = bson.M{"$" + accumulator.Operator: "$" + accumulator.Field}
+		}
+	}
+	return mgoGroup
+}
+
+// mgoIndex Returns the mgo.Index of a domain.Index
+func mgoIndex(index domain.Index) mgo.Index {
+	return mgo.Index{
+		Name:        index.Name,
+		Key:         index.Fields,
+		Unique:      index.Unique,
+		Sparse:      index.Sparse,
+		ExpireAfter: index.ExpireAfter,
+	}
 }
 
-func (db *MongoDB) EnsureIndex(name string, index mgo.Index) error {
-	return db.currentDb.C(name).EnsureIndex(index)
+// run Returns the mapped error of the operation, or of the context if it is done before the operation starts.
+// mgo operations can not be interrupted: if the context has a deadline, the operation runs on a copy of the
+// session whose socket timeout expires with the deadline, otherwise it runs until it returns.
+func (db *MongoDB) run(ctx context.Context, op func(database *mgo.Database) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return mapError(err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return mapError(op(db.currentDb))
+	}
+	timeout := deadline.Sub(time.Now())
+	if timeout <= 0 {
+		return domain.ErrTimeout
+	}
+	session := db.currentDb.Session.Copy()
+	defer session.Close()
+	session.SetSocketTimeout(timeout)
+	return mapError(op(db.currentDb.With(session)))
+}
+
+// mapError Returns the domain error for mgo and context errors
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == mgo.ErrNotFound {
+		return domain.ErrNotFound
+	}
+	if err == context.DeadlineExceeded {
+		return domain.ErrTimeout
+	}
+	if err == context.Canceled {
+		return err
+	}
+	if mgo.IsDup(err) {
+		return domain.ErrDuplicateKey
+	}
+	if queryErr, ok := err.(*mgo.QueryError); ok {
+		switch queryErr.Code {
+		case 50:
+			return domain.ErrTimeout
+		case 112:
+			return domain.ErrConflict
+		}
+	}
+	if lastErr, ok := err.(*mgo.LastError); ok && lastErr.Code == 112 {
+		return domain.ErrConflict
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return domain.ErrTimeout
+	}
+	return err
 }
 
 // MongoDatabaseSession struct implements IContextMiddleware
@@ -0,0 +1,12 @@
+package mongodb_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMongoDB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MongoDB Suite")
+}
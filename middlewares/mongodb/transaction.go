@@ -0,0 +1,214 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotSupportedInTransaction is returned for operations that can not be undone by a transaction
+var ErrNotSupportedInTransaction = errors.New("Operation not supported in a transaction")
+
+// transaction type
+// implements IDatabase in MongoDB.WithTransaction()
+// MongoDB has no multi-document transactions, writes are applied immediately and logged with the
+// compensating action that undoes them, run in reverse order if the transaction fails.
+// The action is logged before the write, since a write that times out may still be applied by the server.
+type transaction struct {
+	*MongoDB
+	undo []func(ctx context.Context) error
+}
+
+// WithTransaction runs fn with a database that undoes its writes if fn returns an error.
+// Writes are visible to other requests before fn returns, and a document written by another request
+// during fn is overwritten when the transaction is undone.
+// DropCollection() and DropDatabase() return ErrNotSupportedInTransaction, indexes are not removed.
+func (db *MongoDB) WithTransaction(ctx context.Context, fn func(tx domain.IDatabase) error) error {
+	tx := &transaction{MongoDB: db}
+	err := fn(tx)
+	if err == nil {
+		return nil
+	}
+	if rollbackErr := tx.rollback(); rollbackErr != nil {
+		return errors.New(fmt.Sprintf("%v; error undoing the transaction: %v", err, rollbackErr))
+	}
+	return err
+}
+
+// rollback runs the compensating actions in reverse order, and Returns the first error
+func (tx *transaction) rollback() error {
+	// undo even if the request has been canceled
+	ctx := context.Background()
+	var firstErr error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// restore logs the compensating action that restores documents to their previous version
+func (tx *transaction) restore(name string, previous ...bson.M) {
+	tx.undo = append(tx.undo, func(ctx context.Context) error {
+		for _, doc := range previous {
+			change := domain.Change{Update: domain.Query(doc), Upsert: true}
+			if err := tx.MongoDB.Update(ctx, name, domain.Query{documents.IDField: doc[documents.IDField]}, change, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// remove logs the compensating action that removes an inserted document
+func (tx *transaction) remove(name string, id interface{}) {
+	tx.undo = append(tx.undo, func(ctx context.Context) error {
+		err := tx.MongoDB.RemoveOne(ctx, name, domain.Query{documents.IDField: id})
+		if err == domain.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// write runs a write whose compensating action has just been logged, and drops the action if the write
+// was rejected by the database; it is kept for other errors, for eg: ErrTimeout, as the write may be applied
+func (tx *transaction) write(fn func() error) error {
+	err := fn()
+	if err == domain.ErrDuplicateKey || err == domain.ErrNotFound {
+		tx.undo = tx.undo[:len(tx.undo)-1]
+	}
+	return err
+}
+
+// upsertID Returns the `_id` of the document inserted by an upsert and the update that sets it,
+// so that the inserted document can be removed even if the upsert does not return
+func upsertID(query domain.Query, update domain.Query) (interface{}, domain.Query) {
+	if id, ok := query[documents.IDField]; ok {
+		if _, isMap := documents.AsMap(id); !isMap {
+			return id, update
+		}
+	}
+	updated := domain.Query{}
+	for key, value := range update {
+		updated[key] = value
+	}
+	if !documents.IsOperatorQuery(update) {
+		return documents.EnsureID(bson.M(updated)), updated
+	}
+	setOnInsert := domain.Query{}
+	if values, ok := documents.AsMap(update["$setOnInsert"]); ok {
+		for key, value := range values {
+			setOnInsert[key] = value
+		}
+	}
+	id, ok := setOnInsert[documents.IDField]
+	if !ok {
+		id = bson.NewObjectId()
+		setOnInsert[documents.IDField] = id
+	}
+	updated["$setOnInsert"] = setOnInsert
+	return id, updated
+}
+
+// idsQuery Returns a query of the documents with the `_id` of the documents
+func idsQuery(docs []bson.M) domain.Query {
+	values := []interface{}{}
+	for _, doc := range docs {
+		values = append(values, doc[documents.IDField])
+	}
+	return domain.Query{documents.IDField: domain.Query{"$in": values}}
+}
+
+func andQuery(queries ...domain.Query) domain.Query {
+	return domain.Query{"$and": queries}
+}
+
+func (tx *transaction) Insert(ctx context.Context, name string, obj interface{}) error {
+	doc, err := documents.Encode(obj)
+	if err != nil {
+		return err
+	}
+	tx.remove(name, documents.EnsureID(doc))
+	return tx.write(func() error {
+		return tx.MongoDB.Insert(ctx, name, doc)
+	})
+}
+
+func (tx *transaction) Update(ctx context.Context, name string, query domain.Query, change domain.Change, result interface{}) error {
+	var previous bson.M
+	err := tx.MongoDB.FindOne(ctx, name, query, &previous)
+	if err == domain.ErrNotFound {
+		if !change.Upsert || change.Remove {
+			return err
+		}
+		id, update := upsertID(query, change.Update)
+		change.Update = update
+		tx.remove(name, id)
+		return tx.write(func() error {
+			return tx.MongoDB.Update(ctx, name, query, change, result)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	// update the loaded document only, so that it is the one restored
+	query = andQuery(query, domain.Query{documents.IDField: previous[documents.IDField]})
+	change.Upsert = false
+	tx.restore(name, previous)
+	return tx.write(func() error {
+		return tx.MongoDB.Update(ctx, name, query, change, result)
+	})
+}
+
+func (tx *transaction) UpdateAll(ctx context.Context, name string, query domain.Query, change domain.Query) (int, error) {
+	var previous []bson.M
+	if err := tx.MongoDB.FindAll(ctx, name, query, &previous, 0, ""); err != nil {
+		return 0, err
+	}
+	if len(previous) == 0 {
+		return 0, nil
+	}
+	tx.restore(name, previous...)
+	return tx.MongoDB.UpdateAll(ctx, name, andQuery(query, idsQuery(previous)), change)
+}
+
+func (tx *transaction) RemoveOne(ctx context.Context, name string, query domain.Query) error {
+	var previous bson.M
+	if err := tx.MongoDB.FindOne(ctx, name, query, &previous); err != nil {
+		return err
+	}
+	tx.restore(name, previous)
+	return tx.write(func() error {
+		return tx.MongoDB.RemoveOne(ctx, name, domain.Query{documents.IDField: previous[documents.IDField]})
+	})
+}
+
+func (tx *transaction) RemoveAll(ctx context.Context, name string, query domain.Query) error {
+	var previous []bson.M
+	if err := tx.MongoDB.FindAll(ctx, name, query, &previous, 0, ""); err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	tx.restore(name, previous...)
+	return tx.MongoDB.RemoveAll(ctx, name, andQuery(query, idsQuery(previous)))
+}
+
+func (tx *transaction) DropCollection(ctx context.Context, name string) error {
+	return ErrNotSupportedInTransaction
+}
+
+func (tx *transaction) DropDatabase(ctx context.Context) error {
+	return ErrNotSupportedInTransaction
+}
+
+// WithTransaction runs fn in the same transaction
+func (tx *transaction) WithTransaction(ctx context.Context, fn func(tx domain.IDatabase) error) error {
+	return fn(tx)
+}
@@ -0,0 +1,32 @@
+//go:build mongodb
+// +build mongodb
+
+package mongodb_test
+
+import (
+	"context"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/mongodb"
+	"github.com/sogko/slumber/test_helpers"
+	"os"
+)
+
+// the specs run against a MongoDB server, on localhost or $MONGODB_SERVER, with `go test -tags mongodb`
+var _ = test_helpers.DescribeDatabaseConformance("MongoDB", func() (domain.IDatabase, func()) {
+	serverName := os.Getenv("MONGODB_SERVER")
+	if serverName == "" {
+		serverName = "localhost"
+	}
+	ctx := context.Background()
+	db := mongodb.New(&mongodb.Options{
+		ServerName:   serverName,
+		DatabaseName: "test-slumber-mongodb",
+	})
+	session := db.NewSession()
+	Expect(db.DropDatabase(ctx)).To(BeNil())
+	return db, func() {
+		db.DropDatabase(ctx)
+		session.Close()
+	}
+})
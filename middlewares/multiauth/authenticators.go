@@ -0,0 +1,163 @@
+package multiauth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/sogko/slumber/domain"
+	"net/http"
+	"strings"
+)
+
+const SchemeBearer = "bearer"
+const SchemeCert = "cert"
+const SchemeCookie = "cookie"
+
+// MiddlewareAuthenticator type
+// implements IAuthenticator
+// It adapts an authenticator middleware that sets the current user into the context,
+// for eg: the JWT bearer authenticator of the sessions resource.
+type MiddlewareAuthenticator struct {
+	scheme     string
+	middleware domain.IMiddleware
+	ctx        domain.IContext
+}
+
+// FromMiddleware Returns an IAuthenticator for the scheme, that runs the middleware.
+// If the middleware answers the request instead of calling the next handler, the response is turned into
+// an AuthenticationError.
+func FromMiddleware(scheme string, middleware domain.IMiddleware, ctx domain.IContext) *MiddlewareAuthenticator {
+	return &MiddlewareAuthenticator{scheme, middleware, ctx}
+}
+
+func (authenticator *MiddlewareAuthenticator) Scheme() string {
+	return authenticator.scheme
+}
+
+func (authenticator *MiddlewareAuthenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	var user domain.IUser
+	called := false
+	w := &capturingResponseWriter{header: http.Header{}}
+	authenticator.middleware.Handler(w, req, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		user = authenticator.ctx.GetCurrentUserCtx(r)
+	})
+	if called {
+		return user, nil
+	}
+
+	status := w.status
+	if status == 0 || status < 400 {
+		status = http.StatusUnauthorized
+	}
+	message := strings.TrimSpace(w.body.String())
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(w.body.Bytes(), &body) == nil && body.Message != "" {
+		message = body.Message
+	}
+	header := http.Header{}
+	if challenge := w.header.Get("WWW-Authenticate"); challenge != "" {
+		header.Set("WWW-Authenticate", challenge)
+	}
+	return nil, &domain.AuthenticationError{Status: status, Message: message, Header: header}
+}
+
+// capturingResponseWriter records the response written by a middleware
+type capturingResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *capturingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *capturingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// CertLookupFunc Returns the user of a verified client certificate, or nil if it is unknown;
+// errors are answered with 5xx, see domain.AuthenticationErrorOf()
+type CertLookupFunc func(cert *x509.Certificate) (domain.IUser, error)
+
+// CertAuthenticator type
+// implements IAuthenticator
+// It authenticates requests with a TLS client certificate (mTLS). The server must request and verify client
+// certificates, for eg: with tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}.
+type CertAuthenticator struct {
+	lookup CertLookupFunc
+}
+
+// NewCertAuthenticator Returns a new CertAuthenticator object
+func NewCertAuthenticator(lookup CertLookupFunc) *CertAuthenticator {
+	return &CertAuthenticator{lookup}
+}
+
+func (authenticator *CertAuthenticator) Scheme() string {
+	return SchemeCert
+}
+
+func (authenticator *CertAuthenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	if len(req.TLS.VerifiedChains) == 0 {
+		return nil, domain.NewAuthenticationError("Client certificate has not been verified", "")
+	}
+	user, err := authenticator.lookup(req.TLS.PeerCertificates[0])
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.NewAuthenticationError("Unknown client certificate", "")
+	}
+	return user, nil
+}
+
+// CookieLookupFunc Returns the user of a session cookie value, or nil if the session is invalid;
+// errors are answered with 5xx, see domain.AuthenticationErrorOf()
+type CookieLookupFunc func(value string) (domain.IUser, error)
+
+// CookieAuthenticator type
+// implements IAuthenticator
+// It authenticates requests with a session cookie
+type CookieAuthenticator struct {
+	name   string
+	lookup CookieLookupFunc
+}
+
+// NewCookieAuthenticator Returns a CookieAuthenticator for the cookie with the given name
+func NewCookieAuthenticator(name string, lookup CookieLookupFunc) *CookieAuthenticator {
+	return &CookieAuthenticator{name, lookup}
+}
+
+func (authenticator *CookieAuthenticator) Scheme() string {
+	return SchemeCookie
+}
+
+func (authenticator *CookieAuthenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	cookie, err := req.Cookie(authenticator.name)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	user, err := authenticator.lookup(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.NewAuthenticationError("Invalid or expired session", "")
+	}
+	return user, nil
+}
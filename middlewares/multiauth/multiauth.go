@@ -0,0 +1,131 @@
+package multiauth
+
+import (
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const SchemeCtxKey domain.ContextKey = "slumber-mddlwr-multiauth-scheme-key"
+
+// Options type
+// Authenticators are tried in the given order, for eg: JWT bearer, API key, Basic, client certificate, session cookie
+type Options struct {
+	Authenticators []domain.IAuthenticator
+	Renderer       domain.IRenderer
+}
+
+// Authenticator type
+// implements IContextMiddleware
+// The first authenticator that returns a user authenticates the request, and its scheme is saved into the
+// request context. If an authenticator rejects the credentials, the next authenticators are still tried;
+// the first error is answered only if none of them authenticates the request, with 5xx if it is not an
+// AuthenticationError. Authenticators that implement IContextAuthenticator save their context, so that
+// for eg: apikey.GetAPIKeyCtx() works for requests authenticated with an API key.
+// Requests without credentials are passed through without a current user.
+type Authenticator struct {
+	options *Options
+}
+
+// New Returns a new Authenticator object
+func New(options *Options) *Authenticator {
+	return &Authenticator{options}
+}
+
+// Authenticate Returns the user of the request and the scheme it authenticated with
+func (authenticator *Authenticator) Authenticate(req *http.Request) (domain.IUser, string, error) {
+	user, a, err := authenticator.authenticate(req)
+	if user == nil {
+		return nil, "", err
+	}
+	return user, a.Scheme(), nil
+}
+
+// authenticate Returns the user of the request and the authenticator that authenticated it
+func (authenticator *Authenticator) authenticate(req *http.Request) (domain.IUser, domain.IAuthenticator, error) {
+	var firstErr error
+	for _, a := range authenticator.options.Authenticators {
+		user, err := a.Authenticate(req)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if user != nil {
+			return user, a, nil
+		}
+	}
+	return nil, nil, firstErr
+}
+
+func (authenticator *Authenticator) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+	user, a, err := authenticator.authenticate(req)
+	if err != nil {
+		if _, ok := err.(*domain.AuthenticationError); !ok {
+			log.Printf("Error authenticating request: %v", err.Error())
+		}
+		domain.AuthenticationErrorOf(err).Write(w, req, authenticator.options.Renderer)
+		return
+	}
+	if user != nil {
+		if contextAuthenticator, ok := a.(domain.IContextAuthenticator); ok {
+			contextAuthenticator.SetContext(ctx, req, user)
+		}
+		ctx.SetCurrentUserCtx(req, user)
+		SetSchemeCtx(ctx, req, a.Scheme())
+	}
+	next(w, req)
+}
+
+func SetSchemeCtx(ctx domain.IContext, r *http.Request, scheme string) {
+	ctx.Set(r, SchemeCtxKey, scheme)
+}
+
+// GetSchemeCtx Returns the scheme the request has been authenticated with, or "" if it is not authenticated
+func GetSchemeCtx(ctx domain.IContext, r *http.Request) string {
+	if scheme := ctx.Get(r, SchemeCtxKey); scheme != nil {
+		return scheme.(string)
+	}
+	return ""
+}
+
+// SchemeRestriction type
+// implements IContextMiddleware
+type SchemeRestriction struct {
+	schemes  []string
+	renderer domain.IRenderer
+}
+
+// RequireSchemes Returns a route middleware that only accepts users authenticated with one of the schemes,
+// for eg:
+//
+//	domain.Route{
+//	  Name:        "ImportUsers",
+//	  ...
+//	  Middlewares: []interface{}{multiauth.RequireSchemes(renderer, apikey.Scheme)},
+//	}
+//
+// Requests without a current user are passed through, so that ACL handlers decide on anonymous access.
+func RequireSchemes(renderer domain.IRenderer, schemes ...string) *SchemeRestriction {
+	return &SchemeRestriction{schemes, renderer}
+}
+
+func (restriction *SchemeRestriction) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+	if ctx.GetCurrentUserCtx(req) == nil {
+		next(w, req)
+		return
+	}
+	scheme := GetSchemeCtx(ctx, req)
+	for _, accepted := range restriction.schemes {
+		if scheme == accepted {
+			next(w, req)
+			return
+		}
+	}
+	err := domain.NewAuthenticationError(fmt.Sprintf("Authentication scheme `%v` is not accepted, use one of: %v",
+		scheme, strings.Join(restriction.schemes, ", ")), "")
+	err.Write(w, req, restriction.renderer)
+}
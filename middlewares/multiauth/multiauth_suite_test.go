@@ -0,0 +1,12 @@
+package multiauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMultiAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MultiAuth Suite")
+}
@@ -0,0 +1,341 @@
+package multiauth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/apikey"
+	"github.com/sogko/slumber/middlewares/boltdb"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/middlewares/multiauth"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// testUser implements IUser
+type testUser struct {
+	id string
+}
+
+func (user *testUser) GetID() string                              { return user.id }
+func (user *testUser) IsValid() bool                              { return true }
+func (user *testUser) IsCodeVerified(code string) bool            { return false }
+func (user *testUser) IsCredentialsVerified(password string) bool { return false }
+func (user *testUser) SetPassword(password string) error          { return nil }
+func (user *testUser) GenerateConfirmationCode()                  {}
+func (user *testUser) HasRole(r domain.IRole) bool                { return false }
+
+// testAuthenticator implements IAuthenticator, it returns user and err and records the calls
+type testAuthenticator struct {
+	scheme string
+	user   domain.IUser
+	err    error
+	calls  *[]string
+}
+
+func (authenticator *testAuthenticator) Scheme() string {
+	return authenticator.scheme
+}
+
+func (authenticator *testAuthenticator) Authenticate(req *http.Request) (domain.IUser, error) {
+	*authenticator.calls = append(*authenticator.calls, authenticator.scheme)
+	return authenticator.user, authenticator.err
+}
+
+// testMiddleware implements IMiddleware
+type testMiddleware func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc)
+
+func (middleware testMiddleware) Handler(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	middleware(w, req, next)
+}
+
+var _ = Describe("MultiAuth", func() {
+
+	var ctx domain.IContext
+	var calls []string
+
+	serve := func(middleware domain.IContextMiddleware, request *http.Request) (*httptest.ResponseRecorder, domain.IUser, bool) {
+		recorder := httptest.NewRecorder()
+		var user domain.IUser
+		called := false
+		middleware.Handler(recorder, request, func(w http.ResponseWriter, req *http.Request) {
+			called = true
+			user = ctx.GetCurrentUserCtx(req)
+		}, ctx)
+		return recorder, user, called
+	}
+	authenticator := func(scheme string, user domain.IUser, err error) domain.IAuthenticator {
+		return &testAuthenticator{scheme, user, err, &calls}
+	}
+
+	BeforeEach(func() {
+		ctx = context.New()
+		calls = []string{}
+	})
+
+	Describe("Authenticator", func() {
+		It("should authenticate with the first authenticator that returns a user", func() {
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("bearer", nil, nil),
+				authenticator("apikey", &testUser{"key"}, nil),
+				authenticator("basic", &testUser{"basic"}, nil),
+			}})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder, user, called := serve(middleware, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(called).To(BeTrue())
+			Expect(user.GetID()).To(Equal("key"))
+			Expect(multiauth.GetSchemeCtx(ctx, request)).To(Equal("apikey"))
+			Expect(calls).To(Equal([]string{"bearer", "apikey"}))
+		})
+		It("should fall back to the next authenticators if credentials are rejected", func() {
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("bearer", nil, domain.NewAuthenticationError("Invalid token", "Bearer")),
+				authenticator("basic", &testUser{"basic"}, nil),
+			}})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder, user, _ := serve(middleware, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(user.GetID()).To(Equal("basic"))
+			Expect(multiauth.GetSchemeCtx(ctx, request)).To(Equal("basic"))
+		})
+		It("should answer the first error if no authenticator returns a user", func() {
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("bearer", nil, nil),
+				authenticator("apikey", nil, domain.NewAuthenticationError("Invalid API key", "ApiKey")),
+				authenticator("basic", nil, domain.NewAuthenticationError("Invalid username or password", "Basic")),
+			}})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder, _, called := serve(middleware, request)
+			Expect(called).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal("ApiKey"))
+			Expect(recorder.Body.String()).To(ContainSubstring("Invalid API key"))
+			Expect(calls).To(Equal([]string{"bearer", "apikey", "basic"}))
+		})
+		It("should answer errors that are not authentication errors with 5xx", func() {
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("basic", nil, errors.New("connection refused")),
+			}})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder, _, called := serve(middleware, request)
+			Expect(called).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(recorder.Body.String()).ToNot(ContainSubstring("connection refused"))
+		})
+		It("should pass through requests without credentials", func() {
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("bearer", nil, nil),
+			}})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			recorder, user, called := serve(middleware, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(called).To(BeTrue())
+			Expect(user).To(BeNil())
+			Expect(multiauth.GetSchemeCtx(ctx, request)).To(Equal(""))
+		})
+		It("should save the API key of requests authenticated with an API key", func() {
+			dir, err := ioutil.TempDir("", "multiauth")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			db := boltdb.New(&boltdb.Options{Path: filepath.Join(dir, "test.db")})
+			Expect(db.Open()).To(BeNil())
+			defer db.Close()
+			store := apikey.NewStore(db, nil)
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			key, _, err := store.Create(request.Context(), "ci", "user", nil, nil, time.Time{})
+			Expect(err).To(BeNil())
+
+			middleware := multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{
+				authenticator("bearer", nil, nil),
+				apikey.New(&apikey.Options{Store: store}),
+			}})
+			request.Header.Set(apikey.DefaultHeader, key)
+			recorder, user, _ := serve(middleware, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(user.GetID()).To(Equal("user"))
+			Expect(apikey.GetAPIKeyCtx(ctx, request).Name).To(Equal("ci"))
+		})
+	})
+
+	Describe("FromMiddleware()", func() {
+		fromMiddleware := func(handler func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc)) domain.IAuthenticator {
+			return multiauth.FromMiddleware(multiauth.SchemeBearer, testMiddleware(handler), ctx)
+		}
+
+		It("should return the user set by the middleware", func() {
+			authenticator := fromMiddleware(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+				ctx.SetCurrentUserCtx(req, &testUser{"bearer"})
+				next(w, req)
+			})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			user, err := authenticator.Authenticate(request)
+			Expect(err).To(BeNil())
+			Expect(user.GetID()).To(Equal("bearer"))
+			Expect(authenticator.Scheme()).To(Equal(multiauth.SchemeBearer))
+		})
+		It("should return no user if the middleware passes the request through", func() {
+			authenticator := fromMiddleware(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+				next(w, req)
+			})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			user, err := authenticator.Authenticate(request)
+			Expect(err).To(BeNil())
+			Expect(user).To(BeNil())
+		})
+		It("should turn the response of the middleware into an AuthenticationError", func() {
+			authenticator := fromMiddleware(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message": "Invalid token"}`))
+			})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			_, err := authenticator.Authenticate(request)
+			Expect(err).To(Equal(&domain.AuthenticationError{
+				Status:  http.StatusUnauthorized,
+				Message: "Invalid token",
+				Header:  http.Header{"Www-Authenticate": []string{"Bearer"}},
+			}))
+		})
+		It("should answer 401 if the middleware answers without an error status", func() {
+			authenticator := fromMiddleware(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+				w.Write([]byte("not authenticated"))
+			})
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			_, err := authenticator.Authenticate(request)
+			Expect(err.(*domain.AuthenticationError).Status).To(Equal(http.StatusUnauthorized))
+			Expect(err.(*domain.AuthenticationError).Message).To(Equal("not authenticated"))
+		})
+	})
+
+	Describe("CertAuthenticator", func() {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+		var lookupErr error
+		certAuthenticator := multiauth.NewCertAuthenticator(func(cert *x509.Certificate) (domain.IUser, error) {
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			if cert.Subject.CommonName == "client" {
+				return &testUser{"client"}, nil
+			}
+			return nil, nil
+		})
+		request := func(cert *x509.Certificate, verified bool) *http.Request {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			if verified {
+				request.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+			return request
+		}
+
+		BeforeEach(func() {
+			lookupErr = nil
+		})
+		It("should return the user of a verified client certificate", func() {
+			user, err := certAuthenticator.Authenticate(request(cert, true))
+			Expect(err).To(BeNil())
+			Expect(user.GetID()).To(Equal("client"))
+		})
+		It("should return no user without a client certificate", func() {
+			plain, _ := http.NewRequest("GET", "/api/users", nil)
+			user, err := certAuthenticator.Authenticate(plain)
+			Expect(err).To(BeNil())
+			Expect(user).To(BeNil())
+		})
+		It("should reject unverified and unknown certificates", func() {
+			_, err := certAuthenticator.Authenticate(request(cert, false))
+			Expect(err).To(BeAssignableToTypeOf(&domain.AuthenticationError{}))
+			_, err = certAuthenticator.Authenticate(request(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}}, true))
+			Expect(err.(*domain.AuthenticationError).Status).To(Equal(http.StatusUnauthorized))
+		})
+		It("should return lookup errors unchanged", func() {
+			lookupErr = errors.New("connection refused")
+			_, err := certAuthenticator.Authenticate(request(cert, true))
+			Expect(err).To(Equal(lookupErr))
+			Expect(domain.AuthenticationErrorOf(err).Status).To(BeNumerically(">=", http.StatusInternalServerError))
+		})
+	})
+
+	Describe("CookieAuthenticator", func() {
+		var lookupErr error
+		cookieAuthenticator := multiauth.NewCookieAuthenticator("session", func(value string) (domain.IUser, error) {
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			if value == "valid" {
+				return &testUser{"user"}, nil
+			}
+			return nil, nil
+		})
+		request := func(value string) *http.Request {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			if value != "" {
+				request.AddCookie(&http.Cookie{Name: "session", Value: value})
+			}
+			return request
+		}
+
+		BeforeEach(func() {
+			lookupErr = nil
+		})
+		It("should return the user of the session cookie", func() {
+			user, err := cookieAuthenticator.Authenticate(request("valid"))
+			Expect(err).To(BeNil())
+			Expect(user.GetID()).To(Equal("user"))
+			Expect(cookieAuthenticator.Scheme()).To(Equal(multiauth.SchemeCookie))
+		})
+		It("should return no user without a session cookie", func() {
+			user, err := cookieAuthenticator.Authenticate(request(""))
+			Expect(err).To(BeNil())
+			Expect(user).To(BeNil())
+		})
+		It("should reject invalid sessions", func() {
+			_, err := cookieAuthenticator.Authenticate(request("expired"))
+			Expect(err.(*domain.AuthenticationError).Status).To(Equal(http.StatusUnauthorized))
+		})
+		It("should return lookup errors unchanged", func() {
+			lookupErr = errors.New("connection refused")
+			_, err := cookieAuthenticator.Authenticate(request("valid"))
+			Expect(err).To(Equal(lookupErr))
+			recorder, _, called := serve(multiauth.New(&multiauth.Options{Authenticators: []domain.IAuthenticator{cookieAuthenticator}}), request("valid"))
+			Expect(called).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Describe("RequireSchemes()", func() {
+		restriction := multiauth.RequireSchemes(nil, apikey.Scheme, "cert")
+
+		It("should accept users authenticated with one of the schemes", func() {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			ctx.SetCurrentUserCtx(request, &testUser{"user"})
+			multiauth.SetSchemeCtx(ctx, request, "cert")
+			recorder, _, called := serve(restriction, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(called).To(BeTrue())
+		})
+		It("should reject users authenticated with other schemes", func() {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			ctx.SetCurrentUserCtx(request, &testUser{"user"})
+			multiauth.SetSchemeCtx(ctx, request, "basic")
+			recorder, _, called := serve(restriction, request)
+			Expect(called).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Body.String()).To(ContainSubstring("basic"))
+		})
+		It("should pass through requests without a current user", func() {
+			request, _ := http.NewRequest("GET", "/api/users", nil)
+			_, _, called := serve(restriction, request)
+			Expect(called).To(BeTrue())
+		})
+	})
+})
@@ -87,6 +87,17 @@ func (renderer *Renderer) Text(w http.ResponseWriter, status int, v []byte) {
 	w.Write(v)
 }
 
+// RenderPage renders a page of results in a domain.PageEnvelope, with a `Link` header to the next page
+func (renderer *Renderer) RenderPage(w http.ResponseWriter, req *http.Request, status int, data interface{}, page *domain.Page) {
+	domain.SetPageLinkHeader(w, req, page)
+	renderer.Render(w, req, status, &domain.PageEnvelope{Data: data, Page: page})
+}
+
+// RenderFields renders v, an object or a list of objects, with only the fields of the projection, see domain.ParseProjection()
+func (renderer *Renderer) RenderFields(w http.ResponseWriter, req *http.Request, status int, v interface{}, projection domain.Projection) {
+	renderer.Render(w, req, status, projection.Filter(v))
+}
+
 func SetRendererCtx(ctx domain.IContext, r *http.Request, renderer *Renderer) {
 	ctx.Set(r, RendererKey, renderer)
 }
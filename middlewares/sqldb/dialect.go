@@ -0,0 +1,142 @@
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect type
+// It declares the SQL variant of the database, the JSON functions used to query documents depend on it.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite3"
+)
+
+// placeholder Returns the placeholder of the nth (1-based) query argument
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%v", n)
+	}
+	return "?"
+}
+
+// createTable Returns the statement that creates the table of a collection.
+// `doc` keeps the BSON encoding of documents, so that they decode into the same structs as with the MongoDB backend;
+// `data` is the normalized JSON document that queries, sorts and indexes are evaluated against.
+func (d Dialect) createTable(table string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (id TEXT PRIMARY KEY, doc BYTEA NOT NULL, data JSONB NOT NULL)`, table)
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (id TEXT PRIMARY KEY, doc BLOB NOT NULL, data TEXT NOT NULL)`, table)
+}
+
+// listTables Returns the query that lists the tables of the database
+func (d Dialect) listTables() string {
+	if d == Postgres {
+		return `SELECT tablename FROM pg_tables WHERE schemaname = current_schema()`
+	}
+	return `SELECT name FROM sqlite_master WHERE type = 'table'`
+}
+
+// field Returns the expression of a document field, for eg: `profile.name`
+func (d Dialect) field(field string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`(data #> '{%v}')`, strings.Replace(field, ".", ",", -1))
+	}
+	return fmt.Sprintf(`json_extract(data, '$.%v')`, field)
+}
+
+// jsonArgument Returns the expression of a JSON encoded query argument
+func (d Dialect) jsonArgument(placeholder string) string {
+	if d == Postgres {
+		return placeholder + "::jsonb"
+	}
+	return "json(" + placeholder + ")"
+}
+
+// isNull Returns the condition that matches documents where the field is null or missing
+func (d Dialect) isNull(field string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`(%v IS NULL OR %v = 'null'::jsonb)`, d.field(field), d.field(field))
+	}
+	return fmt.Sprintf(`%v IS NULL`, d.field(field))
+}
+
+// exists Returns the condition that matches documents that have the field, even if it is null
+func (d Dialect) exists(field string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`%v IS NOT NULL`, d.field(field))
+	}
+	return fmt.Sprintf(`json_type(data, '$.%v') IS NOT NULL`, field)
+}
+
+// equalScalar Returns the condition that matches documents where the field equals a scalar value,
+// or is an array that contains the value; the condition uses the placeholders returned by the function
+func (d Dialect) equalScalar(field string, placeholder func() string) string {
+	if d == Postgres {
+		return fmt.Sprintf(`COALESCE(%v @> %v, FALSE)`, d.field(field), d.jsonArgument(placeholder()))
+	}
+	return fmt.Sprintf(`(COALESCE(%v = %v, 0) OR EXISTS (SELECT 1 FROM json_each(data, '$.%v') WHERE json_type(data, '$.%v') = 'array' AND json_each.value = %v))`,
+		d.field(field), placeholder(), field, field, placeholder())
+}
+
+// equalDocument Returns the condition that matches documents where the field equals a JSON encoded document or array
+func (d Dialect) equalDocument(field string, placeholder func() string) string {
+	return fmt.Sprintf(`COALESCE(%v = %v, FALSE)`, d.field(field), d.jsonArgument(placeholder()))
+}
+
+// sqliteTypes are the SQLite json_type() values of the JSON types of comparable values
+var sqliteTypes = map[string]string{"number": `'integer', 'real'`, "string": `'text'`, "boolean": `'true', 'false'`}
+
+// compare Returns the condition that compares the field to a value of a JSON type, see jsonType(), with a SQL
+// comparison operator. Fields of another type never match, like with MongoDB: the databases order JSON values
+// of different types, for eg: SQLite orders text after numbers.
+func (d Dialect) compare(field string, operator string, valueType string, placeholder func() string) string {
+	if d == Postgres {
+		if valueType == "" {
+			return "FALSE"
+		}
+		return fmt.Sprintf(`COALESCE(jsonb_typeof(%v) = '%v' AND %v %v %v, FALSE)`,
+			d.field(field), valueType, d.field(field), operator, d.jsonArgument(placeholder()))
+	}
+	if valueType == "" {
+		return "0"
+	}
+	return fmt.Sprintf(`COALESCE(json_type(data, '$.%v') IN (%v) AND %v %v %v, 0)`,
+		field, sqliteTypes[valueType], d.field(field), operator, placeholder())
+}
+
+// argument Returns the query argument of a normalized scalar value
+func (d Dialect) argument(value interface{}) interface{} {
+	if d == Postgres {
+		return jsonString(value)
+	}
+	return value
+}
+
+// isDuplicateKey Returns true if the error is a unique constraint violation
+func (d Dialect) isDuplicateKey(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "duplicate key") || strings.Contains(message, "UNIQUE constraint failed")
+}
+
+// isConflict Returns true if the error is a serialization failure or a lock that could not be acquired
+func (d Dialect) isConflict(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "could not serialize") || strings.Contains(message, "deadlock detected") ||
+		strings.Contains(message, "database is locked")
+}
+
+// isTimeout Returns true if the error is a statement timeout
+func (d Dialect) isTimeout(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "statement timeout") || strings.Contains(message, "i/o timeout")
+}
+
+// isCanceled Returns true if the error is a statement canceled by its context
+func (d Dialect) isCanceled(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "canceling statement") || strings.Contains(message, "interrupted")
+}
@@ -0,0 +1,233 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var validField = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+var comparisonOperators = map[string]string{
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// queryBuilder translates domain.Query into SQL conditions, collecting the query arguments
+type queryBuilder struct {
+	dialect Dialect
+	args    []interface{}
+}
+
+func newQueryBuilder(dialect Dialect) *queryBuilder {
+	return &queryBuilder{dialect, []interface{}{}}
+}
+
+// placeholder Returns a placeholder function that adds the argument to the query each time it is called
+func (b *queryBuilder) placeholder(arg interface{}) func() string {
+	return func() string {
+		b.args = append(b.args, arg)
+		return b.dialect.placeholder(len(b.args))
+	}
+}
+
+// where Returns the WHERE clause of a query, or an empty string if the query matches every document
+func (b *queryBuilder) where(query domain.Query) (string, error) {
+	condition, err := b.query(query)
+	if err != nil || condition == "" {
+		return "", err
+	}
+	return " WHERE " + condition, nil
+}
+
+// query Returns the condition that matches every field of the query
+func (b *queryBuilder) query(query map[string]interface{}) (string, error) {
+	conditions := []string{}
+	for _, key := range sortedKeys(query) {
+		value := query[key]
+		var condition string
+		var err error
+		switch key {
+		case "$and", "$or":
+			condition, err = b.logical(key, value)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return "", errors.New(fmt.Sprintf("Invalid query, unsupported operator `%v`", key))
+			}
+			condition, err = b.field(key, value)
+		}
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// logical Returns the condition of a `$and` or `$or` list of queries
+func (b *queryBuilder) logical(operator string, value interface{}) (string, error) {
+	listv := reflect.ValueOf(value)
+	if listv.Kind() != reflect.Slice {
+		return "", errors.New(fmt.Sprintf("Invalid query, `%v` expects a list of queries", operator))
+	}
+	conditions := []string{}
+	for i := 0; i < listv.Len(); i++ {
+		subquery, ok := documents.AsMap(listv.Index(i).Interface())
+		if !ok {
+			return "", errors.New(fmt.Sprintf("Invalid query, `%v` expects a list of queries", operator))
+		}
+		condition, err := b.query(subquery)
+		if err != nil {
+			return "", err
+		}
+		if condition == "" {
+			condition = "1 = 1"
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	if len(conditions) == 0 {
+		return "", errors.New(fmt.Sprintf("Invalid query, `%v` expects a non-empty list of queries", operator))
+	}
+	if operator == "$or" {
+		return "(" + strings.Join(conditions, " OR ") + ")", nil
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", nil
+}
+
+// field Returns the condition of a field, either an equality or a document of operators
+func (b *queryBuilder) field(field string, value interface{}) (string, error) {
+	if !validField.MatchString(field) {
+		return "", errors.New(fmt.Sprintf("Invalid query, unsupported field name `%v`", field))
+	}
+	operators, ok := documents.AsMap(value)
+	if !ok || !documents.IsOperatorQuery(operators) {
+		return b.equal(field, value), nil
+	}
+	conditions := []string{}
+	for _, operator := range sortedKeys(operators) {
+		argument := operators[operator]
+		var condition string
+		switch operator {
+		case "$eq":
+			condition = b.equal(field, argument)
+		case "$ne":
+			condition = "NOT " + b.equal(field, argument)
+		case "$gt", "$gte", "$lt", "$lte":
+			value := documents.Normalize(argument)
+			condition = b.dialect.compare(field, comparisonOperators[operator], jsonType(value), b.placeholder(b.dialect.argument(value)))
+		case "$in", "$nin":
+			in, err := b.in(field, operator, argument)
+			if err != nil {
+				return "", err
+			}
+			condition = in
+		case "$exists":
+			if exists, _ := argument.(bool); exists {
+				condition = b.dialect.exists(field)
+			} else {
+				condition = "NOT " + b.dialect.exists(field)
+			}
+		default:
+			return "", errors.New(fmt.Sprintf("Invalid query, unsupported operator `%v`", operator))
+		}
+		conditions = append(conditions, condition)
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", nil
+}
+
+// equal Returns the condition that matches documents where the field equals the value
+func (b *queryBuilder) equal(field string, value interface{}) string {
+	normalized := documents.Normalize(value)
+	switch normalized.(type) {
+	case nil:
+		return b.dialect.isNull(field)
+	case map[string]interface{}, []interface{}:
+		return b.dialect.equalDocument(field, b.placeholder(jsonString(normalized)))
+	}
+	return b.dialect.equalScalar(field, b.placeholder(b.dialect.argument(normalized)))
+}
+
+// in Returns the condition of a `$in` or `$nin` operator
+func (b *queryBuilder) in(field string, operator string, value interface{}) (string, error) {
+	listv := reflect.ValueOf(value)
+	if listv.Kind() != reflect.Slice {
+		return "", errors.New(fmt.Sprintf("Invalid query, `%v` expects a list", operator))
+	}
+	conditions := []string{}
+	for i := 0; i < listv.Len(); i++ {
+		conditions = append(conditions, b.equal(field, listv.Index(i).Interface()))
+	}
+	condition := "(1 = 0)"
+	if len(conditions) > 0 {
+		condition = "(" + strings.Join(conditions, " OR ") + ")"
+	}
+	if operator == "$nin" {
+		return "NOT " + condition, nil
+	}
+	return condition, nil
+}
+
+// orderBy Returns the ORDER BY clause of a sort string, for eg: `-createdAt,name`
+func (b *queryBuilder) orderBy(sort string) (string, error) {
+	columns := []string{}
+	for _, field := range domain.ParseSort(sort) {
+		if !validField.MatchString(field.Field) {
+			return "", errors.New(fmt.Sprintf("Invalid sort, unsupported field name `%v`", field.Field))
+		}
+		column := b.dialect.field(field.Field)
+		if field.Descending {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return "", nil
+	}
+	return " ORDER BY " + strings.Join(columns, ", "), nil
+}
+
+// sortedKeys Returns the keys of a query in a stable order, so that statements are identical for identical queries
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonString Returns the JSON encoding of a normalized value
+func jsonString(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// jsonType Returns the JSON type of a normalized scalar value, `number`, `string` or `boolean`,
+// or an empty string for values that are not compared
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return ""
+}
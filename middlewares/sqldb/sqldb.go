@@ -0,0 +1,657 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/documents"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var validCollection = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Options type
+// DriverName is the name of a database/sql driver registered by the application, for eg: `postgres` from
+// `github.com/lib/pq` or `sqlite3` from `github.com/mattn/go-sqlite3`. Dialect defaults to DriverName.
+// TablePrefix is prepended to collection names, DropDatabase() only drops tables with the prefix.
+// TTL indexes are stored in the `slumber-ttls` table, with the prefix.
+// SQLite requires the JSON functions, built into SQLite 3.38 and later, or enabled with `-tags sqlite_json`
+// in older versions of `github.com/mattn/go-sqlite3`.
+type Options struct {
+	DriverName     string
+	DataSourceName string
+	Dialect        Dialect
+	TablePrefix    string
+}
+
+// SQLDB type
+// implements IDatabase
+// It stores the documents of a collection in a table, as JSONB with PostgreSQL or JSON text with SQLite.
+type SQLDB struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	options *Options
+	tables  map[string]bool
+	ttls    map[string]domain.Index
+	mutex   *sync.Mutex
+}
+
+// New Returns a new SQLDB object, call Open() to connect to the database
+func New(options *Options) *SQLDB {
+	if options.Dialect == "" {
+		options.Dialect = Dialect(options.DriverName)
+	}
+	return &SQLDB{options: options, tables: map[string]bool{}, ttls: map[string]domain.Index{}, mutex: &sync.Mutex{}}
+}
+
+// Open connects to the database
+func (db *SQLDB) Open() error {
+	if db.options.Dialect != Postgres && db.options.Dialect != SQLite {
+		return errors.New(fmt.Sprintf("Unsupported SQL dialect `%v`", db.options.Dialect))
+	}
+	sqlDb, err := sql.Open(db.options.DriverName, db.options.DataSourceName)
+	if err != nil {
+		return err
+	}
+	if err := sqlDb.Ping(); err != nil {
+		sqlDb.Close()
+		return err
+	}
+	if db.options.Dialect == SQLite {
+		// SQLite allows a single writer, serialize connections instead of failing with `database is locked`
+		sqlDb.SetMaxOpenConns(1)
+	}
+	db.db = sqlDb
+	if err := db.loadTTLs(context.Background()); err != nil {
+		sqlDb.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the connections to the database
+func (db *SQLDB) Close() error {
+	if db.db == nil {
+		return nil
+	}
+	return db.db.Close()
+}
+
+// DB Returns the underlying database/sql database
+func (db *SQLDB) DB() *sql.DB {
+	return db.db
+}
+
+// table Returns the quoted table name of a collection, creating the table if it does not exist
+func (db *SQLDB) table(ctx context.Context, name string) (string, error) {
+	if !validCollection.MatchString(name) {
+		return "", errors.New(fmt.Sprintf("Invalid collection name `%v`", name))
+	}
+	table := `"` + db.options.TablePrefix + name + `"`
+	db.mutex.Lock()
+	created := db.tables[name]
+	db.mutex.Unlock()
+	if created {
+		return table, nil
+	}
+	if _, err := db.querier().ExecContext(ctx, db.options.Dialect.createTable(table)); err != nil {
+		return "", db.mapError(ctx, err)
+	}
+	if db.tx != nil {
+		// the table is created by the transaction, it does not exist if the transaction is rolled back
+		return table, nil
+	}
+	db.mutex.Lock()
+	db.tables[name] = true
+	db.mutex.Unlock()
+	return table, nil
+}
+
+// ttlTable Returns the quoted name of the table of TTL indexes, which is not a valid collection name
+func (db *SQLDB) ttlTable() string {
+	return `"` + db.options.TablePrefix + `slumber-ttls"`
+}
+
+// loadTTLs creates the table of TTL indexes if it does not exist and loads the TTL indexes of the collections
+func (db *SQLDB) loadTTLs(ctx context.Context) error {
+	if _, err := db.db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %v (collection TEXT PRIMARY KEY, field TEXT NOT NULL, expire_after BIGINT NOT NULL)`,
+		db.ttlTable())); err != nil {
+		return db.mapError(ctx, err)
+	}
+	rows, err := db.db.QueryContext(ctx, fmt.Sprintf(`SELECT collection, field, expire_after FROM %v`, db.ttlTable()))
+	if err != nil {
+		return db.mapError(ctx, err)
+	}
+	defer rows.Close()
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for rows.Next() {
+		var name, field string
+		var expireAfter int64
+		if err := rows.Scan(&name, &field, &expireAfter); err != nil {
+			return db.mapError(ctx, err)
+		}
+		db.ttls[name] = domain.Index{Fields: []string{field}, ExpireAfter: time.Duration(expireAfter)}
+	}
+	return db.mapError(ctx, rows.Err())
+}
+
+// expired Returns the query that matches the documents of a collection expired by its TTL index, if any
+func (db *SQLDB) expired(name string) (domain.Query, bool) {
+	db.mutex.Lock()
+	index, ok := db.ttls[name]
+	db.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	field := domain.ParseSort(index.Fields[0])[0].Field
+	return domain.Query{field: domain.Query{"$lt": time.Now().Add(-index.ExpireAfter)}}, true
+}
+
+// where Returns the WHERE clause of a query on a collection, excluding the documents expired by its TTL index
+func (db *SQLDB) where(b *queryBuilder, name string, query domain.Query) (string, error) {
+	condition, err := b.query(query)
+	if err != nil {
+		return "", err
+	}
+	if expired, ok := db.expired(name); ok {
+		expiredCondition, err := b.query(expired)
+		if err != nil {
+			return "", err
+		}
+		if condition != "" {
+			condition = "(" + condition + ") AND "
+		}
+		condition += "NOT (" + expiredCondition + ")"
+	}
+	if condition == "" {
+		return "", nil
+	}
+	return " WHERE " + condition, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// querier Returns the transaction of the database in WithTransaction(), or the database
+func (db *SQLDB) querier() querier {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.db
+}
+
+// selectDocs Returns the BSON encoded documents of the collection that match the query
+func (db *SQLDB) selectDocs(ctx context.Context, q querier, name string, table string, query domain.Query, limit int, sort string, forUpdate bool) ([]string, [][]byte, error) {
+	b := newQueryBuilder(db.options.Dialect)
+	where, err := db.where(b, name, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	orderBy, err := b.orderBy(sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	statement := fmt.Sprintf(`SELECT id, doc FROM %v%v%v`, table, where, orderBy)
+	if limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %v", limit)
+	}
+	if forUpdate && db.options.Dialect == Postgres {
+		statement += " FOR UPDATE"
+	}
+	rows, err := q.QueryContext(ctx, statement, b.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	raws := [][]byte{}
+	for rows.Next() {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		raws = append(raws, raw)
+	}
+	return ids, raws, rows.Err()
+}
+
+// encode Returns the id, the BSON encoding and the normalized JSON document of a document
+func encode(doc bson.M) (string, []byte, string, error) {
+	id := documents.EnsureID(doc)
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return "", nil, "", errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+	}
+	data, err := json.Marshal(documents.Normalize(doc))
+	if err != nil {
+		return "", nil, "", errors.New(fmt.Sprintf("Error encoding document: %v", err.Error()))
+	}
+	return jsonString(documents.Normalize(id)), raw, string(data), nil
+}
+
+func (db *SQLDB) insert(ctx context.Context, q querier, table string, doc bson.M) error {
+	id, raw, data, err := encode(doc)
+	if err != nil {
+		return err
+	}
+	d := db.options.Dialect
+	_, err = q.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %v (id, doc, data) VALUES (%v, %v, %v)`, table,
+		d.placeholder(1), d.placeholder(2), d.jsonArgument(d.placeholder(3))), id, raw, data)
+	return err
+}
+
+func (db *SQLDB) replace(ctx context.Context, q querier, table string, id string, doc bson.M) error {
+	_, raw, data, err := encode(doc)
+	if err != nil {
+		return err
+	}
+	d := db.options.Dialect
+	_, err = q.ExecContext(ctx, fmt.Sprintf(`UPDATE %v SET doc = %v, data = %v WHERE id = %v`, table,
+		d.placeholder(1), d.jsonArgument(d.placeholder(2)), d.placeholder(3)), raw, data, id)
+	return err
+}
+
+// transaction runs fn in a transaction, committed if fn returns no error.
+// fn runs in the transaction of WithTransaction(), if any.
+func (db *SQLDB) transaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if db.tx != nil {
+		return fn(db.tx)
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// WithTransaction runs fn in a database transaction, committed if fn returns no error and rolled back otherwise.
+// Operations of tx that fail abort the transaction with PostgreSQL, fn should return their errors.
+func (db *SQLDB) WithTransaction(ctx context.Context, fn func(tx domain.IDatabase) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		return fn(&SQLDB{db: db.db, tx: tx, options: db.options, tables: db.tables, ttls: db.ttls, mutex: db.mutex})
+	})
+	return db.mapError(ctx, err)
+}
+
+func (db *SQLDB) FindOne(ctx context.Context, name string, query domain.Query, result interface{}) error {
+	return db.FindOneProjection(ctx, name, query, nil, result)
+}
+
+// FindOneProjection decodes only the projected fields and `_id` of the first document that matches the query
+func (db *SQLDB) FindOneProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}) error {
+	table, err := db.table(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, raws, err := db.selectDocs(ctx, db.querier(), name, table, query, 1, "", false)
+	if err != nil {
+		return db.mapError(ctx, err)
+	}
+	if len(raws) == 0 {
+		return domain.ErrNotFound
+	}
+	raw, err := documents.ProjectRaw(raws[0], projection)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+func (db *SQLDB) FindAll(ctx context.Context, name string, query domain.Query, result interface{}, limit int, sort string) error {
+	return db.FindAllProjection(ctx, name, query, nil, result, limit, sort)
+}
+
+// FindAllProjection decodes only the projected fields and `_id` of the documents that match the query
+func (db *SQLDB) FindAllProjection(ctx context.Context, name string, query domain.Query, projection domain.Projection, result interface{}, limit int, sort string) error {
+	if sort == "" {
+		sort = "-_id"
+	}
+	table, err := db.table(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, raws, err := db.selectDocs(ctx, db.querier(), name, table, query, limit, sort, false)
+	if err != nil {
+		return db.mapError(ctx, err)
+	}
+	for i, raw := range raws {
+		if raws[i], err = documents.ProjectRaw(raw, projection); err != nil {
+			return err
+		}
+	}
+	return documents.UnmarshalAll(raws, result)
+}
+
+// FindPage Returns a page of the documents that match the query, using keyset pagination on the sort fields
+func (db *SQLDB) FindPage(ctx context.Context, name string, query domain.Query, result interface{}, sort string, page domain.PageRequest) (*domain.Page, error) {
+	return documents.FindPage(ctx, db, name, query, result, sort, page)
+}
+
+// Aggregate runs the stages of the pipeline in memory, on the documents selected by its first Match stages
+func (db *SQLDB) Aggregate(ctx context.Context, name string, pipeline []domain.Stage, result interface{}) error {
+	return documents.Aggregate(ctx, db, name, pipeline, result)
+}
+
+func (db *SQLDB) Count(ctx context.Context, name string, query domain.Query) (int, error) {
+	table, err := db.table(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	b := newQueryBuilder(db.options.Dialect)
+	where, err := db.where(b, name, query)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := db.querier().QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %v%v`, table, where), b.args...).Scan(&count); err != nil {
+		return 0, db.mapError(ctx, err)
+	}
+	return count, nil
+}
+
+func (db *SQLDB) Insert(ctx context.Context, name string, obj interface{}) error {
+	table, err := db.prepare(ctx, name)
+	if err != nil {
+		return err
+	}
+	doc, err := documents.Encode(obj)
+	if err != nil {
+		return err
+	}
+	return db.mapError(ctx, db.insert(ctx, db.querier(), table, doc))
+}
+
+// Update applies the change to the first document that matches the query, in a transaction
+func (db *SQLDB) Update(ctx context.Context, name string, query domain.Query, change domain.Change, result interface{}) error {
+	table, err := db.prepare(ctx, name)
+	if err != nil {
+		return err
+	}
+	var resultDoc bson.M
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		ids, raws, err := db.selectDocs(ctx, tx, name, table, query, 1, "", true)
+		if err != nil {
+			return err
+		}
+
+		if len(raws) == 0 {
+			if !change.Upsert || change.Remove {
+				return domain.ErrNotFound
+			}
+			doc, err := documents.FromQuery(query)
+			if err != nil {
+				return err
+			}
+			if doc, err = documents.ApplyUpdate(doc, change.Update); err != nil {
+				return err
+			}
+			if err := db.insert(ctx, tx, table, doc); err != nil {
+				return err
+			}
+			if change.ReturnNew {
+				resultDoc = doc
+			}
+			return nil
+		}
+
+		doc := bson.M{}
+		if err := bson.Unmarshal(raws[0], doc); err != nil {
+			return err
+		}
+		resultDoc = doc
+		if change.Remove {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, table, db.options.Dialect.placeholder(1)), ids[0])
+			return err
+		}
+		updated, err := documents.ApplyUpdate(doc, change.Update)
+		if err != nil {
+			return err
+		}
+		if change.ReturnNew {
+			resultDoc = updated
+		}
+		return db.replace(ctx, tx, table, ids[0], updated)
+	})
+	if err != nil {
+		return db.mapError(ctx, err)
+	}
+	if result == nil || resultDoc == nil {
+		return nil
+	}
+	return documents.Decode(resultDoc, result)
+}
+
+// UpdateAll applies the update operators to every document that matches the query, in a transaction
+func (db *SQLDB) UpdateAll(ctx context.Context, name string, query domain.Query, change domain.Query) (int, error) {
+	table, err := db.prepare(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		ids, raws, err := db.selectDocs(ctx, tx, name, table, query, 0, "", true)
+		if err != nil {
+			return err
+		}
+		for i, raw := range raws {
+			doc := bson.M{}
+			if err := bson.Unmarshal(raw, doc); err != nil {
+				return err
+			}
+			if doc, err = documents.ApplyUpdate(doc, change); err != nil {
+				return err
+			}
+			if err := db.replace(ctx, tx, table, ids[i], doc); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, db.mapError(ctx, err)
+	}
+	return updated, nil
+}
+
+func (db *SQLDB) RemoveOne(ctx context.Context, name string, query domain.Query) error {
+	return db.Update(ctx, name, query, domain.Change{Remove: true}, nil)
+}
+
+func (db *SQLDB) RemoveAll(ctx context.Context, name string, query domain.Query) error {
+	table, err := db.prepare(ctx, name)
+	if err != nil {
+		return err
+	}
+	b := newQueryBuilder(db.options.Dialect)
+	where, err := b.where(query)
+	if err != nil {
+		return err
+	}
+	_, err = db.querier().ExecContext(ctx, fmt.Sprintf(`DELETE FROM %v%v`, table, where), b.args...)
+	return db.mapError(ctx, err)
+}
+
+func (db *SQLDB) DropCollection(ctx context.Context, name string) error {
+	if !validCollection.MatchString(name) {
+		return errors.New(fmt.Sprintf("Invalid collection name `%v`", name))
+	}
+	if _, err := db.querier().ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS "%v%v"`, db.options.TablePrefix, name)); err != nil {
+		return db.mapError(ctx, err)
+	}
+	if _, err := db.querier().ExecContext(ctx, fmt.Sprintf(`DELETE FROM %v WHERE collection = %v`,
+		db.ttlTable(), db.options.Dialect.placeholder(1)), name); err != nil {
+		return db.mapError(ctx, err)
+	}
+	db.mutex.Lock()
+	delete(db.tables, name)
+	delete(db.ttls, name)
+	db.mutex.Unlock()
+	return nil
+}
+
+func (db *SQLDB) Exists(ctx context.Context, name string, query domain.Query) bool {
+	var result bson.M
+	err := db.FindOne(ctx, name, query, &result)
+	return (err == nil)
+}
+
+// DropDatabase drops the tables of every collection, only tables with Options.TablePrefix if set
+func (db *SQLDB) DropDatabase(ctx context.Context) error {
+	rows, err := db.querier().QueryContext(ctx, db.options.Dialect.listTables())
+	if err != nil {
+		return db.mapError(ctx, err)
+	}
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return db.mapError(ctx, err)
+		}
+		if strings.HasPrefix(table, db.options.TablePrefix) && validCollection.MatchString(table) {
+			tables = append(tables, table)
+		}
+	}
+	rows.Close()
+	for _, table := range tables {
+		if err := db.DropCollection(ctx, strings.TrimPrefix(table, db.options.TablePrefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureIndex creates an expression index on the JSON fields of the index.
+// SQL databases have no TTL indexes, documents older than Index.ExpireAfter are ignored by reads and removed by writes
+// of the collection. The TTL index is stored in the database, and loaded by Open().
+func (db *SQLDB) EnsureIndex(ctx context.Context, name string, index domain.Index) error {
+	table, err := db.table(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(index.Fields) == 0 {
+		return errors.New("Invalid index, no fields")
+	}
+	d := db.options.Dialect
+	columns := []string{}
+	conditions := []string{}
+	for _, field := range domain.ParseSort(strings.Join(index.Fields, ",")) {
+		if !validField.MatchString(field.Field) {
+			return errors.New(fmt.Sprintf("Invalid index, unsupported field name `%v`", field.Field))
+		}
+		column := d.field(field.Field)
+		if field.Descending {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+		conditions = append(conditions, d.exists(field.Field))
+	}
+	indexName := index.Name
+	if indexName == "" {
+		indexName = strings.Replace(strings.Join(index.Fields, "_"), ".", "_", -1)
+	}
+	indexName = strings.Replace(db.options.TablePrefix+name+"_"+indexName, "-", "", -1)
+	if !validCollection.MatchString(indexName) {
+		return errors.New(fmt.Sprintf("Invalid index name `%v`", indexName))
+	}
+
+	statement := "CREATE INDEX"
+	if index.Unique {
+		statement = "CREATE UNIQUE INDEX"
+	}
+	statement = fmt.Sprintf(`%v IF NOT EXISTS "%v" ON %v (%v)`, statement, indexName, table, strings.Join(columns, ", "))
+	if index.Sparse {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if _, err := db.querier().ExecContext(ctx, statement); err != nil {
+		return db.mapError(ctx, err)
+	}
+
+	if index.ExpireAfter > 0 {
+		field := domain.ParseSort(index.Fields[0])[0].Field
+		d := db.options.Dialect
+		if _, err := db.querier().ExecContext(ctx, fmt.Sprintf(`INSERT INTO %v (collection, field, expire_after) VALUES (%v, %v, %v) `+
+			`ON CONFLICT (collection) DO UPDATE SET field = excluded.field, expire_after = excluded.expire_after`,
+			db.ttlTable(), d.placeholder(1), d.placeholder(2), d.placeholder(3)), name, field, int64(index.ExpireAfter)); err != nil {
+			return db.mapError(ctx, err)
+		}
+		db.mutex.Lock()
+		db.ttls[name] = domain.Index{Fields: []string{field}, ExpireAfter: index.ExpireAfter}
+		db.mutex.Unlock()
+	}
+	return nil
+}
+
+// prepare Returns the table of a collection, after removing the documents expired by its TTL index.
+// Only writes call prepare, reads exclude the expired documents instead, see where().
+func (db *SQLDB) prepare(ctx context.Context, name string) (string, error) {
+	table, err := db.table(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	expired, ok := db.expired(name)
+	if !ok {
+		return table, nil
+	}
+	b := newQueryBuilder(db.options.Dialect)
+	where, err := b.where(expired)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.querier().ExecContext(ctx, fmt.Sprintf(`DELETE FROM %v%v`, table, where), b.args...); err != nil {
+		return "", db.mapError(ctx, err)
+	}
+	return table, nil
+}
+
+// mapError Returns the domain error of database/sql, driver and context errors
+func (db *SQLDB) mapError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && (err == sql.ErrTxDone || db.options.Dialect.isCanceled(err)) {
+		// the statement or the transaction has been interrupted by the context
+		err = ctxErr
+	}
+	switch err {
+	case domain.ErrNotFound, domain.ErrDuplicateKey, domain.ErrConflict, domain.ErrTimeout, context.Canceled:
+		return err
+	case sql.ErrNoRows:
+		return domain.ErrNotFound
+	case context.DeadlineExceeded:
+		return domain.ErrTimeout
+	}
+	if db.options.Dialect.isDuplicateKey(err) {
+		return domain.ErrDuplicateKey
+	}
+	if db.options.Dialect.isConflict(err) {
+		return domain.ErrConflict
+	}
+	if db.options.Dialect.isTimeout(err) {
+		return domain.ErrTimeout
+	}
+	return err
+}
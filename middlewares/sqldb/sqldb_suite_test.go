@@ -0,0 +1,12 @@
+package sqldb_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSQLDB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SQLDB Suite")
+}
@@ -0,0 +1,254 @@
+package sqldb_test
+
+import (
+	"context"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/sqldb"
+	"github.com/sogko/slumber/test_helpers"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("SQLDB", func() {
+
+	var dir string
+	var db *sqldb.SQLDB
+	ctx := context.Background()
+
+	findNames := func(query domain.Query, sort string) []string {
+		return test_helpers.FindUserNames(ctx, db, query, sort)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sqldb")
+		Expect(err).To(BeNil())
+		db = sqldb.New(&sqldb.Options{
+			DriverName:     "sqlite3",
+			DataSourceName: filepath.Join(dir, "test.sqlite"),
+		})
+		Expect(db.Open()).To(BeNil())
+		test_helpers.InsertDatabaseUsers(ctx, db)
+	})
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("FindAll()", func() {
+		It("should match equal values and array elements", func() {
+			Expect(findNames(domain.Query{"name": "bob"}, "")).To(Equal([]string{"bob"}))
+			Expect(findNames(domain.Query{"age": 25}, "name")).To(Equal([]string{"bob", "dave"}))
+			Expect(findNames(domain.Query{"tags": "staff"}, "name")).To(Equal([]string{"alice", "bob"}))
+			Expect(findNames(domain.Query{"name": "nobody"}, "")).To(BeEmpty())
+		})
+		It("should match `$in` and `$nin` lists", func() {
+			Expect(findNames(domain.Query{"name": domain.Query{"$in": []string{"alice", "carol", "nobody"}}}, "name")).To(Equal([]string{"alice", "carol"}))
+			Expect(findNames(domain.Query{"age": domain.Query{"$nin": []int{25, 30}}}, "name")).To(Equal([]string{"carol"}))
+		})
+		It("should compare values with `$gt`, `$gte`, `$lt` and `$lte`", func() {
+			Expect(findNames(domain.Query{"age": domain.Query{"$gt": 25}}, "name")).To(Equal([]string{"alice", "carol"}))
+			Expect(findNames(domain.Query{"age": domain.Query{"$gte": 25, "$lt": 35}}, "name")).To(Equal([]string{"alice", "bob", "dave"}))
+			Expect(findNames(domain.Query{"age": domain.Query{"$lte": 25}}, "name")).To(Equal([]string{"bob", "dave"}))
+			Expect(findNames(domain.Query{"name": domain.Query{"$gt": "bob"}}, "name")).To(Equal([]string{"carol", "dave"}))
+		})
+		It("should only compare values of the same type", func() {
+			for _, value := range []interface{}{5, 10.5, "abc", true, nil} {
+				Expect(db.Insert(ctx, "values", bson.M{"value": value})).To(BeNil())
+			}
+			findValues := func(query domain.Query) []interface{} {
+				var documents []bson.M
+				Expect(db.FindAll(ctx, "values", query, &documents, 0, "value")).To(BeNil())
+				values := []interface{}{}
+				for _, document := range documents {
+					values = append(values, document["value"])
+				}
+				return values
+			}
+			Expect(findValues(domain.Query{"value": domain.Query{"$gt": 5}})).To(Equal([]interface{}{10.5}))
+			Expect(findValues(domain.Query{"value": domain.Query{"$lt": 100}})).To(Equal([]interface{}{5, 10.5}))
+			Expect(findValues(domain.Query{"value": domain.Query{"$gte": "a"}})).To(Equal([]interface{}{"abc"}))
+			Expect(findValues(domain.Query{"value": domain.Query{"$gt": false}})).To(Equal([]interface{}{true}))
+			Expect(findValues(domain.Query{"value": domain.Query{"$gte": nil}})).To(BeEmpty())
+		})
+		It("should combine queries with `$and` and `$or`", func() {
+			Expect(findNames(domain.Query{"$or": []domain.Query{
+				{"name": "alice"},
+				{"age": domain.Query{"$gt": 30}},
+			}}, "name")).To(Equal([]string{"alice", "carol"}))
+			Expect(findNames(domain.Query{"$and": []domain.Query{
+				{"age": 25},
+				{"$or": []domain.Query{{"tags": "staff"}, {"name": "carol"}}},
+			}}, "name")).To(Equal([]string{"bob"}))
+		})
+		It("should match missing fields with `$exists`", func() {
+			Expect(findNames(domain.Query{"tags": domain.Query{"$exists": false}}, "name")).To(Equal([]string{"carol", "dave"}))
+		})
+		It("should sort and limit documents", func() {
+			Expect(findNames(nil, "-age,name")).To(Equal([]string{"carol", "alice", "bob", "dave"}))
+			var users []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "users", nil, &users, 2, "age,-name")).To(BeNil())
+			Expect(test_helpers.UserNames(users)).To(Equal([]string{"dave", "bob"}))
+		})
+		It("should return error for unsupported operators and field names", func() {
+			var users []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "users", domain.Query{"age": domain.Query{"$where": "1"}}, &users, 0, "")).ToNot(BeNil())
+			Expect(db.FindAll(ctx, "users", domain.Query{"name') OR 1=1 --": "alice"}, &users, 0, "")).ToNot(BeNil())
+		})
+	})
+
+	Describe("FindOne() and Count()", func() {
+		It("should find the first document that matches the query", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.FindOne(ctx, "users", domain.Query{"name": "carol"}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(35))
+			Expect(user.ID.Valid()).To(BeTrue())
+			Expect(db.FindOne(ctx, "users", domain.Query{"name": "nobody"}, &user)).To(Equal(domain.ErrNotFound))
+		})
+		It("should count the documents that match the query", func() {
+			count, err := db.Count(ctx, "users", domain.Query{"age": 25})
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(2))
+		})
+	})
+
+	Describe("Update()", func() {
+		It("should update the first document and return its previous version", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"age": 26}},
+			}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(25))
+			Expect(findNames(domain.Query{"age": 26}, "")).To(Equal([]string{"bob"}))
+		})
+		It("should return the new version with ReturnNew", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update:    domain.Query{"$inc": domain.Query{"age": 2}},
+				ReturnNew: true,
+			}, &user)).To(BeNil())
+			Expect(user.Age).To(Equal(27))
+		})
+		It("should insert a document with Upsert if none matches", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.Update(ctx, "users", domain.Query{"name": "erin"}, domain.Change{
+				Update:    domain.Query{"$set": domain.Query{"age": 40}},
+				Upsert:    true,
+				ReturnNew: true,
+			}, &user)).To(BeNil())
+			Expect(user.Name).To(Equal("erin"))
+			Expect(user.Age).To(Equal(40))
+			Expect(findNames(domain.Query{"age": 40}, "")).To(Equal([]string{"erin"}))
+		})
+		It("should remove the document with Remove", func() {
+			var user test_helpers.DatabaseUser
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{Remove: true}, &user)).To(BeNil())
+			Expect(user.Name).To(Equal("bob"))
+			Expect(findNames(nil, "name")).To(Equal([]string{"alice", "carol", "dave"}))
+		})
+		It("should return ErrNotFound if no document matches", func() {
+			Expect(db.Update(ctx, "users", domain.Query{"name": "nobody"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"age": 1}},
+			}, nil)).To(Equal(domain.ErrNotFound))
+			Expect(db.RemoveOne(ctx, "users", domain.Query{"name": "nobody"})).To(Equal(domain.ErrNotFound))
+		})
+	})
+
+	Describe("UpdateAll() and RemoveAll()", func() {
+		It("should update every document that matches the query", func() {
+			updated, err := db.UpdateAll(ctx, "users", domain.Query{"age": 25}, domain.Query{"$set": domain.Query{"age": 26}})
+			Expect(err).To(BeNil())
+			Expect(updated).To(Equal(2))
+			Expect(findNames(domain.Query{"age": 26}, "name")).To(Equal([]string{"bob", "dave"}))
+		})
+		It("should remove every document that matches the query", func() {
+			Expect(db.RemoveAll(ctx, "users", domain.Query{"age": 25})).To(BeNil())
+			Expect(findNames(nil, "name")).To(Equal([]string{"alice", "carol"}))
+		})
+	})
+
+	Describe("EnsureIndex()", func() {
+		It("should enforce unique indexes", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"name"}, Unique: true})).To(BeNil())
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "alice"})).To(Equal(domain.ErrDuplicateKey))
+			Expect(db.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+				Update: domain.Query{"$set": domain.Query{"name": "alice"}},
+			}, nil)).To(Equal(domain.ErrDuplicateKey))
+			Expect(db.Insert(ctx, "users", &test_helpers.DatabaseUser{Name: "erin"})).To(BeNil())
+		})
+		It("should return error for unique indexes on duplicate values", func() {
+			Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"age"}, Unique: true})).To(Equal(domain.ErrDuplicateKey))
+		})
+		It("should ignore documents expired by a TTL index on reads and remove them on writes", func() {
+			Expect(db.EnsureIndex(ctx, "sessions", domain.Index{Fields: []string{"createdAt"}, ExpireAfter: time.Hour})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "active", CreatedAt: time.Now()})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "forever"})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "expired", CreatedAt: time.Now().Add(-2 * time.Hour)})).To(BeNil())
+			rows := func() int {
+				var count int
+				Expect(db.DB().QueryRow(`SELECT COUNT(*) FROM "sessions"`).Scan(&count)).To(BeNil())
+				return count
+			}
+
+			var sessions []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "name")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"active", "forever"}))
+			Expect(db.Count(ctx, "sessions", domain.Query{"name": "expired"})).To(Equal(0))
+			Expect(db.Exists(ctx, "sessions", domain.Query{"name": "expired"})).To(BeFalse())
+			Expect(rows()).To(Equal(3))
+
+			Expect(db.RemoveOne(ctx, "sessions", domain.Query{"name": "active"})).To(BeNil())
+			Expect(rows()).To(Equal(1))
+		})
+		It("should keep TTL indexes after the database is reopened", func() {
+			Expect(db.EnsureIndex(ctx, "sessions", domain.Index{Fields: []string{"createdAt"}, ExpireAfter: time.Hour})).To(BeNil())
+			Expect(db.Close()).To(BeNil())
+			db = sqldb.New(&sqldb.Options{
+				DriverName:     "sqlite3",
+				DataSourceName: filepath.Join(dir, "test.sqlite"),
+			})
+			Expect(db.Open()).To(BeNil())
+
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "expired", CreatedAt: time.Now().Add(-2 * time.Hour)})).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "active", CreatedAt: time.Now()})).To(BeNil())
+			var sessions []test_helpers.DatabaseUser
+			Expect(db.FindAll(ctx, "sessions", nil, &sessions, 0, "")).To(BeNil())
+			Expect(test_helpers.UserNames(sessions)).To(Equal([]string{"active"}))
+		})
+		It("should remove the TTL index of dropped collections", func() {
+			Expect(db.EnsureIndex(ctx, "sessions", domain.Index{Fields: []string{"createdAt"}, ExpireAfter: time.Hour})).To(BeNil())
+			Expect(db.DropDatabase(ctx)).To(BeNil())
+			Expect(db.Insert(ctx, "sessions", &test_helpers.DatabaseUser{Name: "old", CreatedAt: time.Now().Add(-2 * time.Hour)})).To(BeNil())
+			Expect(db.Count(ctx, "sessions", nil)).To(Equal(1))
+		})
+	})
+
+	Describe("Context", func() {
+		It("should return the error of canceled contexts", func() {
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			var users []test_helpers.DatabaseUser
+			Expect(db.FindAll(canceled, "users", nil, &users, 0, "")).To(Equal(context.Canceled))
+		})
+	})
+})
+
+var _ = test_helpers.DescribeDatabaseConformance("SQLDB", func() (domain.IDatabase, func()) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	Expect(err).To(BeNil())
+	db := sqldb.New(&sqldb.Options{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(dir, "test.sqlite"),
+	})
+	Expect(db.Open()).To(BeNil())
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+})
@@ -1,59 +1,266 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	//	"github.com/sogko/slumber/controllers"
 	"github.com/sogko/slumber/domain"
+	"log"
 	"net/http"
+	"sort"
+	"time"
 )
 
 const defaultForbiddenAccessMessage = "Forbidden (403)"
 const defaultOKAccessMessage = "OK"
+const defaultUnauthorizedAccessMessage = "Unauthorized (401)"
+const defaultPolicyForbiddenAccessMessage = "Forbidden (403), not allowed by policy"
+
+// DefaultAuthenticationScheme is the WWW-Authenticate challenge scheme of requests that requires authentication
+const DefaultAuthenticationScheme = "Bearer"
 
 type ErrorResponse struct {
 	Message string `json:"message,omitempty"`
 	Success bool   `json:"success"`
 }
 
-// TODO: Currently, AccessController only acts as a gateway for endpoints on router level. Build AC to handler other aspects of ACL
+// NewAccessController Returns a new AccessController object.
+// AccessController acts as a gateway for endpoints on router level (see NewContextHandler), and authorizes
+// actions on resources loaded by handlers (see Authorize and FilterFields).
 func NewAccessController(ctx domain.IContext, renderer domain.IRenderer) *AccessController {
-	return &AccessController{domain.ACLMap{}, ctx, renderer}
+	return &AccessController{domain.ACLMap{}, ctx, renderer, nil, nil, map[string]domain.ResourceACLHandlerFunc{}, map[string]map[string]domain.ResourceACLHandlerFunc{}, nil, map[string]domain.ACLDecisionHandlerFunc{}, false, DefaultAuthenticationScheme, ""}
 }
 
 // implements IAccessController
 type AccessController struct {
-	ACLMap   domain.ACLMap
-	ctx      domain.IContext
-	renderer domain.IRenderer
+	ACLMap    domain.ACLMap
+	ctx       domain.IContext
+	renderer  domain.IRenderer
+	rbac      *RBACPolicy
+	policy    *PolicyEngine
+	resource  map[string]domain.ResourceACLHandlerFunc
+	fields    map[string]map[string]domain.ResourceACLHandlerFunc
+	audit     domain.IAuditSink
+	decisions map[string]domain.ACLDecisionHandlerFunc
+
+	// TrustProxyHeaders uses X-Forwarded-For / X-Real-IP headers for the client IP of audit entries
+	TrustProxyHeaders bool
+
+	// AuthenticationScheme and AuthenticationRealm are the default WWW-Authenticate challenge of
+	// requests that requires authentication
+	AuthenticationScheme string
+	AuthenticationRealm  string
 }
 
 func (ac *AccessController) Add(aclMap *domain.ACLMap) {
 	ac.ACLMap = ac.ACLMap.Append(aclMap)
+	for action := range *aclMap {
+		delete(ac.decisions, action)
+	}
 }
 
-func (ac *AccessController) AddHandler(action string, handler domain.ACLHandlerFunc) {
+// AddHandler registers the ACL handler for an action.
+// Returns an error if handler is nil or if the action already has a handler,
+// use Add() to explicitly replace existing handlers.
+func (ac *AccessController) AddHandler(action string, handler domain.ACLHandlerFunc) error {
+	if handler == nil {
+		return errors.New(fmt.Sprintf("ACL handler for `%v` is nil", action))
+	}
+	if ac.HasAction(action) {
+		return errors.New(fmt.Sprintf("ACL handler for `%v` already exists", action))
+	}
 	ac.ACLMap[action] = handler
+	return nil
+}
+
+// AddDecisionHandler registers the ACL decision handler for an action, used instead of an ACLHandlerFunc
+// to return richer decisions (see domain.ACLDecision).
+// Returns an error if handler is nil or if the action already has a handler.
+func (ac *AccessController) AddDecisionHandler(action string, handler domain.ACLDecisionHandlerFunc) error {
+	if handler == nil {
+		return errors.New(fmt.Sprintf("ACL handler for `%v` is nil", action))
+	}
+	if ac.HasAction(action) {
+		return errors.New(fmt.Sprintf("ACL handler for `%v` already exists", action))
+	}
+	ac.decisions[action] = handler
+	return nil
 }
 
 func (ac *AccessController) HasAction(action string) bool {
 	fn := ac.ACLMap[action]
-	return (fn != nil)
+	return (fn != nil) || (ac.decisions[action] != nil)
+}
+
+// Actions Returns the sorted names of actions that has an ACL handler or an ACL decision handler
+func (ac *AccessController) Actions() []string {
+	actions := []string{}
+	for action := range ac.ACLMap {
+		if ac.HasAction(action) {
+			actions = append(actions, action)
+		}
+	}
+	for action := range ac.decisions {
+		if ac.ACLMap[action] == nil && ac.HasAction(action) {
+			actions = append(actions, action)
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// UseRBACPolicy sets the RBAC policy checked in addition to ACL handlers.
+// A request for an action declared in the policy is only authorized if both the policy and
+// the ACL handler of the action authorizes it.
+func (ac *AccessController) UseRBACPolicy(policy *RBACPolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	ac.rbac = policy
+	return nil
+}
+
+// LoadRBACPolicyFile loads the RBAC policy from a JSON file, see LoadRBACPolicy and UseRBACPolicy
+func (ac *AccessController) LoadRBACPolicyFile(filename string) error {
+	policy, err := LoadRBACPolicyFile(filename)
+	if err != nil {
+		return err
+	}
+	return ac.UseRBACPolicy(policy)
+}
+
+// RBACPolicy Returns the RBAC policy in use, if any
+func (ac *AccessController) RBACPolicy() *RBACPolicy {
+	return ac.rbac
+}
+
+// UsePolicyEngine sets the attribute-based policy engine checked in addition to ACL handlers and the RBAC policy.
+// Decisions are combined with deny-overrides: a request is denied if any of the ACL handler, the RBAC policy or
+// the policy engine denies it.
+func (ac *AccessController) UsePolicyEngine(engine *PolicyEngine) {
+	ac.policy = engine
+}
+
+// LoadPolicyFile loads the policy engine rules from a file, see LoadPolicyEngine and UsePolicyEngine
+func (ac *AccessController) LoadPolicyFile(filename string) error {
+	engine, err := LoadPolicyEngineFile(filename)
+	if err != nil {
+		return err
+	}
+	ac.UsePolicyEngine(engine)
+	return nil
+}
+
+// PolicyEngine Returns the policy engine in use, if any
+func (ac *AccessController) PolicyEngine() *PolicyEngine {
+	return ac.policy
+}
+
+// UseAuditSink sets the sink that records every authorization decision made by the router gateway
+// (see NewContextHandler) and by Authorize. Errors writing to the sink are logged, and do not affect the decision.
+func (ac *AccessController) UseAuditSink(sink domain.IAuditSink) {
+	ac.audit = sink
+}
+
+// auditDecision writes the authorization decision to the audit sink, if any
+func (ac *AccessController) auditDecision(req *http.Request, action string, user domain.IUser, result bool, message string) {
+	if ac.audit == nil {
+		return
+	}
+	entry := &domain.AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Allowed:   result,
+		Message:   message,
+	}
+	if user != nil {
+		entry.UserID = user.GetID()
+	}
+	if req != nil {
+		entry.Method = req.Method
+		entry.Path = req.URL.Path
+		entry.ClientIP = domain.ClientIP(req, ac.TrustProxyHeaders)
+		entry.RequestID = req.Header.Get(domain.RequestIDHeader)
+	}
+	if err := ac.audit.Write(entry); err != nil {
+		log.Printf("Error writing audit entry for `%v`: %v", action, err.Error())
+	}
 }
 
 func (ac *AccessController) IsHTTPRequestAuthorized(req *http.Request, ctx domain.IContext, action string, user domain.IUser) (bool, string) {
+	decision := ac.DecideHTTPRequest(req, ctx, action, user)
+	return decision.Effect == domain.ACLAllow, decision.Message
+}
+
+// DecideHTTPRequest Returns the ACL decision for a request.
+// A request denied by the RBAC policy, the policy engine or an ACL handler, or not allowed by the policy engine
+// for an action that allow rules apply to, requires authentication if there is no user, so that anonymous
+// clients get a 401 with a WWW-Authenticate challenge; ACL decision handlers decide by themselves.
+func (ac *AccessController) DecideHTTPRequest(req *http.Request, ctx domain.IContext, action string, user domain.IUser) domain.ACLDecision {
 	fn := ac.ACLMap[action]
-	if fn == nil {
+	decisionFn := ac.decisions[action]
+	if fn == nil && decisionFn == nil {
 		// by default, if acl action/handler is not defined, request is not authorized
-		return false, defaultForbiddenAccessMessage
+		return domain.ACLDecision{Effect: domain.ACLDeny, Message: defaultForbiddenAccessMessage}
 	}
 
-	result, message := fn(req, user)
-	if result && message == "" {
-		message = defaultOKAccessMessage
+	decision := domain.ACLDecision{Effect: domain.ACLAllow}
+	if ac.rbac != nil && ac.rbac.HasAction(action) {
+		decision = domain.NewACLDecision(ac.rbac.IsActionAuthorized(action, user))
 	}
-	if !result && message == "" {
-		message = defaultForbiddenAccessMessage
+	if decision.Effect == domain.ACLAllow && ac.policy != nil {
+		if ctx == nil {
+			ctx = ac.ctx
+		}
+		policyDecision := ac.policy.Evaluate(req, ctx, action, user)
+		if policyDecision.Effect == PolicyDeny {
+			decision = domain.ACLDecision{Effect: domain.ACLDeny, Message: policyDecision.Message}
+		} else if policyDecision.Effect != PolicyAllow && ac.policy.RequiresAllow(action) {
+			decision = domain.ACLDecision{Effect: domain.ACLDeny, Message: defaultPolicyForbiddenAccessMessage}
+		}
 	}
-	return result, message
+	if decision.Effect == domain.ACLDeny && user == nil {
+		decision = domain.ACLDecision{Effect: domain.ACLRequireAuthentication}
+	}
+	if decision.Effect == domain.ACLAllow {
+		if decisionFn != nil {
+			decision = decisionFn(req, user)
+		} else {
+			decision = domain.RequireAuthentication(fn)(req, user)
+		}
+	}
+	if decision.Message == "" {
+		switch decision.Effect {
+		case domain.ACLAllow:
+			decision.Message = defaultOKAccessMessage
+		case domain.ACLRequireAuthentication:
+			decision.Message = defaultUnauthorizedAccessMessage
+		default:
+			decision.Message = defaultForbiddenAccessMessage
+		}
+	}
+	return decision
+}
+
+// challenge Returns the WWW-Authenticate header value of a decision that requires authentication
+func (ac *AccessController) challenge(decision domain.ACLDecision) string {
+	scheme, realm := decision.Scheme, decision.Realm
+	if scheme == "" {
+		scheme = ac.AuthenticationScheme
+	}
+	if scheme == "" {
+		scheme = DefaultAuthenticationScheme
+	}
+	if realm == "" {
+		realm = ac.AuthenticationRealm
+	}
+	if realm == "" {
+		return scheme
+	}
+	return fmt.Sprintf("%v realm=%q", scheme, realm)
 }
 
 func (ac *AccessController) NewContextHandler(action string, next http.HandlerFunc) http.HandlerFunc {
@@ -63,10 +270,20 @@ func (ac *AccessController) NewContextHandler(action string, next http.HandlerFu
 		// `user` might be `nil` if has not authenticated.
 		// ACL might want to allow anonymous / non-authenticated access (for login, e.g)
 
-		result, message := ac.IsHTTPRequestAuthorized(req, ac.ctx, action, user)
-		if !result {
+		decision := ac.DecideHTTPRequest(req, ac.ctx, action, user)
+		ac.auditDecision(req, action, user, decision.Effect == domain.ACLAllow, decision.Message)
+		switch decision.Effect {
+		case domain.ACLAllow:
+		case domain.ACLRequireAuthentication:
+			w.Header().Set("WWW-Authenticate", ac.challenge(decision))
+			ac.renderer.Render(w, req, http.StatusUnauthorized, ErrorResponse{
+				Message: decision.Message,
+				Success: false,
+			})
+			return
+		default:
 			ac.renderer.Render(w, req, http.StatusForbidden, ErrorResponse{
-				Message: message,
+				Message: decision.Message,
 				Success: false,
 			})
 			return
@@ -75,3 +292,142 @@ func (ac *AccessController) NewContextHandler(action string, next http.HandlerFu
 		next(w, req)
 	}
 }
+
+// AddResourceHandler registers the handler that authorizes an action on a resource, see Authorize.
+// Returns an error if handler is nil or if the action already has a resource handler.
+func (ac *AccessController) AddResourceHandler(action string, handler domain.ResourceACLHandlerFunc) error {
+	if handler == nil {
+		return errors.New(fmt.Sprintf("Resource ACL handler for `%v` is nil", action))
+	}
+	if _, ok := ac.resource[action]; ok {
+		return errors.New(fmt.Sprintf("Resource ACL handler for `%v` already exists", action))
+	}
+	ac.resource[action] = handler
+	return nil
+}
+
+// Authorize Returns true if the user can perform the action on a resource, for eg:
+//
+//	user := loadUser(mux.Vars(req)["id"])
+//	if ok, message := ac.Authorize(req, "users:update", currentUser, user); !ok {
+//	  ...render 403 with message
+//	}
+//
+// The resource handler of the action, the RBAC policy and the policy engine (with the resource available as
+// `resource.document`) are combined with deny-overrides; an action that allow rules of the policy engine
+// apply to also requires a matching allow rule.
+// By default, if none of them declares the action, the user is not authorized.
+func (ac *AccessController) Authorize(req *http.Request, action string, user domain.IUser, resource interface{}) (bool, string) {
+	declared := false
+	result, message := true, ""
+	if ac.rbac != nil && ac.rbac.HasAction(action) {
+		declared = true
+		result, message = ac.rbac.IsActionAuthorized(action, user)
+	}
+	if result && ac.policy != nil && ac.policy.HasAction(action) {
+		declared = true
+		decision := ac.policy.EvaluateResource(req, action, user, resource)
+		if decision.Effect == PolicyDeny {
+			result, message = false, decision.Message
+		} else if decision.Effect != PolicyAllow && ac.policy.RequiresAllow(action) {
+			result, message = false, defaultPolicyForbiddenAccessMessage
+		}
+	}
+	if fn, ok := ac.resource[action]; ok {
+		declared = true
+		if result {
+			result, message = fn(req, user, resource)
+		}
+	}
+	if !declared {
+		result, message = false, ""
+	}
+	if result && message == "" {
+		message = defaultOKAccessMessage
+	}
+	if !result && message == "" {
+		message = defaultForbiddenAccessMessage
+	}
+	ac.auditDecision(req, action, user, result, message)
+	return result, message
+}
+
+// AddFieldHandler registers the handler that decides if a field of a resource is visible to the user
+// for an action, see FilterFields.
+// Returns an error if handler is nil or if the field already has a handler for the action.
+func (ac *AccessController) AddFieldHandler(action string, field string, handler domain.ResourceACLHandlerFunc) error {
+	if handler == nil {
+		return errors.New(fmt.Sprintf("Field ACL handler for `%v.%v` is nil", action, field))
+	}
+	if ac.fields[action] == nil {
+		ac.fields[action] = map[string]domain.ResourceACLHandlerFunc{}
+	}
+	if _, ok := ac.fields[action][field]; ok {
+		return errors.New(fmt.Sprintf("Field ACL handler for `%v.%v` already exists", action, field))
+	}
+	ac.fields[action][field] = handler
+	return nil
+}
+
+// FilterFields Returns the JSON representation of a resource, or a list of resources, without the fields
+// the user is not allowed to see for the action.
+// Fields without a handler are visible; the resource is returned as-is if the action has no field handlers.
+// Returns nil, or nil items of a list, if the action has field handlers and the resource is not a JSON object.
+func (ac *AccessController) FilterFields(req *http.Request, action string, user domain.IUser, resource interface{}) interface{} {
+	handlers := ac.fields[action]
+	if len(handlers) == 0 || resource == nil {
+		return resource
+	}
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return ac.filterObjectFields(req, handlers, user, v)
+	case []interface{}:
+		for i, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				v[i] = ac.filterObjectFields(req, handlers, user, object)
+			} else {
+				v[i] = nil
+			}
+		}
+		return v
+	}
+	return nil
+}
+
+func (ac *AccessController) filterObjectFields(req *http.Request, handlers map[string]domain.ResourceACLHandlerFunc, user domain.IUser, object map[string]interface{}) map[string]interface{} {
+	hidden := []string{}
+	for field, handler := range handlers {
+		if _, ok := object[field]; !ok {
+			continue
+		}
+		if visible, _ := handler(req, user, object); !visible {
+			hidden = append(hidden, field)
+		}
+	}
+	for _, field := range hidden {
+		delete(object, field)
+	}
+	return object
+}
+
+// ResourceOwner Returns a ResourceACLHandlerFunc that authorizes the user if the field of the resource is the user's ID.
+// The field is the name of the field in the JSON representation of the resource.
+func ResourceOwner(field string) domain.ResourceACLHandlerFunc {
+	return func(req *http.Request, user domain.IUser, resource interface{}) (bool, string) {
+		if user == nil {
+			return false, defaultForbiddenAccessMessage
+		}
+		if owner, ok := policyObject(resource)[field].(string); ok && owner == user.GetID() {
+			return true, ""
+		}
+		return false, "Forbidden (403), only the owner can access this resource"
+	}
+}
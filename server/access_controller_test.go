@@ -13,6 +13,16 @@ import (
 	"net/http/httptest"
 )
 
+// testAuditSink implements IAuditSink
+type testAuditSink struct {
+	entries []*domain.AuditEntry
+}
+
+func (sink *testAuditSink) Write(entry *domain.AuditEntry) error {
+	sink.entries = append(sink.entries, entry)
+	return nil
+}
+
 func SetCurrentObjectCtx(ctx domain.IContext, req *http.Request, user *users.User) {
 	ctx.Set(req, "TESTCURRENTOBJECT", user)
 }
@@ -122,6 +132,24 @@ var _ = Describe("AccessController", func() {
 		})
 	})
 
+	Describe("AddHandler()", func() {
+		stub := func(req *http.Request, user domain.IUser) (bool, string) {
+			return true, ""
+		}
+		It("should add handler", func() {
+			Expect(ac.AddHandler("ListAdmins", stub)).To(BeNil())
+			Expect(ac.HasAction("ListAdmins")).To(BeTrue())
+		})
+		It("should return error if handler is nil", func() {
+			Expect(ac.AddHandler("ListAdmins", nil)).ToNot(BeNil())
+			Expect(ac.HasAction("ListAdmins")).To(BeFalse())
+		})
+		It("should return error if action already has a handler", func() {
+			ac.Add(&aclMap)
+			Expect(ac.AddHandler("ListUsers", stub)).ToNot(BeNil())
+		})
+	})
+
 	Describe("HasAction()", func() {
 		BeforeEach(func() {
 			ac.Add(&aclMap)
@@ -169,6 +197,247 @@ var _ = Describe("AccessController", func() {
 		})
 	})
 
+	Describe("Authorize()", func() {
+		type TestPost struct {
+			ID     string `json:"id"`
+			Author string `json:"author"`
+		}
+		author := &rbacTestUser{"author", []string{"user"}}
+		other := &rbacTestUser{"other", []string{"user"}}
+		post := &TestPost{"post", "author"}
+
+		It("should authorize using the resource handler", func() {
+			Expect(ac.AddResourceHandler("posts:update", server.ResourceOwner("author"))).To(BeNil())
+			result, _ := ac.Authorize(request, "posts:update", author, post)
+			Expect(result).To(BeTrue())
+			result, message := ac.Authorize(request, "posts:update", other, post)
+			Expect(result).To(BeFalse())
+			Expect(message).To(ContainSubstring("owner"))
+			result, _ = ac.Authorize(request, "posts:update", nil, post)
+			Expect(result).To(BeFalse())
+		})
+		It("should not authorize undeclared actions", func() {
+			result, message := ac.Authorize(request, "posts:update", author, post)
+			Expect(result).To(BeFalse())
+			Expect(message).To(Equal("Forbidden (403)"))
+		})
+		It("should return error for nil or existing resource handlers", func() {
+			Expect(ac.AddResourceHandler("posts:update", nil)).ToNot(BeNil())
+			Expect(ac.AddResourceHandler("posts:update", server.ResourceOwner("author"))).To(BeNil())
+			Expect(ac.AddResourceHandler("posts:update", server.ResourceOwner("author"))).ToNot(BeNil())
+		})
+		It("should combine the resource handler with the RBAC policy and the policy engine", func() {
+			ac.AddResourceHandler("posts:update", func(req *http.Request, user domain.IUser, resource interface{}) (bool, string) {
+				return true, ""
+			})
+			ac.UseRBACPolicy(server.NewRBACPolicy().AddRole("user", nil, "posts:update").Require("posts:update", "posts:update"))
+			engine := server.NewPolicyEngine()
+			engine.Deny("author-only", []string{"posts:update"}, `resource.document.author != subject.id`)
+			ac.UsePolicyEngine(engine)
+
+			result, _ := ac.Authorize(request, "posts:update", author, post)
+			Expect(result).To(BeTrue())
+			result, message := ac.Authorize(request, "posts:update", other, post)
+			Expect(result).To(BeFalse())
+			Expect(message).To(ContainSubstring("author-only"))
+			result, _ = ac.Authorize(request, "posts:update", &rbacTestUser{"author", nil}, post)
+			Expect(result).To(BeFalse())
+		})
+		It("should authorize actions only declared in the policy engine", func() {
+			engine := server.NewPolicyEngine()
+			engine.Deny("author-only", []string{"posts:delete"}, `resource.document.author != subject.id`)
+			ac.UsePolicyEngine(engine)
+			result, _ := ac.Authorize(request, "posts:delete", author, post)
+			Expect(result).To(BeTrue())
+			result, _ = ac.Authorize(request, "posts:delete", other, post)
+			Expect(result).To(BeFalse())
+		})
+		It("should require a matching allow rule for actions that allow rules apply to", func() {
+			admin := &rbacTestUser{"admin", []string{"admin"}}
+			engine := server.NewPolicyEngine()
+			engine.Allow("admins", []string{"posts:delete"}, `has_role("admin")`)
+			ac.UsePolicyEngine(engine)
+			result, _ := ac.Authorize(request, "posts:delete", admin, post)
+			Expect(result).To(BeTrue())
+			result, message := ac.Authorize(request, "posts:delete", author, post)
+			Expect(result).To(BeFalse())
+			Expect(message).To(ContainSubstring("not allowed by policy"))
+			result, _ = ac.Authorize(request, "posts:delete", nil, post)
+			Expect(result).To(BeFalse())
+		})
+	})
+
+	Describe("FilterFields()", func() {
+		type TestProfile struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+			Name  string `json:"name"`
+		}
+		owner := &rbacTestUser{"owner", []string{"user"}}
+		other := &rbacTestUser{"other", []string{"user"}}
+		profile := TestProfile{"owner", "owner@example.com", "Owner"}
+
+		BeforeEach(func() {
+			Expect(ac.AddFieldHandler("profiles:read", "email", server.ResourceOwner("id"))).To(BeNil())
+		})
+		It("should strip fields the user cannot see", func() {
+			Expect(ac.FilterFields(request, "profiles:read", owner, profile)).To(Equal(map[string]interface{}{
+				"id":    "owner",
+				"email": "owner@example.com",
+				"name":  "Owner",
+			}))
+			Expect(ac.FilterFields(request, "profiles:read", other, profile)).To(Equal(map[string]interface{}{
+				"id":   "owner",
+				"name": "Owner",
+			}))
+		})
+		It("should filter each resource of a list", func() {
+			filtered := ac.FilterFields(request, "profiles:read", other, []TestProfile{profile, TestProfile{"other", "other@example.com", "Other"}})
+			Expect(filtered).To(Equal([]interface{}{
+				map[string]interface{}{"id": "owner", "name": "Owner"},
+				map[string]interface{}{"id": "other", "email": "other@example.com", "name": "Other"},
+			}))
+		})
+		It("should not return resources that can not be filtered", func() {
+			Expect(ac.FilterFields(request, "profiles:read", other, make(chan int))).To(BeNil())
+			Expect(ac.FilterFields(request, "profiles:read", other, "owner@example.com")).To(BeNil())
+			Expect(ac.FilterFields(request, "profiles:read", other, []interface{}{profile, "owner@example.com"})).To(Equal([]interface{}{
+				map[string]interface{}{"id": "owner", "name": "Owner"},
+				nil,
+			}))
+		})
+		It("should return the resource as-is for actions without field handlers", func() {
+			Expect(ac.FilterFields(request, "profiles:list", other, profile)).To(Equal(profile))
+		})
+		It("should return error for nil or existing field handlers", func() {
+			Expect(ac.AddFieldHandler("profiles:read", "name", nil)).ToNot(BeNil())
+			Expect(ac.AddFieldHandler("profiles:read", "email", server.ResourceOwner("id"))).ToNot(BeNil())
+		})
+	})
+
+	Describe("DecideHTTPRequest()", func() {
+		requireUser := domain.RequireAuthentication(func(req *http.Request, user domain.IUser) (bool, string) {
+			return user != nil, ""
+		})
+		BeforeEach(func() {
+			Expect(ac.AddDecisionHandler("GetProfile", requireUser)).To(BeNil())
+			ac.Add(&aclMap)
+		})
+		It("should use ACL decision handlers", func() {
+			Expect(ac.HasAction("GetProfile")).To(BeTrue())
+			decision := ac.DecideHTTPRequest(request, ctx, "GetProfile", nil)
+			Expect(decision.Effect).To(Equal(domain.ACLRequireAuthentication))
+			Expect(decision.Message).To(Equal("Unauthorized (401)"))
+			decision = ac.DecideHTTPRequest(request, ctx, "GetProfile", normalUser)
+			Expect(decision.Effect).To(Equal(domain.ACLAllow))
+			result, _ := ac.IsHTTPRequestAuthorized(request, ctx, "GetProfile", nil)
+			Expect(result).To(BeFalse())
+		})
+		It("should deny with ACL handlers", func() {
+			SetCurrentObjectCtx(ctx, request, normalUser)
+			decision := ac.DecideHTTPRequest(request, ctx, "EditUser", anotherNormalUser)
+			Expect(decision.Effect).To(Equal(domain.ACLDeny))
+			Expect(decision.Message).To(Equal("Forbidden (403)"))
+		})
+		It("should require authentication if an ACL handler denies a request without user", func() {
+			ac.Add(&domain.ACLMap{
+				"GetAccount": func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, "no account"
+				},
+			})
+			decision := ac.DecideHTTPRequest(request, ctx, "GetAccount", nil)
+			Expect(decision.Effect).To(Equal(domain.ACLRequireAuthentication))
+			Expect(decision.Message).To(Equal("no account"))
+			Expect(ac.DecideHTTPRequest(request, ctx, "GetAccount", normalUser).Effect).To(Equal(domain.ACLDeny))
+		})
+		It("should require authentication if the RBAC policy denies a request without user", func() {
+			ac.UseRBACPolicy(server.NewRBACPolicy().AddRole("user", nil, "users:read").Require("ListUsers", "users:read"))
+			Expect(ac.DecideHTTPRequest(request, ctx, "ListUsers", nil).Effect).To(Equal(domain.ACLRequireAuthentication))
+		})
+		It("should return error for nil or existing decision handlers", func() {
+			Expect(ac.AddDecisionHandler("GetAccount", nil)).ToNot(BeNil())
+			Expect(ac.AddDecisionHandler("GetProfile", requireUser)).ToNot(BeNil())
+			Expect(ac.AddDecisionHandler("ListUsers", requireUser)).ToNot(BeNil())
+		})
+		It("should replace decision handlers with Add()", func() {
+			ac.Add(&domain.ACLMap{
+				"GetProfile": func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, ""
+				},
+			})
+			Expect(ac.DecideHTTPRequest(request, ctx, "GetProfile", normalUser).Effect).To(Equal(domain.ACLDeny))
+			Expect(ac.DecideHTTPRequest(request, ctx, "GetProfile", nil).Effect).To(Equal(domain.ACLRequireAuthentication))
+		})
+		Context("when used as a gateway", func() {
+			serve := func(action string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				ac.NewContextHandler(action, func(w http.ResponseWriter, req *http.Request) {})(recorder, request)
+				return recorder
+			}
+			It("should respond 401 with a WWW-Authenticate challenge", func() {
+				recorder := serve("GetProfile")
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+				Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			})
+			It("should use the configured challenge", func() {
+				ac.AuthenticationScheme = "Basic"
+				ac.AuthenticationRealm = "slumber"
+				Expect(serve("GetProfile").Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="slumber"`))
+			})
+			It("should use the challenge of the decision", func() {
+				ac.AddDecisionHandler("GetAccount", func(req *http.Request, user domain.IUser) domain.ACLDecision {
+					return domain.ACLDecision{Effect: domain.ACLRequireAuthentication, Scheme: "ApiKey", Realm: "profiles"}
+				})
+				recorder := serve("GetAccount")
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+				Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`ApiKey realm="profiles"`))
+			})
+		})
+	})
+
+	Describe("UseAuditSink()", func() {
+		var sink *testAuditSink
+
+		BeforeEach(func() {
+			sink = &testAuditSink{}
+			ac.UseAuditSink(sink)
+			ac.Add(&aclMap)
+			ac.Add(&domain.ACLMap{
+				"TestForbidden": func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, "not today"
+				},
+			})
+		})
+		It("should record allowed and denied requests", func() {
+			request, _ = http.NewRequest("DELETE", "/api/users/1", nil)
+			request.RemoteAddr = "10.0.0.1:1234"
+			request.Header.Set(domain.RequestIDHeader, "request-1")
+			ctx.SetCurrentUserCtx(request, normalUser)
+
+			ac.NewContextHandler("ListUsers", func(w http.ResponseWriter, req *http.Request) {})(httptest.NewRecorder(), request)
+			ac.NewContextHandler("TestForbidden", func(w http.ResponseWriter, req *http.Request) {})(httptest.NewRecorder(), request)
+
+			Expect(sink.entries).To(HaveLen(2))
+			Expect(sink.entries[0].Allowed).To(BeTrue())
+			Expect(sink.entries[0].Action).To(Equal("ListUsers"))
+			Expect(sink.entries[0].UserID).To(Equal(normalUser.GetID()))
+			Expect(sink.entries[0].Method).To(Equal("DELETE"))
+			Expect(sink.entries[0].Path).To(Equal("/api/users/1"))
+			Expect(sink.entries[0].ClientIP).To(Equal("10.0.0.1"))
+			Expect(sink.entries[0].RequestID).To(Equal("request-1"))
+			Expect(sink.entries[0].Timestamp.IsZero()).To(BeFalse())
+			Expect(sink.entries[1].Allowed).To(BeFalse())
+			Expect(sink.entries[1].Message).To(Equal("not today"))
+		})
+		It("should record resource-level decisions", func() {
+			ac.Authorize(request, "posts:update", nil, nil)
+			Expect(sink.entries).To(HaveLen(1))
+			Expect(sink.entries[0].Action).To(Equal("posts:update"))
+			Expect(sink.entries[0].Allowed).To(BeFalse())
+			Expect(sink.entries[0].UserID).To(Equal(""))
+		})
+	})
+
 	type TestResponse struct {
 		Value   string `json:"value,omitempty"`
 		Success bool   `json:"success,omitempty"`
@@ -203,7 +472,7 @@ var _ = Describe("AccessController", func() {
 		})
 
 		Context("when request is forbidden", func() {
-			It("should be working", func() {
+			It("should respond 401 to a request without user", func() {
 				// add test ACL map
 				ac.Add(&domain.ACLMap{
 					"TestForbidden": func(req *http.Request, user domain.IUser) (bool, string) {
@@ -217,7 +486,8 @@ var _ = Describe("AccessController", func() {
 				renderer.Handler(recorder, request, acHandler, ctx)
 
 				acHandler.ServeHTTP(recorder, request)
-				Expect(recorder.Code).To(Equal(http.StatusForbidden))
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+				Expect(recorder.Header().Get("WWW-Authenticate")).ToNot(BeEmpty())
 
 			})
 		})
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+)
+
+// headResponseWriter discards the response body written by a GET handler while keeping
+// track of its length and checksum, so that a HEAD response can still report
+// `Content-Length` and `ETag` headers
+type headResponseWriter struct {
+	w      http.ResponseWriter
+	status int
+	length int
+	hash   hash.Hash
+}
+
+func newHeadResponseWriter(w http.ResponseWriter) *headResponseWriter {
+	return &headResponseWriter{w, 0, 0, sha1.New()}
+}
+
+func (hw *headResponseWriter) Header() http.Header {
+	return hw.w.Header()
+}
+
+func (hw *headResponseWriter) WriteHeader(status int) {
+	// headers are only flushed in finish(), once the body length is known
+	if hw.status == 0 {
+		hw.status = status
+	}
+}
+
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	hw.length += len(b)
+	return hw.hash.Write(b)
+}
+
+// finish writes the recorded status code along with the computed headers,
+// unless the handler has already set them
+func (hw *headResponseWriter) finish() {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	header := hw.w.Header()
+	if header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(hw.length))
+	}
+	if header.Get("ETag") == "" && hw.length > 0 {
+		header.Set("ETag", fmt.Sprintf(`"%x"`, hw.hash.Sum(nil)))
+	}
+	hw.w.WriteHeader(hw.status)
+}
+
+// headHandlerFunc Returns a handler that serves a HEAD request by running the given GET handler
+func headHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		hw := newHeadResponseWriter(w)
+		handler(hw, req)
+		hw.finish()
+	}
+}
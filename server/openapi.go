@@ -0,0 +1,258 @@
+package server
+
+import (
+	"github.com/sogko/slumber/domain"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const OpenAPIVersion = "3.0.0"
+
+// DefaultOpenAPIInfoVersion is the version of the document when neither the info nor the API version is set,
+// OpenAPI 3 requires a non-empty version
+const DefaultOpenAPIInfoVersion = "1.0.0"
+
+// OpenAPIInfo type, the OpenAPI 3 Info Object
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Content  map[string]OpenAPIMediaType `json:"content"`
+	Required bool                        `json:"required,omitempty"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+}
+
+// OpenAPIDocument type, a generated OpenAPI 3 document
+// Paths maps a path to its operations, keyed by lower-cased HTTP method
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// pathParamRegExp matches `{name}` and `{name:pattern}` route variables
+var pathParamRegExp = regexp.MustCompile(`\{([^{}:]+)(:[^{}]*)?\}`)
+
+// OpenAPIDocument Returns an OpenAPI 3 document generated from the routes added to the router.
+// Only routes that has a handler for the specified API version are documented.
+// If version is empty, routes are documented using their default version.
+// info.Version defaults to version, or DefaultOpenAPIInfoVersion if both are empty.
+func (router *Router) OpenAPIDocument(version domain.RouteHandlerVersion, info OpenAPIInfo) *OpenAPIDocument {
+	if info.Version == "" {
+		info.Version = string(version)
+	}
+	if info.Version == "" {
+		info.Version = DefaultOpenAPIInfoVersion
+	}
+	doc := &OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    info,
+		Paths:   map[string]map[string]*OpenAPIOperation{},
+	}
+	registry := newOpenAPISchemaRegistry()
+
+	for _, route := range router.routes {
+		routeVersion := version
+		if routeVersion == "" {
+			routeVersion = route.DefaultVersion
+		}
+		if _, ok := route.RouteHandlers[routeVersion]; !ok {
+			continue
+		}
+		path := pathParamRegExp.ReplaceAllString(route.Pattern, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*OpenAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = newOpenAPIOperation(registry, route, route.Doc.ForVersion(routeVersion))
+	}
+	doc.Components.Schemas = registry.schemas
+	return doc
+}
+
+func newOpenAPIOperation(registry *openAPISchemaRegistry, route domain.Route, routeDoc *domain.RouteDoc) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		OperationID: route.Name,
+		Responses:   map[string]OpenAPIResponse{},
+	}
+
+	// path variables are always documented, even if the route has no RouteDoc
+	declared := map[string]bool{}
+	if routeDoc != nil {
+		for _, p := range routeDoc.Parameters {
+			in := p.In
+			if in == "" {
+				in = "query"
+			}
+			if in == "path" {
+				declared[p.Name] = true
+			}
+			schema := registry.SchemaOf(p.Type)
+			if schema == nil {
+				schema = &OpenAPISchema{Type: "string"}
+			}
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:        p.Name,
+				In:          in,
+				Description: p.Description,
+				Required:    p.Required || in == "path",
+				Schema:      schema,
+			})
+		}
+	}
+	for _, match := range pathParamRegExp.FindAllStringSubmatch(route.Pattern, -1) {
+		if declared[match[1]] {
+			continue
+		}
+		op.Parameters = append(op.Parameters, OpenAPIParameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &OpenAPISchema{Type: "string"},
+		})
+	}
+
+	if routeDoc == nil {
+		op.Responses["default"] = OpenAPIResponse{Description: "Response"}
+		return op
+	}
+
+	op.Summary = routeDoc.Summary
+	op.Description = routeDoc.Description
+	op.Tags = routeDoc.Tags
+	if routeDoc.Request != nil {
+		op.RequestBody = &OpenAPIRequestBody{
+			Content: map[string]OpenAPIMediaType{
+				"application/json": OpenAPIMediaType{registry.SchemaOf(routeDoc.Request)},
+			},
+			Required: true,
+		}
+	}
+
+	// sort status codes so that schemas are registered in a stable order
+	statuses := []int{}
+	for status := range routeDoc.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		r := routeDoc.Responses[status]
+		response := OpenAPIResponse{Description: r.Description}
+		if response.Description == "" {
+			response.Description = http.StatusText(status)
+		}
+		if r.Body != nil {
+			response.Content = map[string]OpenAPIMediaType{
+				"application/json": OpenAPIMediaType{registry.SchemaOf(r.Body)},
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["default"] = OpenAPIResponse{Description: "Response"}
+	}
+	return op
+}
+
+// OpenAPIResourceOptions type
+// ACLHandler defaults to allowing every request, API documents are usually public
+type OpenAPIResourceOptions struct {
+	Pattern    string
+	Info       OpenAPIInfo
+	ACLHandler domain.ACLHandlerFunc
+}
+
+// OpenAPIResource implements IResource
+// It serves the OpenAPI document for the API version specified either by the `version` query param,
+// or by the `version` param of the Accept header, for eg: `Accept=application/json;version=1.0`
+type OpenAPIResource struct {
+	ctx      domain.IContext
+	renderer domain.IRenderer
+	router   *Router
+	options  *OpenAPIResourceOptions
+}
+
+// NewOpenAPIResource Returns a new OpenAPIResource object
+func NewOpenAPIResource(ctx domain.IContext, renderer domain.IRenderer, router *Router, options *OpenAPIResourceOptions) *OpenAPIResource {
+	if options.Pattern == "" {
+		options.Pattern = "/api/openapi.json"
+	}
+	if options.ACLHandler == nil {
+		options.ACLHandler = func(req *http.Request, user domain.IUser) (bool, string) {
+			return true, ""
+		}
+	}
+	return &OpenAPIResource{ctx, renderer, router, options}
+}
+
+func (resource *OpenAPIResource) Context() domain.IContext {
+	return resource.ctx
+}
+
+func (resource *OpenAPIResource) Routes() *domain.Routes {
+	return &domain.Routes{
+		domain.Route{
+			Name:           "GetOpenAPIDocument",
+			Method:         "GET",
+			Pattern:        resource.options.Pattern,
+			DefaultVersion: "0.0",
+			RouteHandlers: domain.RouteHandlers{
+				"0.0": resource.HandleGetDocument,
+			},
+			ACLHandler: resource.options.ACLHandler,
+		},
+	}
+}
+
+func (resource *OpenAPIResource) Render(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	resource.renderer.JSON(w, status, v)
+}
+
+func (resource *OpenAPIResource) HandleGetDocument(w http.ResponseWriter, req *http.Request) {
+	version := req.URL.Query().Get("version")
+	if version == "" {
+		for _, h := range domain.NewAcceptHeadersFromString(req.Header.Get("accept")) {
+			if v, ok := h.MediaType.Parameters["version"]; ok {
+				version = v
+				break
+			}
+		}
+	}
+	resource.Render(w, req, http.StatusOK, resource.router.OpenAPIDocument(domain.RouteHandlerVersion(version), resource.options.Info))
+}
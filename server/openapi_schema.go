@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPISchema type, a subset of the OpenAPI 3 Schema Object
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// openAPISchemaRegistry derives schemas from Go types through reflection.
+// Named struct types are registered once as components and referenced by `$ref`.
+type openAPISchemaRegistry struct {
+	schemas map[string]*OpenAPISchema
+	types   map[reflect.Type]string
+}
+
+func newOpenAPISchemaRegistry() *openAPISchemaRegistry {
+	return &openAPISchemaRegistry{map[string]*OpenAPISchema{}, map[reflect.Type]string{}}
+}
+
+// SchemaOf Returns the schema for the type of the given sample value
+func (registry *openAPISchemaRegistry) SchemaOf(v interface{}) *OpenAPISchema {
+	if v == nil {
+		return nil
+	}
+	return registry.schemaOfType(reflect.TypeOf(v))
+}
+
+func (registry *openAPISchemaRegistry) schemaOfType(t reflect.Type) *OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		// custom JSON encoding, we can't tell its shape
+		if t.Kind() != reflect.Struct {
+			return registry.schemaOfKind(t)
+		}
+		return &OpenAPISchema{}
+	}
+	if t.Kind() == reflect.Struct {
+		return registry.schemaOfStruct(t)
+	}
+	return registry.schemaOfKind(t)
+}
+
+func (registry *openAPISchemaRegistry) schemaOfKind(t reflect.Type) *OpenAPISchema {
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: registry.schemaOfType(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: registry.schemaOfType(t.Elem())}
+	case reflect.Struct:
+		return registry.schemaOfStruct(t)
+	}
+	// interface{} and other types are left unspecified
+	return &OpenAPISchema{}
+}
+
+func (registry *openAPISchemaRegistry) schemaOfStruct(t reflect.Type) *OpenAPISchema {
+	if t.Name() == "" {
+		// anonymous struct is defined inline
+		return registry.structSchema(t)
+	}
+	if name, ok := registry.types[t]; ok {
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	name := t.Name()
+	if _, taken := registry.schemas[name]; taken {
+		// same type name from another package
+		name = strings.Replace(t.PkgPath(), "/", ".", -1) + "." + name
+	}
+	// register name before building the schema, so that recursive types can reference it
+	registry.types[t] = name
+	registry.schemas[name] = &OpenAPISchema{}
+	*registry.schemas[name] = *registry.structSchema(t)
+	return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+func (registry *openAPISchemaRegistry) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	registry.addStructFields(schema, t)
+	return schema
+}
+
+func (registry *openAPISchemaRegistry) addStructFields(schema *OpenAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			// unexported field
+			continue
+		}
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			// embedded struct fields are promoted
+			registry.addStructFields(schema, fieldType)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = registry.schemaOfType(field.Type)
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// jsonFieldName parses the `json` tag of a struct field
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	tokens := strings.Split(tag, ",")
+	for _, option := range tokens[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return tokens[0], omitEmpty, false
+}
@@ -0,0 +1,167 @@
+package server_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/middlewares/renderer"
+	"github.com/sogko/slumber/server"
+	"github.com/sogko/slumber/test_helpers"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type TestOpenAPIAddress struct {
+	City string `json:"city"`
+}
+
+type TestOpenAPIUser struct {
+	ID        string              `json:"id"`
+	Email     string              `json:"email,omitempty"`
+	Age       int                 `json:"age"`
+	Tags      []string            `json:"tags"`
+	Address   *TestOpenAPIAddress `json:"address"`
+	Friends   []TestOpenAPIUser   `json:"friends,omitempty"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Password  string              `json:"-"`
+}
+
+type TestOpenAPICreateUserRequest struct {
+	Email string `json:"email"`
+}
+
+var _ = Describe("OpenAPI", func() {
+
+	var router *server.Router
+	handleAcl := func(req *http.Request, user domain.IUser) (bool, string) {
+		return true, ""
+	}
+	handleStub := func(w http.ResponseWriter, req *http.Request) {}
+
+	BeforeEach(func() {
+		ctx := context.New()
+		router = server.NewRouter(ctx, nil)
+		router.AddRoutes(&domain.Routes{
+			domain.Route{
+				Name:           "GetUser",
+				Method:         "GET",
+				Pattern:        "/api/users/{id:[0-9a-f]+}",
+				DefaultVersion: "0.1",
+				RouteHandlers: domain.RouteHandlers{
+					"0.1": handleStub,
+					"0.2": handleStub,
+				},
+				ACLHandler: handleAcl,
+				Doc: &domain.RouteDoc{
+					Summary: "Get a user",
+					Responses: map[int]domain.RouteResponse{
+						http.StatusOK: domain.RouteResponse{Body: TestOpenAPIUser{}},
+					},
+					Versions: map[domain.RouteHandlerVersion]*domain.RouteDoc{
+						"0.2": &domain.RouteDoc{Summary: "Get a user (v0.2)"},
+					},
+				},
+			},
+			domain.Route{
+				Name:           "CreateUser",
+				Method:         "POST",
+				Pattern:        "/api/users",
+				DefaultVersion: "0.1",
+				RouteHandlers: domain.RouteHandlers{
+					"0.1": handleStub,
+				},
+				ACLHandler: handleAcl,
+				Doc: &domain.RouteDoc{
+					Parameters: []domain.RouteParameter{
+						domain.RouteParameter{Name: "notify", In: "query", Type: true},
+					},
+					Request: TestOpenAPICreateUserRequest{},
+					Responses: map[int]domain.RouteResponse{
+						http.StatusCreated: domain.RouteResponse{Description: "Created", Body: &TestOpenAPIUser{}},
+					},
+				},
+			},
+		})
+	})
+
+	Describe("OpenAPIDocument()", func() {
+
+		It("should document routes using their default version", func() {
+			doc := router.OpenAPIDocument("", server.OpenAPIInfo{Title: "Test"})
+
+			Expect(doc.OpenAPI).To(Equal(server.OpenAPIVersion))
+			Expect(doc.Info.Version).To(Equal(server.DefaultOpenAPIInfoVersion))
+			Expect(doc.Paths).To(HaveKey("/api/users/{id}"))
+			Expect(doc.Paths).To(HaveKey("/api/users"))
+
+			op := doc.Paths["/api/users/{id}"]["get"]
+			Expect(op.OperationID).To(Equal("GetUser"))
+			Expect(op.Summary).To(Equal("Get a user"))
+			Expect(op.Parameters).To(HaveLen(1))
+			Expect(op.Parameters[0].Name).To(Equal("id"))
+			Expect(op.Parameters[0].In).To(Equal("path"))
+			Expect(op.Parameters[0].Required).To(BeTrue())
+			Expect(op.Responses["200"].Description).To(Equal("OK"))
+			Expect(op.Responses["200"].Content["application/json"].Schema.Ref).To(Equal("#/components/schemas/TestOpenAPIUser"))
+		})
+
+		It("should only document routes that has a handler for the specified version", func() {
+			doc := router.OpenAPIDocument("0.2", server.OpenAPIInfo{Title: "Test"})
+
+			Expect(doc.Info.Version).To(Equal("0.2"))
+			Expect(doc.Paths).To(HaveKey("/api/users/{id}"))
+			Expect(doc.Paths).ToNot(HaveKey("/api/users"))
+			Expect(doc.Paths["/api/users/{id}"]["get"].Summary).To(Equal("Get a user (v0.2)"))
+		})
+
+		It("should keep the version of the info", func() {
+			doc := router.OpenAPIDocument("0.2", server.OpenAPIInfo{Title: "Test", Version: "2.1.0"})
+			Expect(doc.Info.Version).To(Equal("2.1.0"))
+		})
+
+		It("should derive schemas from struct types", func() {
+			doc := router.OpenAPIDocument("0.1", server.OpenAPIInfo{Title: "Test"})
+
+			user := doc.Components.Schemas["TestOpenAPIUser"]
+			Expect(user).ToNot(BeNil())
+			Expect(user.Type).To(Equal("object"))
+			Expect(user.Properties["id"].Type).To(Equal("string"))
+			Expect(user.Properties["age"].Type).To(Equal("integer"))
+			Expect(user.Properties["tags"].Type).To(Equal("array"))
+			Expect(user.Properties["tags"].Items.Type).To(Equal("string"))
+			Expect(user.Properties["address"].Ref).To(Equal("#/components/schemas/TestOpenAPIAddress"))
+			Expect(user.Properties["friends"].Items.Ref).To(Equal("#/components/schemas/TestOpenAPIUser"))
+			Expect(user.Properties["createdAt"].Format).To(Equal("date-time"))
+			Expect(user.Properties).ToNot(HaveKey("Password"))
+			Expect(user.Required).To(ConsistOf("id", "age", "tags", "createdAt"))
+
+			op := doc.Paths["/api/users"]["post"]
+			Expect(op.RequestBody.Content["application/json"].Schema.Ref).To(Equal("#/components/schemas/TestOpenAPICreateUserRequest"))
+			Expect(op.Parameters[0].Schema.Type).To(Equal("boolean"))
+			Expect(op.Responses["201"].Description).To(Equal("Created"))
+		})
+	})
+
+	Describe("OpenAPIResource", func() {
+		It("should serve the document for the requested version", func() {
+			ctx := context.New()
+			r := renderer.New(&renderer.Options{}, renderer.JSON)
+			router = server.NewRouter(ctx, nil)
+			resource := server.NewOpenAPIResource(ctx, r, router, &server.OpenAPIResourceOptions{
+				Info: server.OpenAPIInfo{Title: "Test"},
+			})
+			router.AddResources(resource)
+
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/api/openapi.json?version=0.0", nil)
+			router.ServeHTTP(recorder, request)
+			body := test_helpers.MapFromJSON(recorder.Body.Bytes())
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(body["openapi"]).To(Equal(server.OpenAPIVersion))
+			Expect(body["paths"]).To(HaveKey("/api/openapi.json"))
+		})
+	})
+})
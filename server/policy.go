@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/sogko/slumber/domain"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// PolicyResourceKey is the context key of the document a request acts on.
+// Set it from a middleware that runs outside the ACL check (see domain.MiddlewaresOutsideACL)
+// for policy rules to refer to the document through `resource.document`.
+const PolicyResourceKey domain.ContextKey = "slumber-policy-resource"
+
+// PolicyEffect type
+type PolicyEffect string
+
+const (
+	PolicyAllow         PolicyEffect = "allow"
+	PolicyDeny          PolicyEffect = "deny"
+	PolicyNotApplicable PolicyEffect = "not-applicable"
+)
+
+// PolicyRule type
+// Actions are route names the rule applies to, `*` applies to every action.
+// An empty Condition always matches.
+type PolicyRule struct {
+	Name      string
+	Effect    PolicyEffect
+	Actions   []string
+	Condition string
+	condition policyExpr
+}
+
+// PolicyDecision is the result of evaluating a policy
+type PolicyDecision struct {
+	Effect  PolicyEffect
+	Rule    string
+	Message string
+}
+
+// PolicyEngine evaluates attribute-based policy rules.
+// Rules are combined with deny-overrides: a matching deny rule denies the request regardless of
+// matching allow rules; if no rule matches, the decision is not applicable.
+// An action that allow rules apply to requires a matching allow rule, see RequiresAllow; the access
+// controller denies it when the decision is not applicable.
+// RoleFromName converts role names used with has_role() into the value passed to IUser.HasRole().
+// TrustProxyHeaders uses X-Forwarded-For / X-Real-IP headers for `env.ip`.
+type PolicyEngine struct {
+	Rules             []*PolicyRule
+	RoleFromName      func(name string) domain.IRole
+	TrustProxyHeaders bool
+	Now               func() time.Time
+}
+
+// NewPolicyEngine Returns a new PolicyEngine object
+func NewPolicyEngine() *PolicyEngine {
+	return &PolicyEngine{[]*PolicyRule{}, nil, false, time.Now}
+}
+
+// LoadPolicyEngine Returns the PolicyEngine with rules read from r, one rule per line, see ParsePolicyRule.
+// Empty lines and lines starting with `#` are ignored.
+func LoadPolicyEngine(r io.Reader) (*PolicyEngine, error) {
+	engine := NewPolicyEngine()
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParsePolicyRule(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error loading policy, line %v: %v", lineNumber, err.Error()))
+		}
+		if err := engine.AddRule(rule); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error loading policy, line %v: %v", lineNumber, err.Error()))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error loading policy: %v", err.Error()))
+	}
+	return engine, nil
+}
+
+// LoadPolicyEngineFile Returns the PolicyEngine with rules read from a file, see LoadPolicyEngine
+func LoadPolicyEngineFile(filename string) (*PolicyEngine, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error loading policy: %v", err.Error()))
+	}
+	defer f.Close()
+	return LoadPolicyEngine(f)
+}
+
+// ParsePolicyRule Returns the rule declared in a line of the policy DSL:
+//
+//	[name] <allow|deny> <action>[,<action>...] [if <condition>]
+//
+// for eg:
+//
+//	[owner-only] deny UpdateUser,DeleteUser if subject.id != resource.vars.id and not has_role("admin")
+//	[office-hours] deny * if env.hour < 8 or env.hour >= 20
+func ParsePolicyRule(line string) (*PolicyRule, error) {
+	rule := &PolicyRule{}
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") {
+		end := strings.Index(line, "]")
+		if end < 0 {
+			return nil, errors.New("Rule name is missing `]`")
+		}
+		rule.Name = strings.TrimSpace(line[1:end])
+		line = strings.TrimSpace(line[end+1:])
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.New(fmt.Sprintf("Invalid rule `%v`, expected `<allow|deny> <actions> [if <condition>]`", line))
+	}
+	rule.Effect = PolicyEffect(fields[0])
+	for _, action := range strings.Split(fields[1], ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			rule.Actions = append(rule.Actions, action)
+		}
+	}
+	if len(fields) > 2 {
+		if fields[2] != "if" {
+			return nil, errors.New(fmt.Sprintf("Invalid rule `%v`, expected `if` after actions", line))
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		rule.Condition = strings.TrimSpace(strings.TrimPrefix(rest, "if"))
+		if rule.Condition == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid rule `%v`, expected a condition after `if`", line))
+		}
+	}
+	if rule.Name == "" {
+		rule.Name = line
+	}
+	return rule, nil
+}
+
+// AddRule validates and compiles the rule condition, then adds the rule to the engine
+func (engine *PolicyEngine) AddRule(rule *PolicyRule) error {
+	if rule.Effect != PolicyAllow && rule.Effect != PolicyDeny {
+		return errors.New(fmt.Sprintf("Invalid effect `%v` for policy rule `%v`, expected `allow` or `deny`", rule.Effect, rule.Name))
+	}
+	if len(rule.Actions) == 0 {
+		return errors.New(fmt.Sprintf("Policy rule `%v` has no actions", rule.Name))
+	}
+	if rule.Condition != "" {
+		condition, err := parsePolicyExpression(rule.Condition)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid condition for policy rule `%v`: %v", rule.Name, err.Error()))
+		}
+		rule.condition = condition
+	}
+	engine.Rules = append(engine.Rules, rule)
+	return nil
+}
+
+// Allow adds an allow rule, see AddRule
+func (engine *PolicyEngine) Allow(name string, actions []string, condition string) error {
+	return engine.AddRule(&PolicyRule{Name: name, Effect: PolicyAllow, Actions: actions, Condition: condition})
+}
+
+// Deny adds a deny rule, see AddRule
+func (engine *PolicyEngine) Deny(name string, actions []string, condition string) error {
+	return engine.AddRule(&PolicyRule{Name: name, Effect: PolicyDeny, Actions: actions, Condition: condition})
+}
+
+// appliesTo Returns true if the rule applies to the action
+func (rule *PolicyRule) appliesTo(action string) bool {
+	for _, a := range rule.Actions {
+		if a == "*" || a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAction Returns true if any rule applies to the action
+func (engine *PolicyEngine) HasAction(action string) bool {
+	for _, rule := range engine.Rules {
+		if rule.appliesTo(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresAllow Returns true if an allow rule applies to the action, the action is then only allowed
+// by a matching allow rule
+func (engine *PolicyEngine) RequiresAllow(action string) bool {
+	for _, rule := range engine.Rules {
+		if rule.Effect == PolicyAllow && rule.appliesTo(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate Returns the combined decision of the rules that applies to the action.
+// A rule whose condition fails to evaluate denies the request.
+func (engine *PolicyEngine) Evaluate(req *http.Request, ctx domain.IContext, action string, user domain.IUser) PolicyDecision {
+	var resource interface{}
+	if ctx != nil && req != nil {
+		resource = ctx.Get(req, PolicyResourceKey)
+	}
+	return engine.EvaluateResource(req, action, user, resource)
+}
+
+// EvaluateResource Returns the combined decision of the rules that applies to the action on the resource,
+// the resource is available to rule conditions as `resource.document`
+func (engine *PolicyEngine) EvaluateResource(req *http.Request, action string, user domain.IUser, resource interface{}) PolicyDecision {
+	return engine.evaluate(engine.Attributes(req, action, user, resource), action, user)
+}
+
+func (engine *PolicyEngine) evaluate(attributes map[string]interface{}, action string, user domain.IUser) PolicyDecision {
+	env := &policyEnv{attributes, user, engine.RoleFromName}
+	decision := PolicyDecision{Effect: PolicyNotApplicable}
+	for _, rule := range engine.Rules {
+		if !rule.appliesTo(action) {
+			continue
+		}
+		matched := true
+		if rule.condition != nil {
+			var err error
+			matched, err = evalPolicyBool(rule.condition, env)
+			if err != nil {
+				return PolicyDecision{PolicyDeny, rule.Name, fmt.Sprintf("Forbidden (403), error evaluating policy rule `%v`: %v", rule.Name, err.Error())}
+			}
+		}
+		if !matched {
+			continue
+		}
+		if rule.Effect == PolicyDeny {
+			return PolicyDecision{PolicyDeny, rule.Name, fmt.Sprintf("Forbidden (403), denied by policy rule `%v`", rule.Name)}
+		}
+		if decision.Effect == PolicyNotApplicable {
+			decision = PolicyDecision{PolicyAllow, rule.Name, ""}
+		}
+	}
+	return decision
+}
+
+// Attributes Returns the attributes that rule conditions are evaluated against:
+//
+//	subject.id, subject.authenticated, subject.<field>   the user and its JSON fields
+//	action.name, action.method
+//	resource.vars.<name>, resource.path, resource.document.<field>
+//	env.time, env.unix, env.hour, env.weekday, env.ip
+func (engine *PolicyEngine) Attributes(req *http.Request, action string, user domain.IUser, resource interface{}) map[string]interface{} {
+	subject := map[string]interface{}{}
+	if user != nil {
+		subject = policyObject(user)
+		subject["id"] = user.GetID()
+	}
+	subject["authenticated"] = user != nil
+
+	now := engine.Now
+	if now == nil {
+		now = time.Now
+	}
+	t := now()
+	env := map[string]interface{}{
+		"time":    t.Format(time.RFC3339),
+		"unix":    float64(t.Unix()),
+		"hour":    float64(t.Hour()),
+		"weekday": t.Weekday().String(),
+	}
+
+	vars := map[string]interface{}{}
+	actionAttributes := map[string]interface{}{"name": action}
+	resourceAttributes := map[string]interface{}{"vars": vars}
+	if req != nil {
+		for key, value := range mux.Vars(req) {
+			vars[key] = value
+		}
+		actionAttributes["method"] = req.Method
+		resourceAttributes["path"] = req.URL.Path
+		env["ip"] = domain.ClientIP(req, engine.TrustProxyHeaders)
+	}
+	if resource != nil {
+		resourceAttributes["document"] = policyObject(resource)
+	}
+
+	return map[string]interface{}{
+		"subject":  subject,
+		"action":   actionAttributes,
+		"resource": resourceAttributes,
+		"env":      env,
+	}
+}
+
+// policyObject Returns the JSON representation of v as a map, or an empty map if v is not a JSON object
+func policyObject(v interface{}) map[string]interface{} {
+	object := map[string]interface{}{}
+	if m, ok := v.(map[string]interface{}); ok {
+		for key, value := range m {
+			object[key] = value
+		}
+		return object
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return object
+	}
+	json.Unmarshal(b, &object)
+	return object
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Policy rule conditions are expressions over the request attributes, for eg:
+//
+//	subject.id == resource.vars.id or has_role("admin")
+//	env.hour >= 9 and env.hour < 18 and not cidr(env.ip, "10.0.0.0/8")
+//	action.method in ["PUT", "DELETE"] and resource.document.owner != subject.id
+//
+// Operators, from lowest to highest precedence:
+//
+//	or, and, not, == != < <= > >= in contains matches
+//
+// Values are strings ("..." or '...'), numbers, true, false, null, lists ([...]),
+// attribute paths (subject.id) and function calls:
+//
+//	has_role(name)    true if the subject has the role
+//	cidr(ip, block)   true if the IP address is in the CIDR block
+//	len(value)        length of a string, list or object
+//	lower(value)      lower-cased string
+//
+// Attributes that does not exist evaluates to null.
+
+// policyEnv is the evaluation environment of a policy expression
+type policyEnv struct {
+	attributes   map[string]interface{}
+	user         domain.IUser
+	roleFromName func(name string) domain.IRole
+}
+
+type policyExpr interface {
+	eval(env *policyEnv) (interface{}, error)
+}
+
+type policyLiteral struct {
+	value interface{}
+}
+
+type policyPath struct {
+	path []string
+}
+
+type policyList struct {
+	items []policyExpr
+}
+
+type policyCall struct {
+	name string
+	args []policyExpr
+}
+
+type policyNot struct {
+	x policyExpr
+}
+
+type policyBinary struct {
+	op string
+	x  policyExpr
+	y  policyExpr
+}
+
+func (e *policyLiteral) eval(env *policyEnv) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *policyPath) eval(env *policyEnv) (interface{}, error) {
+	var current interface{} = env.attributes
+	for _, key := range e.path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		current = m[key]
+	}
+	return current, nil
+}
+
+func (e *policyList) eval(env *policyEnv) (interface{}, error) {
+	values := []interface{}{}
+	for _, item := range e.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// policyFunctions is the number of arguments of the functions of policy expressions, by name
+var policyFunctions = map[string]int{"has_role": 1, "cidr": 2, "len": 1, "lower": 1}
+
+func (e *policyCall) eval(env *policyEnv) (interface{}, error) {
+	args := []interface{}{}
+	for _, arg := range e.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	if len(args) != policyFunctions[e.name] {
+		return nil, errors.New(fmt.Sprintf("%v() expects %v arguments, got %v", e.name, policyFunctions[e.name], len(args)))
+	}
+	switch e.name {
+	case "has_role":
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("has_role() expects a string")
+		}
+		if env.user == nil {
+			return false, nil
+		}
+		var role domain.IRole = name
+		if env.roleFromName != nil {
+			role = env.roleFromName(name)
+		}
+		return env.user.HasRole(role), nil
+	case "cidr":
+		ip, _ := args[0].(string)
+		block, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("cidr() expects a CIDR block string")
+		}
+		_, ipNet, err := net.ParseCIDR(block)
+		if err != nil {
+			return nil, err
+		}
+		parsed := net.ParseIP(ip)
+		return parsed != nil && ipNet.Contains(parsed), nil
+	case "len":
+		if args[0] == nil {
+			return float64(0), nil
+		}
+		switch reflect.ValueOf(args[0]).Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			return float64(reflect.ValueOf(args[0]).Len()), nil
+		}
+		return nil, errors.New("len() expects a string, list or object")
+	case "lower":
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("lower() expects a string")
+		}
+		return strings.ToLower(s), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown function %v()", e.name))
+}
+
+func (e *policyNot) eval(env *policyEnv) (interface{}, error) {
+	v, err := evalPolicyBool(e.x, env)
+	if err != nil {
+		return nil, err
+	}
+	return !v, nil
+}
+
+func (e *policyBinary) eval(env *policyEnv) (interface{}, error) {
+	// `and` and `or` short-circuits
+	if e.op == "and" || e.op == "or" {
+		x, err := evalPolicyBool(e.x, env)
+		if err != nil {
+			return nil, err
+		}
+		if (e.op == "and" && !x) || (e.op == "or" && x) {
+			return x, nil
+		}
+		return evalPolicyBool(e.y, env)
+	}
+
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return policyEqual(x, y), nil
+	case "!=":
+		return !policyEqual(x, y), nil
+	case "<", "<=", ">", ">=":
+		if x == nil || y == nil {
+			return false, nil
+		}
+		c, err := policyCompare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		return policyContains(y, x)
+	case "contains":
+		return policyContains(x, y)
+	case "matches":
+		s, _ := x.(string)
+		pattern, ok := y.(string)
+		if !ok {
+			return nil, errors.New("matches expects a regular expression string")
+		}
+		return regexp.MatchString(pattern, s)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown operator %v", e.op))
+}
+
+func evalPolicyBool(e policyExpr, env *policyEnv) (bool, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return false, err
+	}
+	if v == nil {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New(fmt.Sprintf("Expected a boolean, got %v", v))
+	}
+	return b, nil
+}
+
+// policyNumber converts numeric values into float64
+func policyNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func policyEqual(x interface{}, y interface{}) bool {
+	if a, ok := policyNumber(x); ok {
+		if b, ok := policyNumber(y); ok {
+			return a == b
+		}
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func policyCompare(x interface{}, y interface{}) (int, error) {
+	if a, ok := policyNumber(x); ok {
+		if b, ok := policyNumber(y); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	a, ok := x.(string)
+	b, ok2 := y.(string)
+	if ok && ok2 {
+		return strings.Compare(a, b), nil
+	}
+	return 0, errors.New(fmt.Sprintf("Cannot compare %v and %v", x, y))
+}
+
+// policyContains Returns true if the list contains the value, or if the string contains the sub-string
+func policyContains(container interface{}, v interface{}) (bool, error) {
+	switch c := container.(type) {
+	case nil:
+		return false, nil
+	case string:
+		s, ok := v.(string)
+		return ok && strings.Contains(c, s), nil
+	case []interface{}:
+		for _, item := range c {
+			if policyEqual(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		s, ok := v.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c[s]
+		return found, nil
+	}
+	return false, errors.New(fmt.Sprintf("Expected a list or string, got %v", container))
+}
+
+// policyToken type
+type policyToken struct {
+	kind  string // `ident`, `string`, `number`, `op` or `eof`
+	value string
+	pos   int
+}
+
+var policyOperators = []string{"==", "!=", "<=", ">=", "<", ">", "(", ")", "[", "]", ",", "."}
+
+func tokenizePolicyExpression(s string) ([]policyToken, error) {
+	tokens := []policyToken{}
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			value := []byte{}
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value = append(value, s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, errors.New(fmt.Sprintf("Unterminated string at position %v", start))
+			}
+			i++
+			tokens = append(tokens, policyToken{"string", string(value), start})
+		case c >= '0' && c <= '9' || (c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			start := i
+			i++
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, policyToken{"number", s[start:i], start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, policyToken{"ident", s[start:i], start})
+		default:
+			found := false
+			for _, op := range policyOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, policyToken{"op", op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, errors.New(fmt.Sprintf("Unexpected character `%c` at position %v", c, i))
+			}
+		}
+	}
+	return append(tokens, policyToken{"eof", "", len(s)}), nil
+}
+
+// policyParser is a recursive descent parser for policy expressions
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+}
+
+// parsePolicyExpression Returns the compiled policy expression
+func parsePolicyExpression(s string) (policyExpr, error) {
+	tokens, err := tokenizePolicyExpression(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens, 0}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, errors.New(fmt.Sprintf("Unexpected `%v` at position %v", t.value, t.pos))
+	}
+	return expr, nil
+}
+
+func (p *policyParser) peek() policyToken {
+	return p.tokens[p.pos]
+}
+
+func (p *policyParser) next() policyToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *policyParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == "ident" && t.value == keyword
+}
+
+func (p *policyParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == "op" && t.value == op
+}
+
+func (p *policyParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		t := p.peek()
+		return errors.New(fmt.Sprintf("Expected `%v` at position %v", op, t.pos))
+	}
+	p.next()
+	return nil
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &policyBinary{"or", x, y}
+	}
+	return x, nil
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &policyBinary{"and", x, y}
+	}
+	return x, nil
+}
+
+func (p *policyParser) parseNot() (policyExpr, error) {
+	if p.isKeyword("not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &policyNot{x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (policyExpr, error) {
+	x, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	isComparison := t.kind == "op" && (t.value == "==" || t.value == "!=" || t.value == "<" || t.value == "<=" || t.value == ">" || t.value == ">=")
+	isKeyword := t.kind == "ident" && (t.value == "in" || t.value == "contains" || t.value == "matches")
+	if !isComparison && !isKeyword {
+		return x, nil
+	}
+	p.next()
+	y, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &policyBinary{t.value, x, y}, nil
+}
+
+func (p *policyParser) parseValue() (policyExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return &policyLiteral{t.value}, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid number `%v` at position %v", t.value, t.pos))
+		}
+		return &policyLiteral{f}, nil
+	case "op":
+		if t.value == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expectOp(")")
+		}
+		if t.value == "[" {
+			list := &policyList{}
+			for !p.isOp("]") {
+				item, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return list, p.expectOp("]")
+		}
+	case "ident":
+		switch t.value {
+		case "true":
+			return &policyLiteral{true}, nil
+		case "false":
+			return &policyLiteral{false}, nil
+		case "null":
+			return &policyLiteral{nil}, nil
+		case "and", "or", "not", "in", "contains", "matches":
+			return nil, errors.New(fmt.Sprintf("Unexpected `%v` at position %v", t.value, t.pos))
+		}
+		if p.isOp("(") {
+			p.next()
+			argc, ok := policyFunctions[t.value]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Unknown function %v() at position %v", t.value, t.pos))
+			}
+			call := &policyCall{name: t.value}
+			for !p.isOp(")") {
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			if len(call.args) != argc {
+				return nil, errors.New(fmt.Sprintf("%v() expects %v arguments, got %v at position %v", t.value, argc, len(call.args), t.pos))
+			}
+			return call, nil
+		}
+		path := &policyPath{[]string{t.value}}
+		for p.isOp(".") {
+			p.next()
+			key := p.next()
+			if key.kind != "ident" {
+				return nil, errors.New(fmt.Sprintf("Expected attribute name at position %v", key.pos))
+			}
+			path.path = append(path.path, key.value)
+		}
+		return path, nil
+	case "eof":
+		return nil, errors.New("Unexpected end of expression")
+	}
+	return nil, errors.New(fmt.Sprintf("Unexpected `%v` at position %v", t.value, t.pos))
+}
@@ -0,0 +1,256 @@
+package server_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/middlewares/renderer"
+	"github.com/sogko/slumber/server"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var _ = Describe("PolicyEngine", func() {
+
+	var engine *server.PolicyEngine
+	var request *http.Request
+	user := &rbacTestUser{"user", []string{"user"}}
+	admin := &rbacTestUser{"admin", []string{"admin"}}
+
+	evaluate := func(condition string, user domain.IUser) server.PolicyDecision {
+		engine := server.NewPolicyEngine()
+		engine.Now = func() time.Time {
+			return time.Date(2015, time.June, 1, 10, 30, 0, 0, time.UTC)
+		}
+		Expect(engine.Allow("test", []string{"*"}, condition)).To(BeNil())
+		return engine.Evaluate(request, nil, "GetUser", user)
+	}
+
+	BeforeEach(func() {
+		engine = server.NewPolicyEngine()
+		request, _ = http.NewRequest("PUT", "/api/users/user", nil)
+		request.RemoteAddr = "10.1.2.3:4567"
+	})
+
+	Describe("Conditions", func() {
+		It("should evaluate comparisons and boolean operators", func() {
+			Expect(evaluate(`1 < 2 and "a" != "b"`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`1 >= 2 or false`, nil).Effect).To(Equal(server.PolicyNotApplicable))
+			Expect(evaluate(`not (1 == 2) and 2 <= 2`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`"b" in ["a", "b"] and "bc" contains "c"`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`"user-1" matches '^user-[0-9]+$'`, nil).Effect).To(Equal(server.PolicyAllow))
+		})
+		It("should evaluate subject, action and environment attributes", func() {
+			Expect(evaluate(`subject.id == "user" and subject.authenticated`, user).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`subject.authenticated`, nil).Effect).To(Equal(server.PolicyNotApplicable))
+			Expect(evaluate(`action.name == "GetUser" and action.method == "PUT"`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`resource.path == "/api/users/user"`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`env.hour == 10 and env.weekday == "Monday"`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`env.ip == "10.1.2.3" and cidr(env.ip, "10.0.0.0/8")`, nil).Effect).To(Equal(server.PolicyAllow))
+		})
+		It("should evaluate functions", func() {
+			Expect(evaluate(`has_role("admin")`, admin).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`has_role("admin")`, user).Effect).To(Equal(server.PolicyNotApplicable))
+			Expect(evaluate(`has_role("admin")`, nil).Effect).To(Equal(server.PolicyNotApplicable))
+			Expect(evaluate(`len("abc") == 3 and lower("ABC") == "abc"`, nil).Effect).To(Equal(server.PolicyAllow))
+		})
+		It("should evaluate missing attributes to null", func() {
+			Expect(evaluate(`resource.document.owner == null`, nil).Effect).To(Equal(server.PolicyAllow))
+			Expect(evaluate(`resource.document.owner`, nil).Effect).To(Equal(server.PolicyNotApplicable))
+		})
+		It("should deny if a condition fails to evaluate", func() {
+			decision := evaluate(`"a" < 1`, nil)
+			Expect(decision.Effect).To(Equal(server.PolicyDeny))
+			Expect(decision.Message).To(ContainSubstring("error evaluating"))
+		})
+		It("should return error for invalid conditions", func() {
+			Expect(engine.Allow("test", []string{"*"}, `1 ==`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `(1 == 1`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `"unterminated`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `1 == 1 1`)).ToNot(BeNil())
+		})
+		It("should return error for unknown functions and wrong numbers of arguments", func() {
+			Expect(engine.Allow("test", []string{"*"}, `has_roles("admin")`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `has_role("admin", "user")`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `cidr(env.ip)`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `len()`)).ToNot(BeNil())
+			Expect(engine.Allow("test", []string{"*"}, `lower(subject.name) == "admin" and cidr(env.ip, "10.0.0.0/8")`)).To(BeNil())
+		})
+	})
+
+	Describe("Evaluate()", func() {
+		It("should not be applicable if no rule matches", func() {
+			engine.Deny("other", []string{"DeleteUser"}, "")
+			Expect(engine.Evaluate(request, nil, "GetUser", user).Effect).To(Equal(server.PolicyNotApplicable))
+		})
+		It("should combine rules with deny-overrides", func() {
+			engine.Allow("admins", []string{"*"}, `has_role("admin")`)
+			engine.Deny("office-hours", []string{"UpdateUser"}, `env.hour < 8`)
+			engine.Now = func() time.Time {
+				return time.Date(2015, time.June, 1, 7, 0, 0, 0, time.UTC)
+			}
+			decision := engine.Evaluate(request, nil, "UpdateUser", admin)
+			Expect(decision.Effect).To(Equal(server.PolicyDeny))
+			Expect(decision.Rule).To(Equal("office-hours"))
+
+			decision = engine.Evaluate(request, nil, "GetUser", admin)
+			Expect(decision.Effect).To(Equal(server.PolicyAllow))
+			Expect(decision.Rule).To(Equal("admins"))
+		})
+		It("should only use proxy headers if trusted", func() {
+			engine.Deny("blocked", []string{"*"}, `env.ip == "192.168.0.1"`)
+			request.Header.Set("X-Forwarded-For", "10.0.0.1, 192.168.0.1")
+			Expect(engine.Evaluate(request, nil, "GetUser", user).Effect).To(Equal(server.PolicyNotApplicable))
+			engine.TrustProxyHeaders = true
+			Expect(engine.Evaluate(request, nil, "GetUser", user).Effect).To(Equal(server.PolicyDeny))
+			// a client can not use the address of a forged leading entry
+			engine.Allow("trusted", []string{"*"}, `env.ip == "10.0.0.1"`)
+			Expect(engine.Evaluate(request, nil, "GetUser", user).Effect).To(Equal(server.PolicyDeny))
+		})
+	})
+
+	Describe("ParsePolicyRule()", func() {
+		It("should parse named rules", func() {
+			rule, err := server.ParsePolicyRule(`[owner-only] deny UpdateUser,DeleteUser if subject.id != resource.vars.id`)
+			Expect(err).To(BeNil())
+			Expect(rule.Name).To(Equal("owner-only"))
+			Expect(rule.Effect).To(Equal(server.PolicyDeny))
+			Expect(rule.Actions).To(Equal([]string{"UpdateUser", "DeleteUser"}))
+			Expect(rule.Condition).To(Equal("subject.id != resource.vars.id"))
+		})
+		It("should parse rules without condition", func() {
+			rule, err := server.ParsePolicyRule(`allow *`)
+			Expect(err).To(BeNil())
+			Expect(rule.Name).To(Equal("allow *"))
+			Expect(rule.Condition).To(Equal(""))
+		})
+		It("should return error for invalid rules", func() {
+			_, err := server.ParsePolicyRule(`deny`)
+			Expect(err).ToNot(BeNil())
+			_, err = server.ParsePolicyRule(`deny * when true`)
+			Expect(err).ToNot(BeNil())
+			_, err = server.ParsePolicyRule(`deny * if`)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("LoadPolicyEngine()", func() {
+		It("should load rules and skip comments", func() {
+			engine, err := server.LoadPolicyEngine(strings.NewReader(`
+				# users can only update themselves
+				[owner-only] deny UpdateUser if subject.id != resource.vars.id and not has_role("admin")
+
+				allow GetUser
+			`))
+			Expect(err).To(BeNil())
+			Expect(engine.Rules).To(HaveLen(2))
+			Expect(engine.HasAction("UpdateUser")).To(BeTrue())
+			Expect(engine.HasAction("ListUsers")).To(BeFalse())
+			Expect(engine.RequiresAllow("GetUser")).To(BeTrue())
+			Expect(engine.RequiresAllow("UpdateUser")).To(BeFalse())
+		})
+		It("should return error with the line number of invalid rules", func() {
+			_, err := server.LoadPolicyEngine(strings.NewReader("allow *\npermit *"))
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("line 2"))
+		})
+	})
+
+	Describe("AccessController", func() {
+		var router *server.Router
+		var ctx domain.IContext
+		var ac *server.AccessController
+		var document map[string]interface{}
+
+		allowAll := func(req *http.Request, user domain.IUser) (bool, string) {
+			return true, ""
+		}
+		handleStub := func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}
+		loadDocument := domain.ContextMiddlewareFunc(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+			ctx.Set(req, server.PolicyResourceKey, document)
+			next(w, req)
+		})
+		serve := func(method string, path string, user domain.IUser) int {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest(method, path, nil)
+			if user != nil {
+				ctx.SetCurrentUserCtx(request, user)
+			}
+			router.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+
+		BeforeEach(func() {
+			ctx = context.New()
+			ac = server.NewAccessController(ctx, renderer.New(&renderer.Options{}, renderer.JSON))
+			router = server.NewRouter(ctx, ac)
+			router.MustAddRoutes(&domain.Routes{
+				domain.Route{
+					Name:           "UpdateUser",
+					Method:         "PUT",
+					Pattern:        "/api/users/{id}",
+					DefaultVersion: "0.0",
+					RouteHandlers: domain.RouteHandlers{
+						"0.0": handleStub,
+					},
+					ACLHandler: allowAll,
+				},
+				domain.Route{
+					Name:                "UpdatePost",
+					Method:              "PUT",
+					Pattern:             "/api/posts/{id}",
+					DefaultVersion:      "0.0",
+					Middlewares:         []interface{}{loadDocument},
+					MiddlewaresPosition: domain.MiddlewaresOutsideACL,
+					RouteHandlers: domain.RouteHandlers{
+						"0.0": handleStub,
+					},
+					ACLHandler: allowAll,
+				},
+			})
+			engine, err := server.LoadPolicyEngine(strings.NewReader(`
+				[self] deny UpdateUser if subject.id != resource.vars.id and not has_role("admin")
+				[author] deny UpdatePost if resource.document.author != subject.id
+			`))
+			Expect(err).To(BeNil())
+			ac.UsePolicyEngine(engine)
+		})
+
+		It("should deny requests according to route variables", func() {
+			Expect(serve("PUT", "/api/users/user", user)).To(Equal(http.StatusOK))
+			Expect(serve("PUT", "/api/users/other", user)).To(Equal(http.StatusForbidden))
+			Expect(serve("PUT", "/api/users/other", admin)).To(Equal(http.StatusOK))
+		})
+		It("should deny requests according to the loaded document", func() {
+			document = map[string]interface{}{"author": "user"}
+			Expect(serve("PUT", "/api/posts/1", user)).To(Equal(http.StatusOK))
+			Expect(serve("PUT", "/api/posts/1", admin)).To(Equal(http.StatusForbidden))
+		})
+		It("should require a matching allow rule for actions that allow rules apply to", func() {
+			engine, err := server.LoadPolicyEngine(strings.NewReader(`
+				[admins] allow UpdateUser if has_role("admin")
+			`))
+			Expect(err).To(BeNil())
+			ac.UsePolicyEngine(engine)
+			Expect(serve("PUT", "/api/users/other", admin)).To(Equal(http.StatusOK))
+			Expect(serve("PUT", "/api/users/other", user)).To(Equal(http.StatusForbidden))
+			Expect(serve("PUT", "/api/users/other", nil)).To(Equal(http.StatusUnauthorized))
+			Expect(serve("PUT", "/api/posts/1", user)).To(Equal(http.StatusOK))
+		})
+		It("should still require the ACL handler to authorize requests", func() {
+			ac.Add(&domain.ACLMap{
+				"UpdateUser": func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, "handler"
+				},
+			})
+			result, message := ac.IsHTTPRequestAuthorized(request, nil, "UpdateUser", admin)
+			Expect(result).To(BeFalse())
+			Expect(message).To(Equal("handler"))
+		})
+	})
+})
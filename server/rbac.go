@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RBACAnonymousRole is the role of requests that has not been authenticated
+const RBACAnonymousRole = "anonymous"
+
+// RBACRole type declares a role, the roles it inherits permissions from, and its own permissions.
+// Permissions are strings, for eg: `users:write`; `users:*` grants every `users:` permission and `*` grants all.
+type RBACRole struct {
+	Name        string   `json:"name"`
+	Inherits    []string `json:"inherits,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// RBACPolicy type
+// Actions maps an action (a route name) to the permissions required to perform it.
+// RoleFromName converts a role name into the value passed to IUser.HasRole(); defaults to the name itself.
+type RBACPolicy struct {
+	Roles        map[string]*RBACRole
+	Actions      map[string][]string
+	RoleFromName func(name string) domain.IRole
+}
+
+// rbacPolicyFile is the file format of a RBAC policy
+type rbacPolicyFile struct {
+	Roles   []*RBACRole         `json:"roles"`
+	Actions map[string][]string `json:"actions"`
+}
+
+// NewRBACPolicy Returns a new RBACPolicy object
+func NewRBACPolicy() *RBACPolicy {
+	return &RBACPolicy{map[string]*RBACRole{}, map[string][]string{}, nil}
+}
+
+// LoadRBACPolicy Returns the RBACPolicy read from a JSON document, for eg:
+//
+//	{
+//	  "roles": [
+//	    { "name": "user", "permissions": ["users:read"] },
+//	    { "name": "admin", "inherits": ["user"], "permissions": ["users:*"] }
+//	  ],
+//	  "actions": { "ListUsers": ["users:read"], "UpdateUser": ["users:write"] }
+//	}
+func LoadRBACPolicy(r io.Reader) (*RBACPolicy, error) {
+	var file rbacPolicyFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding RBAC policy: %v", err.Error()))
+	}
+	policy := NewRBACPolicy()
+	for _, role := range file.Roles {
+		if role == nil || role.Name == "" {
+			return nil, errors.New("Error decoding RBAC policy: role is missing a name")
+		}
+		if _, ok := policy.Roles[role.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("Error decoding RBAC policy: duplicate role `%v`", role.Name))
+		}
+		policy.Roles[role.Name] = role
+	}
+	for action, permissions := range file.Actions {
+		policy.Require(action, permissions...)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// LoadRBACPolicyFile Returns the RBACPolicy read from a JSON file, see LoadRBACPolicy
+func LoadRBACPolicyFile(filename string) (*RBACPolicy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error loading RBAC policy: %v", err.Error()))
+	}
+	defer f.Close()
+	return LoadRBACPolicy(f)
+}
+
+// AddRole declares a role that inherits the permissions of the given roles
+func (p *RBACPolicy) AddRole(name string, inherits []string, permissions ...string) *RBACPolicy {
+	p.Roles[name] = &RBACRole{name, inherits, permissions}
+	return p
+}
+
+// Require declares the permissions required to perform an action
+func (p *RBACPolicy) Require(action string, permissions ...string) *RBACPolicy {
+	p.Actions[action] = append(p.Actions[action], permissions...)
+	return p
+}
+
+// Validate Returns an error if a role inherits from an undeclared role or if role inheritance has a cycle
+func (p *RBACPolicy) Validate() error {
+	for _, name := range p.roleNames() {
+		for _, parent := range p.Roles[name].Inherits {
+			if _, ok := p.Roles[parent]; !ok {
+				return errors.New(fmt.Sprintf("RBAC policy error, role `%v` inherits undeclared role `%v`", name, parent))
+			}
+		}
+		if p.hasInheritanceCycle(name, name, map[string]bool{}) {
+			return errors.New(fmt.Sprintf("RBAC policy error, role `%v` inherits itself", name))
+		}
+	}
+	return nil
+}
+
+func (p *RBACPolicy) hasInheritanceCycle(origin string, name string, visited map[string]bool) bool {
+	if visited[name] {
+		return false
+	}
+	visited[name] = true
+	role, ok := p.Roles[name]
+	if !ok {
+		return false
+	}
+	for _, parent := range role.Inherits {
+		if parent == origin || p.hasInheritanceCycle(origin, parent, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleNames Returns the declared role names in a stable order
+func (p *RBACPolicy) roleNames() []string {
+	names := []string{}
+	for name := range p.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RolePermissions Returns the permissions of a role, including inherited permissions
+func (p *RBACPolicy) RolePermissions(name string) []string {
+	permissions := []string{}
+	p.collectPermissions(name, map[string]bool{}, &permissions)
+	return permissions
+}
+
+func (p *RBACPolicy) collectPermissions(name string, visited map[string]bool, permissions *[]string) {
+	role, ok := p.Roles[name]
+	if !ok || visited[name] {
+		return
+	}
+	visited[name] = true
+	*permissions = append(*permissions, role.Permissions...)
+	for _, parent := range role.Inherits {
+		p.collectPermissions(parent, visited, permissions)
+	}
+}
+
+// UserRoles Returns the names of declared roles that the user has.
+// A `nil` user only has the RBACAnonymousRole role.
+func (p *RBACPolicy) UserRoles(user domain.IUser) []string {
+	if user == nil {
+		return []string{RBACAnonymousRole}
+	}
+	roles := []string{}
+	for _, name := range p.roleNames() {
+		var role domain.IRole = name
+		if p.RoleFromName != nil {
+			role = p.RoleFromName(name)
+		}
+		if user.HasRole(role) {
+			roles = append(roles, name)
+		}
+	}
+	return roles
+}
+
+// UserPermissions Returns the permissions granted to the user through its roles
+func (p *RBACPolicy) UserPermissions(user domain.IUser) []string {
+	permissions := []string{}
+	for _, role := range p.UserRoles(user) {
+		permissions = append(permissions, p.RolePermissions(role)...)
+	}
+	return permissions
+}
+
+// HasPermission Returns true if the user has been granted the permission
+func (p *RBACPolicy) HasPermission(user domain.IUser, permission string) bool {
+	for _, granted := range p.UserPermissions(user) {
+		if rbacPermissionMatch(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// rbacPermissionMatch Returns true if the granted permission covers the required permission
+func rbacPermissionMatch(granted string, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	if strings.HasSuffix(granted, ":*") {
+		return strings.HasPrefix(required, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+// Authorize Returns true if the user has all the permissions, else the message names the first missing permission
+func (p *RBACPolicy) Authorize(user domain.IUser, permissions ...string) (bool, string) {
+	for _, permission := range permissions {
+		if !p.HasPermission(user, permission) {
+			return false, fmt.Sprintf("Forbidden (403), requires `%v` permission", permission)
+		}
+	}
+	return true, ""
+}
+
+// HasAction Returns true if the policy declares the permissions required for the action
+func (p *RBACPolicy) HasAction(action string) bool {
+	_, ok := p.Actions[action]
+	return ok
+}
+
+// IsActionAuthorized Returns true if the user has the permissions required for the action
+func (p *RBACPolicy) IsActionAuthorized(action string, user domain.IUser) (bool, string) {
+	return p.Authorize(user, p.Actions[action]...)
+}
+
+// Requires Returns an ACLHandlerFunc that authorizes users with all the given permissions, for eg:
+//
+//	domain.Route{
+//	  Name:       "UpdateUser",
+//	  ...
+//	  ACLHandler: policy.Requires("users:write"),
+//	}
+func (p *RBACPolicy) Requires(permissions ...string) domain.ACLHandlerFunc {
+	return func(req *http.Request, user domain.IUser) (bool, string) {
+		return p.Authorize(user, permissions...)
+	}
+}
@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/middlewares/renderer"
+	"github.com/sogko/slumber/server"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// rbacTestUser implements IUser
+type rbacTestUser struct {
+	id    string
+	roles []string
+}
+
+func (user *rbacTestUser) GetID() string                              { return user.id }
+func (user *rbacTestUser) IsValid() bool                              { return true }
+func (user *rbacTestUser) IsCodeVerified(code string) bool            { return false }
+func (user *rbacTestUser) IsCredentialsVerified(password string) bool { return false }
+func (user *rbacTestUser) SetPassword(password string) error          { return nil }
+func (user *rbacTestUser) GenerateConfirmationCode()                  {}
+func (user *rbacTestUser) HasRole(r domain.IRole) bool {
+	for _, role := range user.roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+const testRBACPolicyJSON = `{
+	"roles": [
+		{ "name": "anonymous", "permissions": ["sessions:create"] },
+		{ "name": "user", "permissions": ["users:read"] },
+		{ "name": "editor", "inherits": ["user"], "permissions": ["users:write"] },
+		{ "name": "admin", "inherits": ["editor"], "permissions": ["*"] }
+	],
+	"actions": {
+		"ListUsers": ["users:read"],
+		"UpdateUser": ["users:write"],
+		"DeleteUser": ["users:delete"]
+	}
+}`
+
+var _ = Describe("RBACPolicy", func() {
+
+	var policy *server.RBACPolicy
+	user := &rbacTestUser{"user", []string{"user"}}
+	editor := &rbacTestUser{"editor", []string{"editor"}}
+	admin := &rbacTestUser{"admin", []string{"admin"}}
+	nobody := &rbacTestUser{"nobody", []string{"undeclared"}}
+
+	BeforeEach(func() {
+		var err error
+		policy, err = server.LoadRBACPolicy(strings.NewReader(testRBACPolicyJSON))
+		Expect(err).To(BeNil())
+	})
+
+	Describe("LoadRBACPolicy()", func() {
+		It("should load roles and actions", func() {
+			Expect(policy.Roles).To(HaveLen(4))
+			Expect(policy.Actions["UpdateUser"]).To(Equal([]string{"users:write"}))
+		})
+		It("should return error for invalid JSON", func() {
+			_, err := server.LoadRBACPolicy(strings.NewReader("{"))
+			Expect(err).ToNot(BeNil())
+		})
+		It("should return error for undeclared inherited roles", func() {
+			_, err := server.LoadRBACPolicy(strings.NewReader(`{"roles": [{"name": "a", "inherits": ["b"]}]}`))
+			Expect(err).ToNot(BeNil())
+		})
+		It("should return error for inheritance cycles", func() {
+			_, err := server.LoadRBACPolicy(strings.NewReader(`{"roles": [
+				{"name": "a", "inherits": ["b"]},
+				{"name": "b", "inherits": ["a"]}
+			]}`))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("RolePermissions()", func() {
+		It("should include inherited permissions", func() {
+			Expect(policy.RolePermissions("editor")).To(ConsistOf("users:write", "users:read"))
+		})
+	})
+
+	Describe("HasPermission()", func() {
+		It("should grant role permissions", func() {
+			Expect(policy.HasPermission(user, "users:read")).To(BeTrue())
+			Expect(policy.HasPermission(user, "users:write")).To(BeFalse())
+		})
+		It("should grant inherited permissions", func() {
+			Expect(policy.HasPermission(editor, "users:read")).To(BeTrue())
+			Expect(policy.HasPermission(editor, "users:write")).To(BeTrue())
+			Expect(policy.HasPermission(editor, "users:delete")).To(BeFalse())
+		})
+		It("should grant wildcard permissions", func() {
+			Expect(policy.HasPermission(admin, "users:delete")).To(BeTrue())
+			policy.AddRole("moderator", nil, "users:*")
+			moderator := &rbacTestUser{"moderator", []string{"moderator"}}
+			Expect(policy.HasPermission(moderator, "users:delete")).To(BeTrue())
+			Expect(policy.HasPermission(moderator, "sessions:delete")).To(BeFalse())
+		})
+		It("should grant anonymous role permissions to nil user", func() {
+			Expect(policy.HasPermission(nil, "sessions:create")).To(BeTrue())
+			Expect(policy.HasPermission(nil, "users:read")).To(BeFalse())
+		})
+		It("should not grant permissions to undeclared roles", func() {
+			Expect(policy.HasPermission(nobody, "users:read")).To(BeFalse())
+		})
+		It("should use RoleFromName to convert role names", func() {
+			policy.RoleFromName = func(name string) domain.IRole {
+				return "role-" + name
+			}
+			prefixed := &rbacTestUser{"prefixed", []string{"role-user"}}
+			Expect(policy.HasPermission(prefixed, "users:read")).To(BeTrue())
+			Expect(policy.HasPermission(user, "users:read")).To(BeFalse())
+		})
+	})
+
+	Describe("Requires()", func() {
+		It("should return an ACLHandlerFunc checking permissions", func() {
+			handler := policy.Requires("users:read", "users:write")
+			result, _ := handler(nil, editor)
+			Expect(result).To(BeTrue())
+			result, message := handler(nil, user)
+			Expect(result).To(BeFalse())
+			Expect(message).To(ContainSubstring("users:write"))
+		})
+	})
+
+	Describe("AccessController", func() {
+		var ac *server.AccessController
+		var request *http.Request
+		allowAll := func(req *http.Request, user domain.IUser) (bool, string) {
+			return true, ""
+		}
+
+		BeforeEach(func() {
+			ac = server.NewAccessController(context.New(), renderer.New(&renderer.Options{}, renderer.JSON))
+			ac.Add(&domain.ACLMap{
+				"ListUsers":  allowAll,
+				"UpdateUser": allowAll,
+				"GetUser":    allowAll,
+				"Forbidden": func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, "handler"
+				},
+			})
+			Expect(ac.UseRBACPolicy(policy)).To(BeNil())
+			request, _ = http.NewRequest("GET", "/api/users", nil)
+		})
+		It("should authorize actions according to the policy", func() {
+			result, _ := ac.IsHTTPRequestAuthorized(request, nil, "ListUsers", user)
+			Expect(result).To(BeTrue())
+			result, _ = ac.IsHTTPRequestAuthorized(request, nil, "UpdateUser", user)
+			Expect(result).To(BeFalse())
+			result, _ = ac.IsHTTPRequestAuthorized(request, nil, "UpdateUser", editor)
+			Expect(result).To(BeTrue())
+		})
+		It("should only use ACL handler for actions not in the policy", func() {
+			result, _ := ac.IsHTTPRequestAuthorized(request, nil, "GetUser", nobody)
+			Expect(result).To(BeTrue())
+			result, message := ac.IsHTTPRequestAuthorized(request, nil, "Forbidden", admin)
+			Expect(result).To(BeFalse())
+			Expect(message).To(Equal("handler"))
+		})
+		It("should load the policy from a file", func() {
+			f, _ := ioutil.TempFile("", "rbac")
+			defer os.Remove(f.Name())
+			f.WriteString(`{"roles": [{"name": "user"}], "actions": {"GetUser": ["users:read"]}}`)
+			f.Close()
+
+			Expect(ac.LoadRBACPolicyFile(f.Name())).To(BeNil())
+			result, _ := ac.IsHTTPRequestAuthorized(request, nil, "GetUser", user)
+			Expect(result).To(BeFalse())
+		})
+		It("should return error if the policy file does not exist", func() {
+			Expect(ac.LoadRBACPolicyFile("/does/not/exist.json")).ToNot(BeNil())
+		})
+	})
+})
@@ -6,18 +6,24 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sogko/slumber/domain"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 )
 
 // Router type
 type Router struct {
 	*mux.Router
-	ac  domain.IAccessController
-	ctx domain.IContext
+	ac       domain.IAccessController
+	ctx      domain.IContext
+	autoHEAD bool
+	routes   domain.Routes
 }
 
 // matcherFunc matches the handler to the correct API version based on its `accept` header
+// handlers are the route handlers wrapped with the route middlewares and ACL check, see routeHandlers()
 // TODO: refactor matcher function as server.Config
-func matcherFunc(r domain.Route, defaultHandler http.HandlerFunc, ctx domain.IContext, ac domain.IAccessController) func(r *http.Request, rm *mux.RouteMatch) bool {
+func matcherFunc(handlers domain.RouteHandlers, defaultHandler http.HandlerFunc) func(r *http.Request, rm *mux.RouteMatch) bool {
 	return func(req *http.Request, rm *mux.RouteMatch) bool {
 		acceptHeaders := domain.NewAcceptHeadersFromString(req.Header.Get("accept"))
 		foundHandler := defaultHandler
@@ -35,18 +41,27 @@ func matcherFunc(r domain.Route, defaultHandler http.HandlerFunc, ctx domain.ICo
 			if !hasVersion {
 				continue
 			}
-			if handler, ok := r.RouteHandlers[domain.RouteHandlerVersion(version)]; ok {
+			if handler, ok := handlers[domain.RouteHandlerVersion(version)]; ok {
 				// found handler for specified version
 				foundHandler = handler
 				break
 			}
 		}
 
-		if ac != nil {
-			rm.Handler = ac.NewContextHandler(r.Name, foundHandler)
-		} else {
-			rm.Handler = foundHandler
+		rm.Handler = foundHandler
+		return true
+	}
+}
+
+// headMatcherFunc matches a HEAD request to the handler of a GET route and discards the response body
+func headMatcherFunc(handlers domain.RouteHandlers, defaultHandler http.HandlerFunc) func(r *http.Request, rm *mux.RouteMatch) bool {
+	match := matcherFunc(handlers, defaultHandler)
+	return func(req *http.Request, rm *mux.RouteMatch) bool {
+		if !match(req, rm) {
+			return false
 		}
+		handler := rm.Handler
+		rm.Handler = headHandlerFunc(handler.ServeHTTP)
 		return true
 	}
 }
@@ -55,44 +70,229 @@ func matcherFunc(r domain.Route, defaultHandler http.HandlerFunc, ctx domain.ICo
 func NewRouter(ctx domain.IContext, ac domain.IAccessController) *Router {
 	router := mux.NewRouter().StrictSlash(true)
 
-	return &Router{router, ac, ctx}
+	return &Router{router, ac, ctx, false, domain.Routes{}}
+}
+
+// AutoHEAD defines the behaviour of AddRoutes for GET routes.
+// When true, a HEAD request to a GET route will be served by the GET handler with its response body discarded.
+// The response will still report its `Content-Length` and `ETag` headers.
+// Note that it only applies to routes added after it was set.
+func (router *Router) AutoHEAD(value bool) *Router {
+	router.autoHEAD = value
+	return router
 }
 
-func (router *Router) AddRoutes(routes *domain.Routes) *Router {
+// AddRoutes adds the routes to the router and registers their ACL handlers with the access controller.
+// The routes are validated before any of them is added, returning an error if
+//   - a route is missing the handler for its default version,
+//   - a route name has already been used,
+//   - a method and pattern pair has already been used, or
+//   - a route has no ACL handler while the router has an access controller.
+func (router *Router) AddRoutes(routes *domain.Routes) error {
 	if routes == nil {
-		return router
+		return nil
 	}
+	if err := router.validateRoutes(routes); err != nil {
+		return err
+	}
+	// compose route handlers before adding any route, composing fails on unknown middleware types
+	handlersList := []domain.RouteHandlers{}
 	for _, route := range *routes {
-
-		// get the defaultHandler for current route at init time so that we can safely panic
-		// if it was not defined
-		defaultHandler, ok := route.RouteHandlers[route.DefaultVersion]
-		if !ok {
-			// server/router instantiation error
-			// its safe to throw panic here
-			panic(errors.New(fmt.Sprintf("Routes definition error, missing default route handler for version `%v` in `%v`",
-				route.DefaultVersion, route.Name)))
+		handlers, err := router.routeHandlers(route)
+		if err != nil {
+			return err
 		}
+		handlersList = append(handlersList, handlers)
+	}
+	for i, route := range *routes {
+		handlers := handlersList[i]
+		defaultHandler := handlers[route.DefaultVersion]
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			MatcherFunc(matcherFunc(route, defaultHandler, router.ctx, router.ac))
+			MatcherFunc(matcherFunc(handlers, defaultHandler))
+		if router.autoHEAD && route.Method == "GET" {
+			router.
+				Methods("HEAD").
+				Path(route.Pattern).
+				MatcherFunc(headMatcherFunc(handlers, defaultHandler))
+		}
 		if router.ac != nil {
-			router.ac.AddHandler(route.Name, route.ACLHandler)
+			var err error
+			if route.ACLDecisionHandler != nil {
+				err = router.ac.AddDecisionHandler(route.Name, route.ACLDecisionHandler)
+			} else {
+				err = router.ac.AddHandler(route.Name, route.ACLHandler)
+			}
+			if err != nil {
+				return err
+			}
 		}
+		router.routes = append(router.routes, route)
+	}
+	return nil
+}
+
+// routeHandlers Returns the handlers of the route for each version, wrapped with the route middlewares
+// and the ACL check in the order defined by route.MiddlewaresPosition
+func (router *Router) routeHandlers(route domain.Route) (domain.RouteHandlers, error) {
+	handlers := domain.RouteHandlers{}
+	for version, handler := range route.RouteHandlers {
+		var err error
+		if route.MiddlewaresPosition == domain.MiddlewaresInsideACL {
+			handler, err = domain.ComposeMiddlewares(router.ctx, route.Middlewares, handler)
+		}
+		if router.ac != nil {
+			handler = router.ac.NewContextHandler(route.Name, handler)
+		}
+		if route.MiddlewaresPosition == domain.MiddlewaresOutsideACL {
+			handler, err = domain.ComposeMiddlewares(router.ctx, route.Middlewares, handler)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Routes definition error in `%v`: %v", route.Name, err.Error()))
+		}
+		handlers[version] = handler
+	}
+	return handlers, nil
+}
+
+// MustAddRoutes is like AddRoutes but panics if the routes definition is invalid
+func (router *Router) MustAddRoutes(routes *domain.Routes) *Router {
+	if err := router.AddRoutes(routes); err != nil {
+		// server/router instantiation error
+		// its safe to throw panic here
+		panic(err)
 	}
 	return router
 }
 
-func (router *Router) AddResources(resources ...domain.IResource) *Router {
+// AddResources adds the routes of each resource to the router, see AddRoutes
+func (router *Router) AddResources(resources ...domain.IResource) error {
 	for _, resource := range resources {
 		if resource.Routes() == nil {
-			// server/router instantiation error
-			// its safe to throw panic here
-			panic(errors.New(fmt.Sprintf("Routes definition missing: %v", resource)))
+			return errors.New(fmt.Sprintf("Routes definition missing: %v", resource))
+		}
+		if err := router.AddRoutes(resource.Routes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MustAddResources is like AddResources but panics if a routes definition is invalid
+func (router *Router) MustAddResources(resources ...domain.IResource) *Router {
+	if err := router.AddResources(resources...); err != nil {
+		// server/router instantiation error
+		// its safe to throw panic here
+		panic(err)
+	}
+	return router
+}
+
+// AddRouteGroups adds the routes of each group to the router, see AddRoutes
+func (router *Router) AddRouteGroups(groups ...*domain.RouteGroup) error {
+	for _, group := range groups {
+		routes, err := group.Flatten()
+		if err != nil {
+			return err
+		}
+		if err := router.AddRoutes(&routes); err != nil {
+			return err
 		}
-		router.AddRoutes(resource.Routes())
+	}
+	return nil
+}
+
+// MustAddRouteGroups is like AddRouteGroups but panics if a routes definition is invalid
+func (router *Router) MustAddRouteGroups(groups ...*domain.RouteGroup) *Router {
+	if err := router.AddRouteGroups(groups...); err != nil {
+		// server/router instantiation error
+		// its safe to throw panic here
+		panic(err)
 	}
 	return router
 }
+
+// routeVarNameRegExp matches the name of `{name}` and `{name:pattern}` route variables
+var routeVarNameRegExp = regexp.MustCompile(`\{[^{}:]+`)
+
+// routeKey Returns a key identifying a method and pattern pair.
+// Route variable names are ignored, `/users/{id}` and `/users/{name}` matches the same requests.
+func routeKey(method string, pattern string) string {
+	return strings.ToUpper(method) + " " + routeVarNameRegExp.ReplaceAllString(pattern, "{")
+}
+
+// routeKeys Returns the keys of the method and pattern pairs served by a route
+func (router *Router) routeKeys(route domain.Route) []string {
+	keys := []string{routeKey(route.Method, route.Pattern)}
+	if router.autoHEAD && route.Method == "GET" {
+		keys = append(keys, routeKey("HEAD", route.Pattern))
+	}
+	return keys
+}
+
+func (router *Router) validateRoutes(routes *domain.Routes) error {
+	names := map[string]bool{}
+	keys := map[string]string{}
+	for _, route := range router.routes {
+		names[route.Name] = true
+		for _, key := range router.routeKeys(route) {
+			keys[key] = route.Name
+		}
+	}
+	for _, route := range *routes {
+		if route.Name == "" {
+			return errors.New(fmt.Sprintf("Routes definition error, missing name for `%v %v`", route.Method, route.Pattern))
+		}
+		if _, ok := route.RouteHandlers[route.DefaultVersion]; !ok {
+			return errors.New(fmt.Sprintf("Routes definition error, missing default route handler for version `%v` in `%v`",
+				route.DefaultVersion, route.Name))
+		}
+		if names[route.Name] || (router.ac != nil && router.ac.HasAction(route.Name)) {
+			return errors.New(fmt.Sprintf("Routes definition error, duplicate route name `%v`", route.Name))
+		}
+		if router.ac != nil && route.ACLHandler == nil && route.ACLDecisionHandler == nil {
+			return errors.New(fmt.Sprintf("Routes definition error, missing ACL handler in `%v`", route.Name))
+		}
+		for _, key := range router.routeKeys(route) {
+			if name, ok := keys[key]; ok {
+				return errors.New(fmt.Sprintf("Routes definition error, `%v` in `%v` conflicts with `%v`", key, route.Name, name))
+			}
+			keys[key] = route.Name
+		}
+		names[route.Name] = true
+	}
+	return nil
+}
+
+// RouteInfo type describes a route added to the router
+type RouteInfo struct {
+	Name           string   `json:"name"`
+	Method         string   `json:"method"`
+	Pattern        string   `json:"pattern"`
+	Versions       []string `json:"versions"`
+	DefaultVersion string   `json:"defaultVersion"`
+	HasACLHandler  bool     `json:"hasACLHandler"`
+}
+
+// RouteInfos Returns the list of routes added to the router, in the order they were added
+func (router *Router) RouteInfos() []RouteInfo {
+	infos := []RouteInfo{}
+	for _, route := range router.routes {
+		versions := []string{}
+		for version := range route.RouteHandlers {
+			versions = append(versions, string(version))
+		}
+		sort.Strings(versions)
+		infos = append(infos, RouteInfo{
+			Name:           route.Name,
+			Method:         route.Method,
+			Pattern:        route.Pattern,
+			Versions:       versions,
+			DefaultVersion: string(route.DefaultVersion),
+			HasACLHandler:  route.ACLHandler != nil || route.ACLDecisionHandler != nil,
+		})
+	}
+	return infos
+}
@@ -141,54 +141,432 @@ var _ = Describe("Router", func() {
 	})
 
 	Describe("AddRoutes()", func() {
+		var ctx domain.IContext
+		var testRoute domain.Route
+		BeforeEach(func() {
+			ctx = context.New()
+			testRoute = domain.Route{
+				Name:           "Test",
+				Method:         "GET",
+				Pattern:        "/api/test/{id}",
+				DefaultVersion: "0.1",
+				RouteHandlers: domain.RouteHandlers{
+					"0.1": handleStub(ctx, "0.1"),
+				},
+				ACLHandler: handleAcl,
+			}
+		})
 		Context("Bad routes definition (undefined)", func() {
+			It("should not return error", func() {
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(nil)).To(BeNil())
+			})
 			It("should not panic", func() {
 				Expect(func() {
-					ctx := context.New()
 					router := server.NewRouter(ctx, nil)
-					router.AddRoutes(nil)
+					router.MustAddRoutes(nil)
 				}).ShouldNot(Panic())
 			})
 
 		})
 		Context("Bad routes definition (missing default version handler)", func() {
+			BeforeEach(func() {
+				testRoute.DefaultVersion = "missinghandler"
+			})
+			It("should return error", func() {
+				router := server.NewRouter(ctx, nil)
+				err := router.AddRoutes(&domain.Routes{testRoute})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(ContainSubstring("missing default route handler"))
+			})
 			It("should panic", func() {
 				Expect(func() {
-					ctx := context.New()
-					routes := &domain.Routes{
-						domain.Route{
-							Name:           "Test",
-							Method:         "GET",
-							Pattern:        "/api/test",
-							DefaultVersion: "missinghandler",
-							RouteHandlers: domain.RouteHandlers{
-								"0.1": handleStub(ctx, "0.1"),
-								"0.2": handleStub(ctx, "0.2"),
-								"0.3": handleStub(ctx, "0.3"),
-							},
-							ACLHandler: handleAcl,
-						},
-					}
 					router := server.NewRouter(ctx, nil)
-					router.AddRoutes(routes)
+					router.MustAddRoutes(&domain.Routes{testRoute})
 				}).Should(Panic())
 			})
 
 		})
+		Context("Bad routes definition (duplicate route name)", func() {
+			It("should return error for duplicates in the same routes", func() {
+				another := testRoute
+				another.Method = "POST"
+				router := server.NewRouter(ctx, nil)
+				err := router.AddRoutes(&domain.Routes{testRoute, another})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(ContainSubstring("duplicate route name `Test`"))
+			})
+			It("should return error for duplicates of routes already added", func() {
+				another := testRoute
+				another.Method = "POST"
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(&domain.Routes{testRoute})).To(BeNil())
+				Expect(router.AddRoutes(&domain.Routes{another})).ToNot(BeNil())
+			})
+			It("should not add any of the routes", func() {
+				another := testRoute
+				another.Pattern = "/api/another"
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(&domain.Routes{another, testRoute, testRoute})).ToNot(BeNil())
+				Expect(router.RouteInfos()).To(BeEmpty())
+			})
+			It("should not overwrite ACL handler of the first route", func() {
+				ac := server.NewAccessController(ctx, r)
+				router := server.NewRouter(ctx, ac)
+				Expect(router.AddRoutes(&domain.Routes{testRoute})).To(BeNil())
+
+				another := testRoute
+				another.Pattern = "/api/another"
+				another.ACLHandler = func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, "overwritten"
+				}
+				Expect(router.AddRoutes(&domain.Routes{another})).ToNot(BeNil())
+
+				request, _ = http.NewRequest("GET", "/api/test/1", nil)
+				_, message := ac.IsHTTPRequestAuthorized(request, ctx, "Test", nil)
+				Expect(message).ToNot(Equal("overwritten"))
+			})
+		})
+		Context("Bad routes definition (conflicting method and pattern)", func() {
+			It("should return error for identical method and pattern", func() {
+				another := testRoute
+				another.Name = "Another"
+				router := server.NewRouter(ctx, nil)
+				err := router.AddRoutes(&domain.Routes{testRoute, another})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(ContainSubstring("conflicts with `Test`"))
+			})
+			It("should return error for patterns that only differs by variable names", func() {
+				another := testRoute
+				another.Name = "Another"
+				another.Pattern = "/api/test/{name}"
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(&domain.Routes{testRoute, another})).ToNot(BeNil())
+			})
+			It("should return error for HEAD routes served by GET routes", func() {
+				another := testRoute
+				another.Name = "Another"
+				another.Method = "HEAD"
+				router := server.NewRouter(ctx, nil)
+				router.AutoHEAD(true)
+				Expect(router.AddRoutes(&domain.Routes{testRoute, another})).ToNot(BeNil())
+			})
+			It("should not return error for same pattern with different method", func() {
+				another := testRoute
+				another.Name = "Another"
+				another.Method = "POST"
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(&domain.Routes{testRoute, another})).To(BeNil())
+			})
+		})
+		Context("Bad routes definition (missing ACL handler)", func() {
+			BeforeEach(func() {
+				testRoute.ACLHandler = nil
+			})
+			It("should return error if router has an access controller", func() {
+				router := server.NewRouter(ctx, server.NewAccessController(ctx, r))
+				err := router.AddRoutes(&domain.Routes{testRoute})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(ContainSubstring("missing ACL handler"))
+			})
+			It("should not return error if router does not have an access controller", func() {
+				router := server.NewRouter(ctx, nil)
+				Expect(router.AddRoutes(&domain.Routes{testRoute})).To(BeNil())
+			})
+			It("should use the ACL decision handler if defined", func() {
+				testRoute.ACLDecisionHandler = domain.RequireAuthentication(func(req *http.Request, user domain.IUser) (bool, string) {
+					return false, ""
+				})
+				router := server.NewRouter(ctx, server.NewAccessController(ctx, r))
+				Expect(router.AddRoutes(&domain.Routes{testRoute})).To(BeNil())
+				Expect(router.RouteInfos()[0].HasACLHandler).To(BeTrue())
+
+				recorder = httptest.NewRecorder()
+				request, _ = http.NewRequest("GET", "/api/test/1", nil)
+				router.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+				Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			})
+		})
+	})
+	Describe("AutoHEAD()", func() {
+		var router *server.Router
+
+		BeforeEach(func() {
+			ctx := context.New()
+			routes := &domain.Routes{
+				domain.Route{
+					Name:           "TestGet",
+					Method:         "GET",
+					Pattern:        "/api/test",
+					DefaultVersion: "0.1",
+					RouteHandlers: domain.RouteHandlers{
+						"0.1": func(w http.ResponseWriter, req *http.Request) {
+							w.Write([]byte("hello world"))
+						},
+					},
+					ACLHandler: handleAcl,
+				},
+				domain.Route{
+					Name:           "TestPost",
+					Method:         "POST",
+					Pattern:        "/api/test/post",
+					DefaultVersion: "0.1",
+					RouteHandlers: domain.RouteHandlers{
+						"0.1": handleStub(ctx, "0.1"),
+					},
+					ACLHandler: handleAcl,
+				},
+			}
+			router = server.NewRouter(ctx, nil)
+			router.AutoHEAD(true)
+			router.AddRoutes(routes)
+
+			recorder = httptest.NewRecorder()
+		})
+
+		Context("when HEAD request is made to a GET route", func() {
+			It("should discard body and report Content-Length and ETag", func() {
+				request, _ = http.NewRequest("HEAD", "/api/test", nil)
+				router.ServeHTTP(recorder, request)
+
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.Len()).To(Equal(0))
+				Expect(recorder.Header().Get("Content-Length")).To(Equal("11"))
+				Expect(recorder.Header().Get("ETag")).ToNot(BeEmpty())
+			})
+			It("should report the same ETag for the same content", func() {
+				request, _ = http.NewRequest("HEAD", "/api/test", nil)
+				router.ServeHTTP(recorder, request)
+				anotherRecorder := httptest.NewRecorder()
+				router.ServeHTTP(anotherRecorder, request)
+
+				Expect(anotherRecorder.Header().Get("ETag")).To(Equal(recorder.Header().Get("ETag")))
+			})
+		})
+		Context("when HEAD request is made to a non-GET route", func() {
+			It("should not be served", func() {
+				request, _ = http.NewRequest("HEAD", "/api/test/post", nil)
+				router.ServeHTTP(recorder, request)
+
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+		Context("when AutoHEAD is disabled", func() {
+			It("should not serve HEAD requests", func() {
+				ctx := context.New()
+				router = server.NewRouter(ctx, nil)
+				router.AddRoutes(&domain.Routes{
+					domain.Route{
+						Name:           "TestGet",
+						Method:         "GET",
+						Pattern:        "/api/test",
+						DefaultVersion: "0.1",
+						RouteHandlers: domain.RouteHandlers{
+							"0.1": handleStub(ctx, "0.1"),
+						},
+						ACLHandler: handleAcl,
+					},
+				})
+				request, _ = http.NewRequest("HEAD", "/api/test", nil)
+				router.ServeHTTP(recorder, request)
+
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+	Describe("RouteInfos()", func() {
+		It("should list routes added to the router", func() {
+			ctx := context.New()
+			router := server.NewRouter(ctx, nil)
+			router.AddResources(test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{}))
+			router.AddRoutes(&domain.Routes{
+				domain.Route{
+					Name:           "TestVersions",
+					Method:         "GET",
+					Pattern:        "/api/test/versions",
+					DefaultVersion: "0.2",
+					RouteHandlers: domain.RouteHandlers{
+						"0.2": handleStub(ctx, "0.2"),
+						"0.1": handleStub(ctx, "0.1"),
+					},
+				},
+			})
+
+			infos := router.RouteInfos()
+			Expect(infos).To(HaveLen(3))
+			Expect(infos[0].Name).To(Equal("TestGetRoute"))
+			Expect(infos[0].Method).To(Equal("GET"))
+			Expect(infos[0].Pattern).To(Equal("/api/test"))
+			Expect(infos[0].HasACLHandler).To(BeTrue())
+			Expect(infos[1].Name).To(Equal("TestPostRoute"))
+			Expect(infos[2].Versions).To(Equal([]string{"0.1", "0.2"}))
+			Expect(infos[2].DefaultVersion).To(Equal("0.2"))
+			Expect(infos[2].HasACLHandler).To(BeFalse())
+		})
+	})
+	Describe("RoutesResource", func() {
+		var router *server.Router
+		var ctx domain.IContext
+		BeforeEach(func() {
+			ctx = context.New()
+			ac := server.NewAccessController(ctx, r)
+			router = server.NewRouter(ctx, ac)
+			recorder = httptest.NewRecorder()
+			request, _ = http.NewRequest("GET", "/api/_routes", nil)
+		})
+		Context("when ACLHandler is not specified", func() {
+			It("should deny request", func() {
+				router.AddResources(server.NewRoutesResource(ctx, r, router, &server.RoutesResourceOptions{}))
+				router.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+		Context("when ACLHandler allows request", func() {
+			It("should list routes", func() {
+				router.AddResources(server.NewRoutesResource(ctx, r, router, &server.RoutesResourceOptions{
+					ACLHandler: handleAcl,
+				}))
+				router.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.String()).To(ContainSubstring("ListRoutes"))
+			})
+		})
+	})
+	Describe("AddRouteGroups()", func() {
+		It("should serve routes of the group at its prefix", func() {
+			ctx := context.New()
+			router := server.NewRouter(ctx, server.NewAccessController(ctx, r))
+			err := router.AddRouteGroups(&domain.RouteGroup{
+				Prefix:     "/v2",
+				ACLHandler: handleAcl,
+				Resources: []domain.IResource{
+					test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{}),
+				},
+			})
+			Expect(err).To(BeNil())
+
+			recorder = httptest.NewRecorder()
+			request, _ = http.NewRequest("GET", "/v2/api/test", nil)
+			router.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+		It("should return error for invalid routes definition", func() {
+			ctx := context.New()
+			router := server.NewRouter(ctx, server.NewAccessController(ctx, r))
+			err := router.AddRouteGroups(&domain.RouteGroup{
+				Prefix: "/v2",
+				Routes: domain.Routes{
+					domain.Route{
+						Name:           "Test",
+						Method:         "GET",
+						Pattern:        "/api/test",
+						DefaultVersion: "0.1",
+						RouteHandlers: domain.RouteHandlers{
+							"0.1": handleStub(ctx, "0.1"),
+						},
+					},
+				},
+			})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+	Describe("Route middlewares", func() {
+		var router *server.Router
+		var calls []string
+		var route domain.Route
+		var allow bool
+
+		BeforeEach(func() {
+			calls = []string{}
+			allow = true
+			ctx := context.New()
+			router = server.NewRouter(ctx, server.NewAccessController(ctx, r))
+			route = domain.Route{
+				Name:           "Test",
+				Method:         "GET",
+				Pattern:        "/api/test",
+				DefaultVersion: "0.1",
+				RouteHandlers: domain.RouteHandlers{
+					"0.1": func(w http.ResponseWriter, req *http.Request) {
+						calls = append(calls, "handler")
+					},
+				},
+				ACLHandler: func(req *http.Request, user domain.IUser) (bool, string) {
+					calls = append(calls, "acl")
+					return allow, ""
+				},
+				Middlewares: []interface{}{
+					domain.MiddlewareFunc(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+						calls = append(calls, "first")
+						next(w, req)
+					}),
+					domain.ContextMiddlewareFunc(func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc, ctx domain.IContext) {
+						calls = append(calls, "second")
+						next(w, req)
+					}),
+				},
+			}
+			recorder = httptest.NewRecorder()
+			request, _ = http.NewRequest("GET", "/api/test", nil)
+		})
+		Context("when middlewares are inside ACL (default)", func() {
+			It("should run middlewares after ACL check", func() {
+				router.MustAddRoutes(&domain.Routes{route})
+				router.ServeHTTP(recorder, request)
+				Expect(calls).To(Equal([]string{"acl", "first", "second", "handler"}))
+			})
+			It("should not run middlewares if request is forbidden", func() {
+				allow = false
+				router.MustAddRoutes(&domain.Routes{route})
+				router.ServeHTTP(recorder, request)
+				Expect(calls).To(Equal([]string{"acl"}))
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+		Context("when middlewares are outside ACL", func() {
+			It("should run middlewares before ACL check", func() {
+				route.MiddlewaresPosition = domain.MiddlewaresOutsideACL
+				router.MustAddRoutes(&domain.Routes{route})
+				router.ServeHTTP(recorder, request)
+				Expect(calls).To(Equal([]string{"first", "second", "acl", "handler"}))
+			})
+		})
+		Context("when a middleware has an unknown type", func() {
+			It("should return error", func() {
+				route.Middlewares = []interface{}{"not a middleware"}
+				Expect(router.AddRoutes(&domain.Routes{route})).ToNot(BeNil())
+				Expect(router.RouteInfos()).To(BeEmpty())
+			})
+		})
 	})
 	Describe("AddResources()", func() {
 		Context("Valid IResource", func() {
+			It("should not return error", func() {
+				ctx := context.New()
+				router := server.NewRouter(ctx, nil)
+				testResources := test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{})
+				Expect(router.AddResources(testResources)).To(BeNil())
+			})
 			It("should not panic", func() {
 				Expect(func() {
 					ctx := context.New()
 					router := server.NewRouter(ctx, nil)
 					testResources := test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{})
-					router.AddResources(testResources)
+					router.MustAddResources(testResources)
 				}).ShouldNot(Panic())
 			})
 
 		})
 		Context("Invalid IResource", func() {
+			It("should return error", func() {
+				ctx := context.New()
+				router := server.NewRouter(ctx, nil)
+				testResources := test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{
+					NilRoutes: true,
+				})
+				Expect(router.AddResources(testResources)).ToNot(BeNil())
+			})
 			It("should panic", func() {
 				Expect(func() {
 					ctx := context.New()
@@ -196,11 +574,20 @@ var _ = Describe("Router", func() {
 					testResources := test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{
 						NilRoutes: true,
 					})
-					router.AddResources(testResources)
+					router.MustAddResources(testResources)
 				}).Should(Panic())
 			})
 
 		})
+		Context("Same IResource added twice", func() {
+			It("should return error", func() {
+				ctx := context.New()
+				router := server.NewRouter(ctx, nil)
+				testResources := test_helpers.NewTestResource(ctx, r, &test_helpers.TestResourceOptions{})
+				Expect(router.AddResources(testResources)).To(BeNil())
+				Expect(router.AddResources(testResources)).ToNot(BeNil())
+			})
+		})
 	})
 
 })
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/sogko/slumber/domain"
+	"net/http"
+)
+
+// RoutesResourceOptions type
+// ACLHandler defaults to forbidding every request; routes listing should only be exposed explicitly
+type RoutesResourceOptions struct {
+	Pattern    string
+	ACLHandler domain.ACLHandlerFunc
+}
+
+// RoutesResource implements IResource
+// It serves the list of routes added to the router, for debugging purposes
+type RoutesResource struct {
+	ctx      domain.IContext
+	renderer domain.IRenderer
+	router   *Router
+	options  *RoutesResourceOptions
+}
+
+// NewRoutesResource Returns a new RoutesResource object
+func NewRoutesResource(ctx domain.IContext, renderer domain.IRenderer, router *Router, options *RoutesResourceOptions) *RoutesResource {
+	if options.Pattern == "" {
+		options.Pattern = "/api/_routes"
+	}
+	if options.ACLHandler == nil {
+		options.ACLHandler = func(req *http.Request, user domain.IUser) (bool, string) {
+			return false, ""
+		}
+	}
+	return &RoutesResource{ctx, renderer, router, options}
+}
+
+func (resource *RoutesResource) Context() domain.IContext {
+	return resource.ctx
+}
+
+func (resource *RoutesResource) Routes() *domain.Routes {
+	return &domain.Routes{
+		domain.Route{
+			Name:           "ListRoutes",
+			Method:         "GET",
+			Pattern:        resource.options.Pattern,
+			DefaultVersion: "0.0",
+			RouteHandlers: domain.RouteHandlers{
+				"0.0": resource.HandleListRoutes,
+			},
+			ACLHandler: resource.options.ACLHandler,
+		},
+	}
+}
+
+func (resource *RoutesResource) Render(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	resource.renderer.JSON(w, status, v)
+}
+
+func (resource *RoutesResource) HandleListRoutes(w http.ResponseWriter, req *http.Request) {
+	resource.Render(w, req, http.StatusOK, resource.router.RouteInfos())
+}
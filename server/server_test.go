@@ -68,7 +68,7 @@ var _ = Describe("Server", func() {
 		router := server.NewRouter(s.Context, ac)
 
 		// add REST resources to router
-		router.AddResources(sessionsResource, usersResource)
+		router.MustAddResources(sessionsResource, usersResource)
 
 		// add middlewares
 		s.UseContextMiddleware(renderer)
@@ -91,7 +91,7 @@ var _ = Describe("Server", func() {
 
 			s.ServeHTTP(recorder, request)
 
-			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
 
 			// gracefully stops server
 			s.Stop()
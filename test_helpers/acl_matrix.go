@@ -0,0 +1,226 @@
+package test_helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/server"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ACLAnonymousUser is the name of the matrix column for requests without user
+const ACLAnonymousUser = "anonymous"
+
+// ACLFixtureUser type
+// implements IUser
+// HasRole() compares role names with the string representation of the role, for eg: users.RoleAdmin => "admin"
+type ACLFixtureUser struct {
+	Name  string   `json:"name"`
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+func (user *ACLFixtureUser) GetID() string {
+	if user.ID == "" {
+		return user.Name
+	}
+	return user.ID
+}
+func (user *ACLFixtureUser) IsValid() bool                              { return true }
+func (user *ACLFixtureUser) IsCodeVerified(code string) bool            { return false }
+func (user *ACLFixtureUser) IsCredentialsVerified(password string) bool { return false }
+func (user *ACLFixtureUser) SetPassword(password string) error          { return nil }
+func (user *ACLFixtureUser) GenerateConfirmationCode()                  {}
+func (user *ACLFixtureUser) HasRole(r domain.IRole) bool {
+	for _, role := range user.Roles {
+		if role == fmt.Sprint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadACLFixtureUsers Returns the fixture users read from a JSON list, for eg:
+//
+//	[
+//	  { "name": "admin", "roles": ["admin"] },
+//	  { "name": "user", "id": "5577d5d5b5a8a60c9f000001", "roles": ["user"] }
+//	]
+func LoadACLFixtureUsers(r io.Reader) ([]*ACLFixtureUser, error) {
+	users := []*ACLFixtureUser{}
+	if err := json.NewDecoder(r).Decode(&users); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding fixture users: %v", err.Error()))
+	}
+	for _, user := range users {
+		if user.Name == "" || user.Name == ACLAnonymousUser {
+			return nil, errors.New(fmt.Sprintf("Error decoding fixture users: invalid name `%v`", user.Name))
+		}
+	}
+	return users, nil
+}
+
+// LoadACLFixtureUsersFile Returns the fixture users read from a JSON file, see LoadACLFixtureUsers
+func LoadACLFixtureUsersFile(filename string) ([]*ACLFixtureUser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error loading fixture users: %v", err.Error()))
+	}
+	defer f.Close()
+	return LoadACLFixtureUsers(f)
+}
+
+// ACLMatrixError is the effect of a matrix cell whose ACL handler panicked, the message is the panic value
+const ACLMatrixError domain.ACLEffect = "error"
+
+// ACLMatrixCell is the decision for an action and a user
+type ACLMatrixCell struct {
+	Effect  domain.ACLEffect `json:"effect"`
+	Message string           `json:"message,omitempty"`
+}
+
+// ACLMatrix type
+// Cells maps an action (a route name) and a user name to the ACL decision
+type ACLMatrix struct {
+	Actions []string
+	Users   []string
+	Cells   map[string]map[string]ACLMatrixCell
+}
+
+var aclMatrixPathParamRegExp = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// NewACLMatrix Returns the ACL decisions of every action of the access controller for each fixture user,
+// and for a request without user (see ACLAnonymousUser).
+// Requests are built from the method and pattern of the route with the same name, route variables are replaced
+// by their names (for eg: `/api/users/{id}` => `/api/users/id`); router can be nil.
+// Note that route variables are not available through mux.Vars() during a dry run; a cell whose ACL handler
+// panics is reported with the ACLMatrixError effect.
+func NewACLMatrix(router *server.Router, ac *server.AccessController, users []*ACLFixtureUser) *ACLMatrix {
+	routes := map[string]server.RouteInfo{}
+	if router != nil {
+		for _, info := range router.RouteInfos() {
+			routes[info.Name] = info
+		}
+	}
+
+	matrix := &ACLMatrix{ac.Actions(), []string{ACLAnonymousUser}, map[string]map[string]ACLMatrixCell{}}
+	for _, user := range users {
+		matrix.Users = append(matrix.Users, user.Name)
+	}
+	for _, action := range matrix.Actions {
+		method, path := "GET", "/"
+		if info, ok := routes[action]; ok {
+			method, path = info.Method, aclMatrixPathParamRegExp.ReplaceAllString(info.Pattern, "$1")
+		}
+		matrix.Cells[action] = map[string]ACLMatrixCell{}
+		for i, name := range matrix.Users {
+			var user domain.IUser
+			if i > 0 {
+				user = users[i-1]
+			}
+			req, _ := http.NewRequest(method, path, nil)
+			matrix.Cells[action][name] = decideACLMatrixCell(ac, req, action, user)
+		}
+	}
+	return matrix
+}
+
+// decideACLMatrixCell Returns the ACL decision for a request, or an ACLMatrixError cell if the ACL handler panics
+func decideACLMatrixCell(ac *server.AccessController, req *http.Request, action string, user domain.IUser) (cell ACLMatrixCell) {
+	defer func() {
+		if r := recover(); r != nil {
+			cell = ACLMatrixCell{ACLMatrixError, fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+	decision := ac.DecideHTTPRequest(req, nil, action, user)
+	return ACLMatrixCell{decision.Effect, decision.Message}
+}
+
+// WriteTable writes the matrix as a table of actions by users
+func (matrix *ACLMatrix) WriteTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ACTION\t%v\n", strings.ToUpper(strings.Join(matrix.Users, "\t")))
+	for _, action := range matrix.Actions {
+		row := []string{action}
+		for _, user := range matrix.Users {
+			row = append(row, string(matrix.Cells[action][user].Effect))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// WriteJSON writes the matrix cells as indented JSON, the format of golden files
+func (matrix *ACLMatrix) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(matrix.Cells, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Diff Returns the differences between the matrix and the expected cells, sorted by action and user
+func (matrix *ACLMatrix) Diff(expected map[string]map[string]ACLMatrixCell) []string {
+	diffs := []string{}
+	keys := map[string]bool{}
+	for action, users := range matrix.Cells {
+		for user := range users {
+			keys[action+"\x00"+user] = true
+		}
+	}
+	for action, users := range expected {
+		for user := range users {
+			keys[action+"\x00"+user] = true
+		}
+	}
+	for key := range keys {
+		parts := strings.SplitN(key, "\x00", 2)
+		action, user := parts[0], parts[1]
+		got, hasGot := matrix.Cells[action][user]
+		want, hasWant := expected[action][user]
+		switch {
+		case !hasWant:
+			diffs = append(diffs, fmt.Sprintf("`%v` x `%v`: unexpected %v (%v)", action, user, got.Effect, got.Message))
+		case !hasGot:
+			diffs = append(diffs, fmt.Sprintf("`%v` x `%v`: missing, expected %v (%v)", action, user, want.Effect, want.Message))
+		case got != want:
+			diffs = append(diffs, fmt.Sprintf("`%v` x `%v`: expected %v (%v), got %v (%v)", action, user, want.Effect, want.Message, got.Effect, got.Message))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// AssertGoldenFile Returns an error listing the differences between the matrix and the golden file.
+// If update is true, the golden file is (re)written with the matrix instead.
+func (matrix *ACLMatrix) AssertGoldenFile(filename string, update bool) error {
+	if update {
+		f, err := os.Create(filename)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error writing ACL matrix golden file: %v", err.Error()))
+		}
+		defer f.Close()
+		return matrix.WriteJSON(f)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error reading ACL matrix golden file: %v", err.Error()))
+	}
+	var expected map[string]map[string]ACLMatrixCell
+	if err := json.Unmarshal(b, &expected); err != nil {
+		return errors.New(fmt.Sprintf("Error decoding ACL matrix golden file: %v", err.Error()))
+	}
+	if diffs := matrix.Diff(expected); len(diffs) > 0 {
+		return errors.New(fmt.Sprintf("ACL matrix does not match `%v`:\n%v", filename, strings.Join(diffs, "\n")))
+	}
+	return nil
+}
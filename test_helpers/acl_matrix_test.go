@@ -0,0 +1,168 @@
+package test_helpers_test
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"github.com/sogko/slumber/middlewares/context"
+	"github.com/sogko/slumber/middlewares/renderer"
+	"github.com/sogko/slumber/server"
+	"github.com/sogko/slumber/test_helpers"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var _ = Describe("ACLMatrix", func() {
+
+	var router *server.Router
+	var ac *server.AccessController
+	var users []*test_helpers.ACLFixtureUser
+	var paths []string
+
+	handleStub := func(w http.ResponseWriter, req *http.Request) {}
+
+	BeforeEach(func() {
+		ctx := context.New()
+		ac = server.NewAccessController(ctx, renderer.New(&renderer.Options{}, renderer.JSON))
+		router = server.NewRouter(ctx, ac)
+		paths = []string{}
+		router.MustAddRoutes(&domain.Routes{
+			domain.Route{
+				Name:           "ListUsers",
+				Method:         "GET",
+				Pattern:        "/api/users",
+				DefaultVersion: "0.0",
+				RouteHandlers:  domain.RouteHandlers{"0.0": handleStub},
+				ACLHandler: func(req *http.Request, user domain.IUser) (bool, string) {
+					return true, ""
+				},
+			},
+			domain.Route{
+				Name:           "UpdateUser",
+				Method:         "PUT",
+				Pattern:        "/api/users/{id:[0-9a-f]+}",
+				DefaultVersion: "0.0",
+				RouteHandlers:  domain.RouteHandlers{"0.0": handleStub},
+				ACLHandler: func(req *http.Request, user domain.IUser) (bool, string) {
+					paths = append(paths, req.Method+" "+req.URL.Path)
+					if user == nil || !user.HasRole("admin") {
+						return false, "admins only"
+					}
+					return true, ""
+				},
+			},
+			domain.Route{
+				Name:           "GetProfile",
+				Method:         "GET",
+				Pattern:        "/api/profile",
+				DefaultVersion: "0.0",
+				RouteHandlers:  domain.RouteHandlers{"0.0": handleStub},
+				ACLDecisionHandler: domain.RequireAuthentication(func(req *http.Request, user domain.IUser) (bool, string) {
+					return user != nil, ""
+				}),
+			},
+		})
+
+		var err error
+		users, err = test_helpers.LoadACLFixtureUsers(strings.NewReader(`[
+			{"name": "admin", "roles": ["admin"]},
+			{"name": "user", "id": "1", "roles": ["user"]}
+		]`))
+		Expect(err).To(BeNil())
+	})
+
+	Describe("LoadACLFixtureUsers()", func() {
+		It("should load users", func() {
+			Expect(users).To(HaveLen(2))
+			Expect(users[0].GetID()).To(Equal("admin"))
+			Expect(users[1].GetID()).To(Equal("1"))
+			Expect(users[0].HasRole("admin")).To(BeTrue())
+			Expect(users[1].HasRole("admin")).To(BeFalse())
+		})
+		It("should return error for invalid users", func() {
+			_, err := test_helpers.LoadACLFixtureUsers(strings.NewReader(`[{"roles": ["admin"]}]`))
+			Expect(err).ToNot(BeNil())
+			_, err = test_helpers.LoadACLFixtureUsers(strings.NewReader(`[{"name": "anonymous"}]`))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("NewACLMatrix()", func() {
+		It("should decide every action for every user", func() {
+			matrix := test_helpers.NewACLMatrix(router, ac, users)
+			Expect(matrix.Actions).To(Equal([]string{"GetProfile", "ListUsers", "UpdateUser"}))
+			Expect(matrix.Users).To(Equal([]string{"anonymous", "admin", "user"}))
+			Expect(matrix.Cells["ListUsers"]["anonymous"].Effect).To(Equal(domain.ACLAllow))
+			Expect(matrix.Cells["UpdateUser"]["admin"].Effect).To(Equal(domain.ACLAllow))
+			Expect(matrix.Cells["UpdateUser"]["user"]).To(Equal(test_helpers.ACLMatrixCell{domain.ACLDeny, "admins only"}))
+			Expect(matrix.Cells["UpdateUser"]["anonymous"]).To(Equal(test_helpers.ACLMatrixCell{domain.ACLRequireAuthentication, "admins only"}))
+			Expect(matrix.Cells["GetProfile"]["anonymous"].Effect).To(Equal(domain.ACLRequireAuthentication))
+			Expect(matrix.Cells["GetProfile"]["user"].Effect).To(Equal(domain.ACLAllow))
+		})
+		It("should build requests from the route method and pattern", func() {
+			test_helpers.NewACLMatrix(router, ac, users)
+			Expect(paths).To(ContainElement("PUT /api/users/id"))
+		})
+		It("should report ACL handlers that panic", func() {
+			ac.Add(&domain.ACLMap{
+				"DeleteUser": func(req *http.Request, user domain.IUser) (bool, string) {
+					var vars map[string]string
+					vars["id"] = user.GetID()
+					return true, ""
+				},
+			})
+			matrix := test_helpers.NewACLMatrix(router, ac, users)
+			Expect(matrix.Cells["DeleteUser"]["anonymous"].Effect).To(Equal(test_helpers.ACLMatrixError))
+			Expect(matrix.Cells["DeleteUser"]["anonymous"].Message).To(ContainSubstring("panic"))
+			Expect(matrix.Cells["DeleteUser"]["admin"].Effect).To(Equal(test_helpers.ACLMatrixError))
+			Expect(matrix.Cells["ListUsers"]["anonymous"].Effect).To(Equal(domain.ACLAllow))
+		})
+	})
+
+	Describe("WriteTable()", func() {
+		It("should write a table of actions by users", func() {
+			var b bytes.Buffer
+			test_helpers.NewACLMatrix(router, ac, users).WriteTable(&b)
+			lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+			Expect(lines).To(HaveLen(4))
+			Expect(strings.Fields(lines[0])).To(Equal([]string{"ACTION", "ANONYMOUS", "ADMIN", "USER"}))
+			Expect(strings.Fields(lines[3])).To(Equal([]string{"UpdateUser", "require-authentication", "allow", "deny"}))
+		})
+	})
+
+	Describe("AssertGoldenFile()", func() {
+		var filename string
+		BeforeEach(func() {
+			f, _ := ioutil.TempFile("", "acl-matrix")
+			f.Close()
+			filename = f.Name()
+		})
+		AfterEach(func() {
+			os.Remove(filename)
+		})
+		It("should match the golden file it has written", func() {
+			matrix := test_helpers.NewACLMatrix(router, ac, users)
+			Expect(matrix.AssertGoldenFile(filename, true)).To(BeNil())
+			Expect(matrix.AssertGoldenFile(filename, false)).To(BeNil())
+		})
+		It("should return error listing the differences", func() {
+			Expect(test_helpers.NewACLMatrix(router, ac, users).AssertGoldenFile(filename, true)).To(BeNil())
+			ac.Add(&domain.ACLMap{
+				"ListUsers": func(req *http.Request, user domain.IUser) (bool, string) {
+					return user != nil, ""
+				},
+			})
+			err := test_helpers.NewACLMatrix(router, ac, users).AssertGoldenFile(filename, false)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("`ListUsers` x `anonymous`: expected allow (OK), got require-authentication (Unauthorized (401))"))
+			Expect(err.Error()).ToNot(ContainSubstring("`ListUsers` x `admin`"))
+		})
+		It("should return error if the golden file does not exist", func() {
+			matrix := test_helpers.NewACLMatrix(router, ac, users)
+			Expect(matrix.AssertGoldenFile("/does/not/exist.json", false)).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,150 @@
+package test_helpers
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sogko/slumber/domain"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// DatabaseUser type
+// is a document of the `users` collection in the specs of IDatabase implementations
+type DatabaseUser struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	Name      string        `bson:"name"`
+	Email     string        `bson:"email,omitempty"`
+	Age       int           `bson:"age"`
+	Tags      []string      `bson:"tags,omitempty"`
+	CreatedAt time.Time     `bson:"createdAt,omitempty"`
+}
+
+// DatabaseUsers Returns the users that InsertDatabaseUsers() inserts
+func DatabaseUsers() []DatabaseUser {
+	return []DatabaseUser{
+		{Name: "alice", Email: "alice@example.com", Age: 30, Tags: []string{"admin", "staff"}},
+		{Name: "bob", Email: "bob@example.com", Age: 25, Tags: []string{"staff"}},
+		{Name: "carol", Age: 35},
+		{Name: "dave", Age: 25},
+	}
+}
+
+// InsertDatabaseUsers inserts the users of DatabaseUsers() in the `users` collection
+func InsertDatabaseUsers(ctx context.Context, db domain.IDatabase) {
+	for _, user := range DatabaseUsers() {
+		Expect(db.Insert(ctx, "users", &user)).To(BeNil())
+	}
+}
+
+// UserNames Returns the names of the users
+func UserNames(users []DatabaseUser) []string {
+	result := []string{}
+	for _, user := range users {
+		result = append(result, user.Name)
+	}
+	return result
+}
+
+// FindUserNames Returns the names of the users that match the query, sorted
+func FindUserNames(ctx context.Context, db domain.IDatabase, query domain.Query, sort string) []string {
+	var users []DatabaseUser
+	Expect(db.FindAll(ctx, "users", query, &users, 0, sort)).To(BeNil())
+	return UserNames(users)
+}
+
+// DescribeDatabaseConformance registers the specs that every IDatabase implementation passes, in a top-level `var _ =`.
+// open is called before each spec, it Returns an empty database and the function that closes it after the spec.
+func DescribeDatabaseConformance(name string, open func() (domain.IDatabase, func())) bool {
+	return Describe(name+" conformance", func() {
+
+		var db domain.IDatabase
+		var closeDB func()
+		ctx := context.Background()
+
+		findNames := func(query domain.Query, sort string) []string {
+			return FindUserNames(ctx, db, query, sort)
+		}
+
+		BeforeEach(func() {
+			db, closeDB = open()
+			InsertDatabaseUsers(ctx, db)
+		})
+		AfterEach(func() {
+			closeDB()
+		})
+
+		Describe("WithTransaction()", func() {
+			It("should commit the writes of fn", func() {
+				Expect(db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+					if err := tx.Insert(ctx, "users", &DatabaseUser{Name: "erin", Age: 40}); err != nil {
+						return err
+					}
+					if err := tx.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+						Update: domain.Query{"$set": domain.Query{"age": 26}},
+					}, nil); err != nil {
+						return err
+					}
+					return tx.RemoveOne(ctx, "users", domain.Query{"name": "carol"})
+				})).To(BeNil())
+				Expect(findNames(nil, "name")).To(Equal([]string{"alice", "bob", "dave", "erin"}))
+				Expect(findNames(domain.Query{"age": 26}, "")).To(Equal([]string{"bob"}))
+			})
+			It("should roll back the writes of fn after a partial failure", func() {
+				Expect(db.EnsureIndex(ctx, "users", domain.Index{Fields: []string{"name"}, Unique: true})).To(BeNil())
+				err := db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+					if err := tx.Insert(ctx, "users", &DatabaseUser{Name: "erin", Age: 40}); err != nil {
+						return err
+					}
+					if err := tx.Update(ctx, "users", domain.Query{"name": "bob"}, domain.Change{
+						Update: domain.Query{"$set": domain.Query{"age": 26}},
+					}, nil); err != nil {
+						return err
+					}
+					if err := tx.Update(ctx, "users", domain.Query{"name": "frank"}, domain.Change{
+						Update: domain.Query{"$set": domain.Query{"age": 50}},
+						Upsert: true,
+					}, nil); err != nil {
+						return err
+					}
+					if _, err := tx.UpdateAll(ctx, "users", domain.Query{"age": 35}, domain.Query{"$inc": domain.Query{"age": 1}}); err != nil {
+						return err
+					}
+					if err := tx.RemoveAll(ctx, "users", domain.Query{"name": "dave"}); err != nil {
+						return err
+					}
+					return tx.Insert(ctx, "users", &DatabaseUser{Name: "alice"})
+				})
+				Expect(err).To(Equal(domain.ErrDuplicateKey))
+				Expect(findNames(nil, "name")).To(Equal([]string{"alice", "bob", "carol", "dave"}))
+				Expect(findNames(domain.Query{"age": 25}, "name")).To(Equal([]string{"bob", "dave"}))
+				Expect(findNames(domain.Query{"age": 35}, "")).To(Equal([]string{"carol"}))
+			})
+			It("should commit nested calls with the transaction", func() {
+				Expect(db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+					return tx.WithTransaction(ctx, func(nested domain.IDatabase) error {
+						return nested.Insert(ctx, "users", &DatabaseUser{Name: "frank"})
+					})
+				})).To(BeNil())
+				Expect(findNames(domain.Query{"name": "frank"}, "")).To(Equal([]string{"frank"}))
+			})
+			It("should roll back nested calls with the transaction", func() {
+				abort := errors.New("abort")
+				err := db.WithTransaction(ctx, func(tx domain.IDatabase) error {
+					if err := tx.Insert(ctx, "users", &DatabaseUser{Name: "erin"}); err != nil {
+						return err
+					}
+					if err := tx.WithTransaction(ctx, func(nested domain.IDatabase) error {
+						return nested.Insert(ctx, "users", &DatabaseUser{Name: "frank"})
+					}); err != nil {
+						return err
+					}
+					return abort
+				})
+				Expect(err).To(Equal(abort))
+				Expect(findNames(nil, "name")).To(Equal([]string{"alice", "bob", "carol", "dave"}))
+			})
+		})
+	})
+}
@@ -105,7 +105,7 @@ func NewTestServer(options *TestServerOptions) *TestServer {
 
 func (ts *TestServer) AddResources(resources ...domain.IResource) {
 	for _, resource := range resources {
-		ts.Router.AddResources(resource)
+		ts.Router.MustAddResources(resource)
 	}
 }
 func (ts *TestServer) AddMiddlewares(middlewares ...interface{}) {